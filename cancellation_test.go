@@ -0,0 +1,54 @@
+package aetest
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCancelledSubmitDoesNotStoreOrder(t *testing.T) {
+	items, discounts, _ := NewStore()
+	orders := make(OrderStore)
+	svc := New(items, discounts, orders)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := svc.SimpleSummary(ctx, goodOrderRequest)
+	require.ErrorIs(t, err, ErrRequestCancelled)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Empty(t, orders)
+
+	_, err = svc.GetAllOrders(ctx)
+	require.ErrorIs(t, err, ErrRequestCancelled)
+}
+
+func TestDeadlineExceeded(t *testing.T) {
+	items, discounts, _ := NewStore()
+	orders := make(OrderStore)
+	svc := New(items, discounts, orders)
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	_, err := svc.Quote(ctx, goodOrderRequest)
+	require.ErrorIs(t, err, ErrRequestCancelled)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestRequestTimeout(t *testing.T) {
+	items, discounts, _ := NewStore()
+	orders := make(OrderStore)
+	router := NewOrdersRouter(
+		New(items, discounts, orders),
+		WithRequestTimeout(time.Nanosecond),
+	)
+
+	// The deadline has passed by the time the Service is called.
+	response := postJSON(t, router, "/submit-order", goodOrderRequest)
+	require.Equal(t, http.StatusGatewayTimeout, response.StatusCode)
+	require.Empty(t, orders)
+}
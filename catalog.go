@@ -0,0 +1,259 @@
+package aetest
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// normalizeName returns the form of an item name used for matching, names
+// match ignoring case and extra whitespace.
+func normalizeName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// normalizeSKU returns the form in which SKUs are stored, SKUs match ignoring
+// case.
+func normalizeSKU(sku string) string {
+	return strings.ToUpper(strings.TrimSpace(sku))
+}
+
+// skuFromName derives the SKU of an item added to the catalog by name alone,
+// the name is upper cased with each run of other characters replaced by a
+// hyphen, e.g. "Green tea (loose)" becomes "GREEN-TEA-LOOSE".
+func skuFromName(name string) string {
+	var sku strings.Builder
+	hyphen := false
+	for _, r := range strings.ToUpper(name) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			if hyphen && sku.Len() > 0 {
+				sku.WriteByte('-')
+			}
+			sku.WriteRune(r)
+			hyphen = false
+		} else {
+			hyphen = true
+		}
+	}
+	if sku.Len() == 0 {
+		return "ITEM"
+	}
+	if sku.Len() > 56 {
+		return strings.TrimRight(sku.String()[:56], "-")
+	}
+	return sku.String()
+}
+
+// Find returns the SKU and item that an order refers to. The SKU is used when
+// given, otherwise the name is matched against the names and aliases of every
+// item.
+func (store ItemStore) Find(sku string, name string) (string, StoreItem, bool) {
+	if sku != "" {
+		sku = normalizeSKU(sku)
+		item, ok := store[sku]
+		return sku, item, ok
+	}
+
+	name = normalizeName(name)
+	for sku, item := range store {
+		if item.hasName(name) {
+			return sku, item, true
+		}
+	}
+	return "", StoreItem{}, false
+}
+
+// hasName reports whether the normalized name is the item's name or one of
+// its aliases.
+func (item StoreItem) hasName(name string) bool {
+	if normalizeName(item.Name) == name {
+		return true
+	}
+	for _, alias := range item.Aliases {
+		if normalizeName(alias) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// nameOwner returns the SKU of an item other than except that uses name as
+// its name or an alias, names must be unique so that orders by name are never
+// ambiguous.
+func (store ItemStore) nameOwner(name string, except string) (string, bool) {
+	name = normalizeName(name)
+	for sku, item := range store {
+		if sku != except && item.hasName(name) {
+			return sku, true
+		}
+	}
+	return "", false
+}
+
+// newSKU derives a SKU from the name that is not yet used by any item, a
+// numeric suffix is added when the derived SKU is taken.
+func (store ItemStore) newSKU(name string) string {
+	base := skuFromName(name)
+	sku := base
+	for i := 2; ; i++ {
+		if _, ok := store[sku]; !ok {
+			return sku
+		}
+		sku = base + "-" + strconv.Itoa(i)
+	}
+}
+
+// toCatalogItem converts a stored item to its CatalogItem with the given
+// cost, as the cost depends on when the item is looked at.
+func (item StoreItem) toCatalogItem(sku string, cost int) CatalogItem {
+	var aliases []string
+	if len(item.Aliases) > 0 {
+		aliases = append(aliases, item.Aliases...)
+	}
+	return CatalogItem{
+		SKU:      sku,
+		ItemName: item.Name,
+		Aliases:  aliases,
+		Cost:     cost,
+	}
+}
+
+// maxSuggestions is the largest number of suggestions made for an unknown
+// item.
+const maxSuggestions = 3
+
+// UnknownItem is a cart line that refers to an item that does not exist,
+// along with the names, or SKUs, of the closest matching items.
+type UnknownItem struct {
+	// Line is the index of the line in the cart.
+	Line        int      `json:"line"`
+	ItemName    string   `json:"item_name,omitempty"`
+	SKU         string   `json:"sku,omitempty"`
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+// UnknownItemsError is returned when one or more cart lines refer to items
+// that do not exist. It matches ErrItemDoesNotExist with `errors.Is`.
+type UnknownItemsError struct {
+	Items []UnknownItem
+}
+
+func (e *UnknownItemsError) Error() string {
+	var msg strings.Builder
+	msg.WriteString(ErrItemDoesNotExist.Error())
+	for i, item := range e.Items {
+		if i == 0 {
+			msg.WriteString(": ")
+		} else {
+			msg.WriteString(", ")
+		}
+
+		ref := item.ItemName
+		if item.SKU != "" {
+			ref = "sku " + item.SKU
+		}
+		msg.WriteString(strconv.Quote(ref))
+
+		if len(item.Suggestions) > 0 {
+			quoted := make([]string, len(item.Suggestions))
+			for j, suggestion := range item.Suggestions {
+				quoted[j] = strconv.Quote(suggestion)
+			}
+			msg.WriteString(" (did you mean " + strings.Join(quoted, " or ") + "?)")
+		}
+	}
+	return msg.String()
+}
+
+func (e *UnknownItemsError) Unwrap() error {
+	return ErrItemDoesNotExist
+}
+
+// Suggest returns the names of the items closest to name, or the SKUs closest
+// to sku when a SKU is given. Only close matches are suggested, within an edit
+// distance of a third of the length of the name, closest first.
+func (store ItemStore) Suggest(sku string, name string) []string {
+	type match struct {
+		value    string
+		distance int
+	}
+	var matches []match
+
+	if sku != "" {
+		sku = normalizeSKU(sku)
+		limit := maxDistance(sku)
+		for candidate := range store {
+			if d := levenshtein(sku, candidate); d <= limit {
+				matches = append(matches, match{candidate, d})
+			}
+		}
+	} else {
+		name = normalizeName(name)
+		limit := maxDistance(name)
+		for _, item := range store {
+			// An item is suggested once, by its catalog name, however
+			// many of its names are close.
+			best := limit + 1
+			for _, candidate := range append([]string{item.Name}, item.Aliases...) {
+				if d := levenshtein(name, normalizeName(candidate)); d < best {
+					best = d
+				}
+			}
+			if best <= limit {
+				matches = append(matches, match{item.Name, best})
+			}
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].distance != matches[j].distance {
+			return matches[i].distance < matches[j].distance
+		}
+		return matches[i].value < matches[j].value
+	})
+
+	var suggestions []string
+	for i := 0; i < len(matches) && i < maxSuggestions; i++ {
+		suggestions = append(suggestions, matches[i].value)
+	}
+	return suggestions
+}
+
+// maxDistance is the largest edit distance at which a name is suggested, at
+// least one edit is allowed so short names with a typo still have a match.
+func maxDistance(name string) int {
+	if limit := utf8.RuneCountInString(name) / 3; limit > 1 {
+		return limit
+	}
+	return 1
+}
+
+// levenshtein returns the edit distance between a and b, the number of single
+// rune insertions, deletions or substitutions needed to turn a into b.
+func levenshtein(a string, b string) int {
+	source, target := []rune(a), []rune(b)
+
+	// Only the previous row of the distance matrix is needed to calculate
+	// the next.
+	previous := make([]int, len(target)+1)
+	current := make([]int, len(target)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(source); i++ {
+		current[0] = i
+		for j := 1; j <= len(target); j++ {
+			substitution := previous[j-1]
+			if source[i-1] != target[j-1] {
+				substitution++
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, substitution)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(target)]
+}
@@ -0,0 +1,288 @@
+package aetest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// postJSON serializes body as JSON and POSTs it to the supplied router path.
+func postJSON(t *testing.T, router http.Handler, path string, body interface{}) *http.Response {
+	JSON, err := json.Marshal(body)
+	require.NoError(t, err)
+	request := httptest.NewRequest("POST", path, bytes.NewReader(JSON))
+	request.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, request)
+	return rec.Result()
+}
+
+func TestQuoteDoesNotStoreOrder(t *testing.T) {
+	items, discounts, _ := NewStore()
+	orders := make(OrderStore)
+	router := NewOrdersRouter(New(items, discounts, orders))
+
+	response := postJSON(t, router, "/quote-order", goodOrderRequest)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	var quote OrderSummary
+	err := json.NewDecoder(response.Body).Decode(&quote)
+	require.NoError(t, err)
+	require.Equal(t, 110, quote.TotalCost, "incorrect quote total")
+	require.Empty(t, quote.OrderID, "quotes are not assigned an order_id")
+	require.Empty(t, orders, "quotes must not be stored")
+}
+
+func TestCatalogAdmin(t *testing.T) {
+	items, discounts, orders := NewStore()
+	svc := New(items, discounts, orders)
+	router := NewOrdersRouter(svc)
+
+	// Add a new item and order it.
+	response := postJSON(t, router, "/set-item-price", SetItemPriceRequest{ItemName: "Pears", Cost: 40})
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	catalog, err := svc.GetCatalog(context.Background())
+	require.NoError(t, err)
+	require.Contains(t, catalog.Items, CatalogItem{SKU: "PEARS", ItemName: "Pears", Cost: 40})
+
+	response = postJSON(t, router, "/submit-order", OrderRequest{
+		Cart: []Item{{ItemName: "Pears", Quantity: 2}},
+	})
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	// A negative cost is rejected.
+	response = postJSON(t, router, "/set-item-price", SetItemPriceRequest{ItemName: "Pears", Cost: -1})
+	require.Equal(t, http.StatusBadRequest, response.StatusCode)
+
+	// Removed items can no longer be ordered.
+	response = postJSON(t, router, "/remove-item", RemoveItemRequest{ItemName: "Pears"})
+	require.Equal(t, http.StatusNoContent, response.StatusCode)
+
+	response = postJSON(t, router, "/remove-item", RemoveItemRequest{ItemName: "Pears"})
+	require.Equal(t, http.StatusNotFound, response.StatusCode)
+
+	response = postJSON(t, router, "/submit-order", OrderRequest{
+		Cart: []Item{{ItemName: "Pears", Quantity: 2}},
+	})
+	require.Equal(t, http.StatusBadRequest, response.StatusCode)
+}
+
+func TestItemLookupByNameOrSKU(t *testing.T) {
+	items, discounts, orders := NewStore()
+	svc := New(items, discounts, orders)
+
+	// Names match ignoring case and whitespace, aliases and SKUs match too,
+	// and every line is recorded with the item's catalog name and SKU.
+	summary, err := svc.Quote(context.Background(), OrderRequest{
+		Cart: []Item{
+			{ItemName: "  APPLES ", Quantity: 1},
+			{ItemName: "apple", Quantity: 1},
+			{SKU: "fruit-002", Quantity: 3},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []ItemWithCost{
+		{"Apples", 1, 60, 30, "FRUIT-001", DefaultPriceList},
+		{"Apples", 1, 60, 30, "FRUIT-001", DefaultPriceList},
+		{"Oranges", 3, 25, 25, "FRUIT-002", DefaultPriceList},
+	}, summary.Summary)
+
+	// The SKU is used when both are given.
+	summary, err = svc.Quote(context.Background(), OrderRequest{
+		Cart: []Item{{ItemName: "Apples", SKU: "FRUIT-002", Quantity: 1}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "Oranges", summary.Summary[0].ItemName)
+
+	_, err = svc.Quote(context.Background(), OrderRequest{
+		Cart: []Item{{ItemName: "Appels", Quantity: 1}},
+	})
+	require.ErrorIs(t, err, ErrItemDoesNotExist)
+}
+
+func TestCatalogSKUs(t *testing.T) {
+	ctx := context.Background()
+	items, discounts, orders := NewStore()
+	svc := New(items, discounts, orders)
+
+	// Items added by name alone get a SKU derived from the name.
+	item, err := svc.SetItemPrice(ctx, SetItemPriceRequest{
+		ItemName: "Green tea (loose)",
+		Aliases:  []string{"Tea"},
+		Cost:     120,
+	})
+	require.NoError(t, err)
+	require.Equal(t, CatalogItem{"GREEN-TEA-LOOSE", "Green tea (loose)", []string{"Tea"}, 120}, item)
+
+	// Changing the cost by name keeps the item's name and aliases.
+	item, err = svc.SetItemPrice(ctx, SetItemPriceRequest{ItemName: "green TEA (loose)", Cost: 100})
+	require.NoError(t, err)
+	require.Equal(t, CatalogItem{"GREEN-TEA-LOOSE", "Green tea (loose)", []string{"Tea"}, 100}, item)
+
+	// Renaming by SKU keeps the SKU, so the item can still be ordered by it.
+	item, err = svc.SetItemPrice(ctx, SetItemPriceRequest{SKU: "green-tea-loose", ItemName: "Sencha", Cost: 100})
+	require.NoError(t, err)
+	require.Equal(t, "GREEN-TEA-LOOSE", item.SKU)
+	summary, err := svc.Quote(ctx, OrderRequest{Cart: []Item{{ItemName: "sencha", Quantity: 1}}})
+	require.NoError(t, err)
+	require.Equal(t, 100, summary.TotalCost)
+
+	// A name may only refer to one item.
+	_, err = svc.SetItemPrice(ctx, SetItemPriceRequest{SKU: "TEA-002", ItemName: "Earl grey", Aliases: []string{"tea"}, Cost: 90})
+	require.ErrorIs(t, err, ErrInvalidRequest)
+
+	// A new SKU needs a name.
+	_, err = svc.SetItemPrice(ctx, SetItemPriceRequest{SKU: "TEA-002", Cost: 90})
+	require.ErrorIs(t, err, ErrInvalidRequest)
+
+	require.NoError(t, svc.RemoveItem(ctx, RemoveItemRequest{SKU: "GREEN-TEA-LOOSE"}))
+	require.ErrorIs(t, svc.RemoveItem(ctx, RemoveItemRequest{ItemName: "Sencha"}), ErrItemDoesNotExist)
+}
+
+func TestUnknownItemSuggestions(t *testing.T) {
+	items, discounts, orders := NewStore()
+	svc := New(items, discounts, orders)
+
+	_, err := svc.Quote(context.Background(), OrderRequest{
+		Cart: []Item{
+			{ItemName: "Appels", Quantity: 1},
+			{ItemName: "Oranges", Quantity: 1},
+			{ItemName: "oranje", Quantity: 1},
+			{SKU: "FRUIT-01", Quantity: 1},
+			{ItemName: "Bread", Quantity: 1},
+		},
+	})
+	require.ErrorIs(t, err, ErrItemDoesNotExist)
+
+	var unknown *UnknownItemsError
+	require.ErrorAs(t, err, &unknown)
+	require.Equal(t, []UnknownItem{
+		{Line: 0, ItemName: "Appels", Suggestions: []string{"Apples"}},
+		{Line: 2, ItemName: "oranje", Suggestions: []string{"Oranges"}},
+		{Line: 3, SKU: "FRUIT-01", Suggestions: []string{"FRUIT-001", "FRUIT-002"}},
+		{Line: 4, ItemName: "Bread"},
+	}, unknown.Items)
+	require.Contains(t, err.Error(), `"Appels" (did you mean "Apples"?)`)
+}
+
+func TestUnknownItemsResponse(t *testing.T) {
+	items, discounts, orders := NewStore()
+	router := NewOrdersRouter(New(items, discounts, orders))
+
+	response := postJSON(t, router, "/quote-order", OrderRequest{
+		Cart: []Item{{ItemName: "Orangs", Quantity: 1}},
+	})
+	require.Equal(t, http.StatusBadRequest, response.StatusCode)
+
+	var failure GenericErrResponse
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&failure))
+	require.Equal(t, []UnknownItem{
+		{Line: 0, ItemName: "Orangs", Suggestions: []string{"Oranges"}},
+	}, failure.UnknownItems)
+}
+
+func TestScheduledPriceChanges(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	items, discounts, orders := NewStore()
+	svc := New(items, discounts, orders, WithClock(clock.Now))
+	router := NewOrdersRouter(svc)
+	apples := OrderRequest{Cart: []Item{{ItemName: "Apples", Quantity: 1}}}
+
+	// Apples go up on Monday, and again the week after.
+	monday := clock.Now().Add(72 * time.Hour)
+	next_monday := monday.Add(7 * 24 * time.Hour)
+	item, err := svc.SetItemPrice(ctx, SetItemPriceRequest{SKU: "FRUIT-001", Cost: 70, EffectiveFrom: &monday})
+	require.NoError(t, err)
+	require.Equal(t, 70, item.Cost)
+	_, err = svc.SetItemPrice(ctx, SetItemPriceRequest{SKU: "FRUIT-001", Cost: 80, EffectiveFrom: &next_monday})
+	require.NoError(t, err)
+
+	// Until then orders are priced, and the catalog lists, the current price.
+	before, err := svc.SimpleSummary(ctx, apples)
+	require.NoError(t, err)
+	require.Equal(t, 60, before.TotalCost)
+	catalog, err := svc.GetCatalog(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 60, catalog.Items[0].Cost)
+
+	clock.now = monday
+	after, err := svc.SimpleSummary(ctx, apples)
+	require.NoError(t, err)
+	require.Equal(t, 70, after.TotalCost)
+
+	// Stored orders keep the price they were placed at.
+	stored, err := svc.GetSingleOrder(ctx, GetSingleOrderRequest{before.OrderID})
+	require.NoError(t, err)
+	require.Equal(t, 60, stored.TotalCost)
+
+	// Prices cannot be changed in the past.
+	yesterday := clock.Now().Add(-24 * time.Hour)
+	_, err = svc.SetItemPrice(ctx, SetItemPriceRequest{SKU: "FRUIT-001", Cost: 10, EffectiveFrom: &yesterday})
+	require.ErrorIs(t, err, ErrInvalidRequest)
+
+	// An item whose first price is scheduled cannot be ordered yet.
+	tomorrow := clock.Now().Add(24 * time.Hour)
+	_, err = svc.SetItemPrice(ctx, SetItemPriceRequest{ItemName: "Pears", Cost: 40, EffectiveFrom: &tomorrow})
+	require.NoError(t, err)
+	_, err = svc.Quote(ctx, OrderRequest{Cart: []Item{{ItemName: "Pears", Quantity: 1}}})
+	require.ErrorIs(t, err, ErrItemDoesNotExist)
+	catalog, err = svc.GetCatalog(ctx)
+	require.NoError(t, err)
+	require.Len(t, catalog.Items, 2)
+
+	// The history lists every price, the one still to come is scheduled.
+	response := postJSON(t, router, "/get-price-history", PriceHistoryRequest{ItemName: "apples"})
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	var history PriceHistory
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&history))
+	require.Equal(t, PriceHistory{
+		SKU:      "FRUIT-001",
+		ItemName: "Apples",
+		Prices: []PriceVersion{
+			{Cost: 60},
+			{Cost: 70, EffectiveFrom: &monday},
+			{Cost: 80, EffectiveFrom: &next_monday, Scheduled: true},
+		},
+	}, history)
+
+	response = postJSON(t, router, "/get-price-history", PriceHistoryRequest{ItemName: "Plums"})
+	require.Equal(t, http.StatusNotFound, response.StatusCode)
+}
+
+func TestItemPriceVersions(t *testing.T) {
+	start := time.Date(2022, time.March, 1, 0, 0, 0, 0, time.UTC)
+	item := StoreItem{Name: "Apples"}
+	_, ok := item.CostAt(start)
+	require.False(t, ok)
+
+	// Prices are kept in the order they take effect, whatever order they are
+	// set in. A price set for the same time replaces the earlier one.
+	item.Prices = item.withPrice(ItemPrice{70, start.Add(2 * time.Hour)})
+	item.Prices = item.withPrice(ItemPrice{60, start})
+	shared := item.Prices
+	item.Prices = item.withPrice(ItemPrice{65, start})
+	require.Equal(t, []ItemPrice{{65, start}, {70, start.Add(2 * time.Hour)}}, item.Prices)
+	require.Equal(t, 60, shared[0].Cost, "prices must not be changed in place")
+
+	for _, test := range []struct {
+		at   time.Time
+		cost int
+		ok   bool
+	}{
+		{start.Add(-time.Second), 0, false},
+		{start, 65, true},
+		{start.Add(2*time.Hour - time.Second), 65, true},
+		{start.Add(2 * time.Hour), 70, true},
+	} {
+		cost, ok := item.CostAt(test.at)
+		require.Equal(t, test.ok, ok)
+		require.Equal(t, test.cost, cost)
+	}
+}
@@ -0,0 +1,382 @@
+// Command aebatch prices a file of orders offline and writes a priced report.
+//
+//	aebatch [-format csv|jsonl] [-catalog FILE] [-promotions FILE]
+//	        [-price-lists FILE] [-discount-policy POLICY] [-o REPORT] [ORDERS]
+//
+// Every order is priced through the same path as the `/submit-order` endpoint
+// but is never stored. Orders are read from ORDERS, or stdin when omitted, in
+// one of two formats:
+//
+//	csv    a header row followed by one row per cart line, rows sharing an
+//	       order_ref form a single order
+//	jsonl  one OrderRequest JSON object per line, the order_ref is the line
+//	       number
+//
+// The CSV columns are order_ref, quantity and either or both of item_name and
+// sku, in any order. An item is looked up by its SKU when one is given, as in
+// an OrderRequest. The optional channel and customer_group columns select the
+// price list of an order, every row of the order must give the same values.
+//
+// The report is CSV with one row per priced line, giving the item's SKU and
+// the price list it was priced from, orders that fail are written as a single
+// row with the error column set.
+//
+// Orders are priced against the default catalog, or only the items in the
+// -catalog file when one is given. Promotions in the -promotions file, in the
+// same format as the server's, are priced as at the time aebatch is run, and
+// orders are priced from the price lists in the -price-lists file. Offers on
+// the same item are combined by the -discount-policy, as in the server.
+//
+// aebatch exits with status 1 when the input cannot be read or the report
+// written, and with status 2 when the report was written but some orders
+// could not be priced.
+package main
+
+import (
+	"aetest"
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// errOrdersFailed is returned when the report has been written but some of
+// its orders could not be priced.
+var errOrdersFailed = errors.New("orders failed")
+
+// batchOrder is a single order read from the input file. Err is set when the
+// input row could not be parsed, the order is then reported without pricing.
+type batchOrder struct {
+	Ref     string
+	Request aetest.OrderRequest
+	Err     error
+}
+
+var reportHeader = []string{
+	"order_ref", "item_name", "sku", "quantity", "cost", "discount",
+	"line_total", "price_list", "order_total", "error",
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("aebatch", flag.ContinueOnError)
+	format := fs.String("format", "", "input format, csv or jsonl, detected from the file extension when empty")
+	catalog_file := fs.String("catalog", "", "JSON catalog of item prices to price against instead of the default")
+	promotions_file := fs.String("promotions", "", "JSON file of time limited promotions")
+	price_lists_file := fs.String("price-lists", "", "JSON file of price lists for customer groups and channels")
+	discount_policy := fs.String("discount-policy", "best-for-customer", "how the offers on an item are combined: best-for-customer, first-match or all-stackable")
+	output := fs.String("o", "", "report file, defaults to stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	input := stdin
+	name := ""
+	if fs.NArg() > 0 {
+		name = fs.Arg(0)
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		input = f
+	}
+
+	if *format == "" {
+		*format = strings.TrimPrefix(filepath.Ext(name), ".")
+	}
+
+	var orders []batchOrder
+	var err error
+	switch *format {
+	case "csv":
+		orders, err = readCSV(input)
+	case "jsonl", "ndjson":
+		orders, err = readJSONL(input)
+	default:
+		return fmt.Errorf("unknown input format %q, use -format csv or jsonl", *format)
+	}
+	if err != nil {
+		return err
+	}
+
+	// A fresh service is used for every run, nothing priced here is visible
+	// to a running server. The catalog file replaces the default catalog,
+	// the default items' discounts still apply to items with the same SKU.
+	policy, err := aetest.ParseDiscountPolicy(*discount_policy)
+	if err != nil {
+		return err
+	}
+	service_opts := []aetest.Option{aetest.WithDiscountPolicy(policy)}
+	if *promotions_file != "" {
+		promotions, err := aetest.LoadPromotionsFile(*promotions_file)
+		if err != nil {
+			return err
+		}
+		service_opts = append(service_opts, aetest.WithPromotions(promotions...))
+	}
+	if *price_lists_file != "" {
+		price_lists, err := aetest.LoadPriceListsFile(*price_lists_file)
+		if err != nil {
+			return err
+		}
+		service_opts = append(service_opts, aetest.WithPriceLists(price_lists...))
+	}
+	item_store, discount, order_store := aetest.NewStore()
+	if *catalog_file != "" {
+		clear(item_store)
+	}
+	service := aetest.New(item_store, discount, order_store, service_opts...)
+	if *catalog_file != "" {
+		if err := loadCatalog(ctx, service, *catalog_file); err != nil {
+			return err
+		}
+	}
+
+	out := stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	return writeReport(ctx, out, service, orders)
+}
+
+// csvColumns are the columns a CSV order file may have.
+var csvColumns = []string{
+	"order_ref", "item_name", "sku", "quantity", "channel", "customer_group",
+}
+
+// readCSV groups the rows of a CSV order file by order_ref, keeping orders in
+// the order they first appear.
+func readCSV(r io.Reader) ([]batchOrder, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading csv header: %w", err)
+	}
+	columns, err := csvHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	column := func(record []string, name string) string {
+		if i, ok := columns[name]; ok {
+			return record[i]
+		}
+		return ""
+	}
+
+	var orders []batchOrder
+	index := map[string]int{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		ref := column(record, "order_ref")
+		request := aetest.OrderRequest{
+			Channel:       column(record, "channel"),
+			CustomerGroup: column(record, "customer_group"),
+		}
+		i, ok := index[ref]
+		if !ok {
+			i = len(orders)
+			index[ref] = i
+			orders = append(orders, batchOrder{Ref: ref, Request: request})
+		}
+		if orders[i].Request.Channel != request.Channel ||
+			orders[i].Request.CustomerGroup != request.CustomerGroup {
+			orders[i].Err = errors.New("rows of the order have different channels or customer groups")
+			continue
+		}
+
+		quantity, err := strconv.Atoi(column(record, "quantity"))
+		if err != nil {
+			orders[i].Err = fmt.Errorf("invalid quantity %q", column(record, "quantity"))
+			continue
+		}
+		orders[i].Request.Cart = append(orders[i].Request.Cart, aetest.Item{
+			ItemName: column(record, "item_name"),
+			SKU:      column(record, "sku"),
+			Quantity: quantity,
+		})
+	}
+
+	return orders, nil
+}
+
+// csvHeader returns the index of each column in the header, which must have
+// an order_ref, a quantity and an item_name or sku column.
+func csvHeader(header []string) (map[string]int, error) {
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		if !slices.Contains(csvColumns, name) {
+			return nil, fmt.Errorf("unknown csv column %q, columns are %s", name, strings.Join(csvColumns, ","))
+		}
+		if _, ok := columns[name]; ok {
+			return nil, fmt.Errorf("csv column %q is repeated", name)
+		}
+		columns[name] = i
+	}
+
+	_, has_name := columns["item_name"]
+	_, has_sku := columns["sku"]
+	_, has_ref := columns["order_ref"]
+	_, has_quantity := columns["quantity"]
+	if !has_ref || !has_quantity || !has_name && !has_sku {
+		return nil, errors.New("csv header must have order_ref, quantity and item_name or sku columns")
+	}
+	return columns, nil
+}
+
+// readJSONL reads one OrderRequest per line, blank lines are skipped.
+func readJSONL(r io.Reader) ([]batchOrder, error) {
+	var orders []batchOrder
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		order := batchOrder{Ref: strconv.Itoa(line)}
+		if err := json.Unmarshal([]byte(text), &order.Request); err != nil {
+			order.Err = fmt.Errorf("invalid order: %v", err)
+		}
+		orders = append(orders, order)
+	}
+
+	return orders, scanner.Err()
+}
+
+// loadCatalog adds the items in the supplied JSON file, which uses the same
+// format as `/get-catalog`, to the service' catalog.
+func loadCatalog(
+	ctx context.Context,
+	service aetest.Service,
+	path string,
+) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var catalog aetest.Catalog
+	if err := json.NewDecoder(f).Decode(&catalog); err != nil {
+		return fmt.Errorf("reading catalog: %w", err)
+	}
+
+	for _, item := range catalog.Items {
+		_, err := service.SetItemPrice(ctx, aetest.SetItemPriceRequest{
+			SKU:      item.SKU,
+			ItemName: item.ItemName,
+			Aliases:  item.Aliases,
+			Cost:     item.Cost,
+		})
+		if err != nil {
+			return fmt.Errorf("catalog item %q: %w", item.ItemName, err)
+		}
+	}
+
+	return nil
+}
+
+// writeReport prices every order and writes the priced lines as CSV. Every
+// order is reported, if some could not be priced an error wrapping
+// errOrdersFailed is returned once the report is written.
+func writeReport(
+	ctx context.Context,
+	w io.Writer,
+	service aetest.Service,
+	orders []batchOrder,
+) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(reportHeader); err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, order := range orders {
+		err := order.Err
+		var summary aetest.OrderSummary
+		if err == nil {
+			summary, err = service.Quote(ctx, order.Request)
+		}
+
+		if err != nil {
+			writer.Write([]string{order.Ref, "", "", "", "", "", "", "", "", err.Error()})
+			failed++
+			continue
+		}
+
+		for _, item := range summary.Summary {
+			line_total := item.Cost*item.Quantity - item.Discount
+			writer.Write([]string{
+				order.Ref,
+				item.ItemName,
+				item.SKU,
+				strconv.Itoa(item.Quantity),
+				strconv.Itoa(item.Cost),
+				strconv.Itoa(item.Discount),
+				strconv.Itoa(line_total),
+				item.PriceList,
+				strconv.Itoa(summary.TotalCost),
+				"",
+			})
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d orders could not be priced", errOrdersFailed, failed, len(orders))
+	}
+	return nil
+}
+
+// exitStatus returns the status aebatch exits with after run returns err.
+func exitStatus(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.Is(err, errOrdersFailed):
+		return 2
+	}
+	return 1
+}
+
+func main() {
+	err := run(os.Args[1:], os.Stdin, os.Stdout)
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	os.Exit(exitStatus(err))
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// aebatch runs the command with stdin, returning the report and exit status.
+func aebatch(t *testing.T, stdin string, args ...string) ([][]string, int) {
+	var stdout bytes.Buffer
+	status := exitStatus(run(args, strings.NewReader(stdin), &stdout))
+	if stdout.Len() == 0 {
+		return nil, status
+	}
+
+	report, err := csv.NewReader(&stdout).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, reportHeader, report[0])
+	return report[1:], status
+}
+
+func TestRunCSV(t *testing.T) {
+	report, status := aebatch(t, `order_ref,item_name,quantity
+A-100,Apples,2
+A-100,Oranges,3
+A-101,Oranges,7
+`, "-format", "csv")
+	require.Zero(t, status)
+	require.Equal(t, [][]string{
+		{"A-100", "Apples", "FRUIT-001", "2", "60", "60", "60", "default", "110", ""},
+		{"A-100", "Oranges", "FRUIT-002", "3", "25", "25", "50", "default", "110", ""},
+		{"A-101", "Oranges", "FRUIT-002", "7", "25", "50", "125", "default", "125", ""},
+	}, report)
+}
+
+func TestRunCSVColumns(t *testing.T) {
+	// Items may be given by SKU, the columns may be in any order.
+	report, status := aebatch(t, `sku,quantity,order_ref,item_name
+FRUIT-001,2,A-100,
+,3,A-100,Oranges
+FRUIT-002,1,A-101,Apples
+`, "-format", "csv")
+	require.Zero(t, status)
+	require.Equal(t, [][]string{
+		{"A-100", "Apples", "FRUIT-001", "2", "60", "60", "60", "default", "110", ""},
+		{"A-100", "Oranges", "FRUIT-002", "3", "25", "25", "50", "default", "110", ""},
+		{"A-101", "Oranges", "FRUIT-002", "1", "25", "0", "25", "default", "25", ""},
+	}, report)
+
+	for _, header := range []string{
+		"order_ref,quantity",
+		"order_ref,item_name,quantity,colour",
+		"order_ref,sku,sku,quantity",
+	} {
+		_, status := aebatch(t, header+"\n", "-format", "csv")
+		require.Equal(t, 1, status, header)
+	}
+}
+
+func TestRunJSONL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte(`{"cart":[{"item_name":"Apples","quantity":4}]}
+
+{"cart":[{"item_name":"Apples","quantity":0}]}
+`), 0o644))
+
+	// The format is detected from the extension, the second order is on the
+	// third line.
+	report, status := aebatch(t, "", path)
+	require.Equal(t, 2, status)
+	require.Len(t, report, 2)
+	require.Equal(t, []string{"1", "Apples", "FRUIT-001", "4", "60", "120", "120", "default", "120", ""}, report[0])
+	require.Equal(t, "3", report[1][0])
+	require.NotEmpty(t, report[1][9])
+}
+
+func TestRunRowErrors(t *testing.T) {
+	// Rows that cannot be parsed and orders that cannot be priced are
+	// reported, the other orders are still priced.
+	report, status := aebatch(t, `order_ref,item_name,quantity
+A-102,Magazine,1
+A-103,Apples,two
+A-104,Apples,1
+`, "-format", "csv")
+	require.Equal(t, 2, status)
+	require.Len(t, report, 3)
+	require.Contains(t, report[0][9], "does not exist")
+	require.Equal(t, `invalid quantity "two"`, report[1][9])
+	require.Equal(t, []string{"A-104", "Apples", "FRUIT-001", "1", "60", "0", "60", "default", "60", ""}, report[2])
+}
+
+func TestRunCatalog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catalog.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"items": [
+		{"sku": "FRUIT-001", "item_name": "Apples", "cost": 50}
+	]}`), 0o644))
+
+	// The catalog file replaces the default catalog, apples keep their
+	// discount.
+	report, status := aebatch(t, `order_ref,item_name,quantity
+A-100,Apples,2
+A-101,Oranges,1
+`, "-format", "csv", "-catalog", path)
+	require.Equal(t, 2, status)
+	require.Equal(t, []string{"A-100", "Apples", "FRUIT-001", "2", "50", "50", "50", "default", "50", ""}, report[0])
+	require.Contains(t, report[1][9], "does not exist")
+}
+
+func TestRunPromotions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "promotions.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[
+		{"id": "orange-sale", "skus": ["FRUIT-002"], "percent_off": 20}
+	]`), 0o644))
+
+	report, status := aebatch(t, `order_ref,item_name,quantity
+A-100,Oranges,1
+`, "-format", "csv", "-promotions", path)
+	require.Equal(t, 0, status)
+	require.Equal(t, []string{"A-100", "Oranges", "FRUIT-002", "1", "25", "5", "20", "default", "20", ""}, report[0])
+
+	// Invalid promotions are rejected before anything is priced.
+	require.NoError(t, os.WriteFile(path, []byte(`[{"id": "a", "skus": ["FRUIT-002"]}]`), 0o644))
+	report, status = aebatch(t, "", "-format", "csv", "-promotions", path)
+	require.Equal(t, 1, status)
+	require.Nil(t, report)
+}
+
+func TestRunPriceLists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "price_lists.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[
+		{"name": "wholesale", "customer_groups": ["trade"], "prices": {"FRUIT-002": 20}},
+		{"name": "pos", "channels": ["pos"], "prices": {"FRUIT-002": 22}}
+	]`), 0o644))
+
+	// Each order is priced from the price list for its customer group or
+	// channel, or from the catalog.
+	report, status := aebatch(t, `order_ref,item_name,quantity,channel,customer_group
+A-100,Oranges,1,,trade
+A-101,Oranges,1,pos,
+A-102,Oranges,1,,
+A-103,Oranges,1,pos,
+A-103,Apples,1,web,
+`, "-format", "csv", "-price-lists", path)
+	require.Equal(t, 2, status)
+	require.Equal(t, []string{"A-100", "Oranges", "FRUIT-002", "1", "20", "0", "20", "wholesale", "20", ""}, report[0])
+	require.Equal(t, []string{"A-101", "Oranges", "FRUIT-002", "1", "22", "0", "22", "pos", "22", ""}, report[1])
+	require.Equal(t, []string{"A-102", "Oranges", "FRUIT-002", "1", "25", "0", "25", "default", "25", ""}, report[2])
+	require.Equal(t, "A-103", report[3][0])
+	require.Contains(t, report[3][9], "different channels or customer groups")
+}
+
+func TestRunDiscountPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "promotions.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[
+		{"id": "orange-sale", "skus": ["FRUIT-002"], "percent_off": 20, "stackable": true}
+	]`), 0o644))
+	orders := `order_ref,item_name,quantity
+A-100,Oranges,3
+`
+
+	// By default the better of 3-for-2 and the sale is given, with
+	// all-stackable both are.
+	report, status := aebatch(t, orders, "-format", "csv", "-promotions", path)
+	require.Equal(t, 0, status)
+	require.Equal(t, []string{"A-100", "Oranges", "FRUIT-002", "3", "25", "25", "50", "default", "50", ""}, report[0])
+	report, status = aebatch(t, orders, "-format", "csv", "-promotions", path, "-discount-policy", "all-stackable")
+	require.Equal(t, 0, status)
+	require.Equal(t, []string{"A-100", "Oranges", "FRUIT-002", "3", "25", "40", "35", "default", "35", ""}, report[0])
+}
+
+func TestRunFailures(t *testing.T) {
+	for name, args := range map[string][]string{
+		"unknown format":      {"-format", "xml"},
+		"missing file":        {filepath.Join(t.TempDir(), "missing.csv")},
+		"missing catalog":     {"-format", "jsonl", "-catalog", filepath.Join(t.TempDir(), "missing.json")},
+		"missing promotions":  {"-format", "jsonl", "-promotions", filepath.Join(t.TempDir(), "missing.json")},
+		"missing price lists": {"-format", "jsonl", "-price-lists", filepath.Join(t.TempDir(), "missing.json")},
+		"unknown flag":        {"-x"},
+		"unknown policy":      {"-format", "jsonl", "-discount-policy", "cheapest"},
+	} {
+		report, status := aebatch(t, "", args...)
+		require.Equal(t, 1, status, name)
+		require.Nil(t, report, name)
+	}
+
+	_, status := aebatch(t, "item_name,quantity\n", "-format", "csv")
+	require.Equal(t, 1, status)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"aetest"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// client is a thin wrapper around the orders HTTP API. Each method maps to a
+// single endpoint registered in `aetest.NewOrdersRouter`.
+type client struct {
+	addr string
+	http *http.Client
+}
+
+func newClient(addr string) client {
+	return client{
+		addr: strings.TrimRight(addr, "/"),
+		http: &http.Client{},
+	}
+}
+
+func (c client) SubmitOrder(req aetest.OrderRequest) (aetest.OrderSummary, error) {
+	var summary aetest.OrderSummary
+	err := c.do(http.MethodPost, "/submit-order", req, &summary)
+	return summary, err
+}
+
+func (c client) QuoteOrder(req aetest.OrderRequest) (aetest.OrderSummary, error) {
+	var summary aetest.OrderSummary
+	err := c.do(http.MethodPost, "/quote-order", req, &summary)
+	return summary, err
+}
+
+func (c client) ExplainPrice(
+	req aetest.ExplainPriceRequest,
+) (aetest.PriceExplanation, error) {
+	var explanation aetest.PriceExplanation
+	err := c.do(http.MethodPost, "/explain-price", req, &explanation)
+	return explanation, err
+}
+
+func (c client) GetOrder(order_id string) (aetest.OrderSummary, error) {
+	var summary aetest.OrderSummary
+	req := aetest.GetSingleOrderRequest{OrderID: order_id}
+	err := c.do(http.MethodPost, "/get-order", req, &summary)
+	return summary, err
+}
+
+func (c client) CancelOrder(order_id string) (aetest.OrderSummary, error) {
+	var summary aetest.OrderSummary
+	req := aetest.CancelOrderRequest{OrderID: order_id}
+	err := c.do(http.MethodPost, "/cancel-order", req, &summary)
+	return summary, err
+}
+
+func (c client) GetAllOrders() (aetest.AllOrders, error) {
+	var orders aetest.AllOrders
+	err := c.do(http.MethodGet, "/get-all-orders", nil, &orders)
+	return orders, err
+}
+
+func (c client) GetOrdersInRange(
+	req aetest.OrdersInRangeRequest,
+) (aetest.AllOrders, error) {
+	var orders aetest.AllOrders
+	err := c.do(http.MethodPost, "/get-orders-in-range", req, &orders)
+	return orders, err
+}
+
+func (c client) GetCatalog() (aetest.Catalog, error) {
+	var catalog aetest.Catalog
+	err := c.do(http.MethodGet, "/get-catalog", nil, &catalog)
+	return catalog, err
+}
+
+func (c client) SetItemPrice(
+	req aetest.SetItemPriceRequest,
+) (aetest.CatalogItem, error) {
+	var item aetest.CatalogItem
+	err := c.do(http.MethodPost, "/set-item-price", req, &item)
+	return item, err
+}
+
+func (c client) GetPriceHistory(
+	req aetest.PriceHistoryRequest,
+) (aetest.PriceHistory, error) {
+	var history aetest.PriceHistory
+	err := c.do(http.MethodPost, "/get-price-history", req, &history)
+	return history, err
+}
+
+func (c client) RemoveItem(req aetest.RemoveItemRequest) error {
+	return c.do(http.MethodPost, "/remove-item", req, nil)
+}
+
+// ExportOrders streams every stored order in the supplied format to w.
+func (c client) ExportOrders(format aetest.ExportFormat, w io.Writer) error {
+	path := "/export-orders?format=" + string(format)
+	response, err := c.send(http.MethodGet, path, "", nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	_, err = io.Copy(w, response.Body)
+	return err
+}
+
+// ImportOrders uploads previously exported orders in the supplied format.
+func (c client) ImportOrders(
+	format aetest.ExportFormat,
+	r io.Reader,
+) (aetest.ImportOrdersResponse, error) {
+	var imported aetest.ImportOrdersResponse
+	path := "/import-orders?format=" + string(format)
+	response, err := c.send(http.MethodPost, path, format.ContentType(), r)
+	if err != nil {
+		return imported, err
+	}
+	defer response.Body.Close()
+
+	err = json.NewDecoder(response.Body).Decode(&imported)
+	return imported, err
+}
+
+// do sends a request to the orders API. A non-nil body is serialized as JSON.
+// A successful response is deserialized into out when it is non-nil.
+func (c client) do(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	content_type := ""
+	if body != nil {
+		JSON, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(JSON)
+		content_type = "application/json"
+	}
+
+	response, err := c.send(method, path, content_type, reader)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(out)
+}
+
+// send sends a request to the orders API, an unsuccessful response is
+// returned as an error containing the reason given by the server. The caller
+// must close the body of a successful response.
+func (c client) send(
+	method, path, content_type string,
+	body io.Reader,
+) (*http.Response, error) {
+	request, err := http.NewRequest(method, c.addr+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if content_type != "" {
+		request.Header.Set("Content-Type", content_type)
+	}
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode >= 300 {
+		defer response.Body.Close()
+		var failure aetest.GenericErrResponse
+		if err := json.NewDecoder(response.Body).Decode(&failure); err != nil || failure.Err == "" {
+			return nil, fmt.Errorf("%s %s: %s", method, path, response.Status)
+		}
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, response.Status, failure.Err)
+	}
+
+	return response, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"aetest"
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTestClient serves the orders API, backed by a fresh store, and returns a
+// client for it.
+func newTestClient(t *testing.T) client {
+	items, discounts, orders := aetest.NewStore()
+	server := httptest.NewServer(aetest.NewOrdersRouter(aetest.New(items, discounts, orders)))
+	t.Cleanup(server.Close)
+
+	// A trailing slash is trimmed from the address.
+	return newClient(server.URL + "/")
+}
+
+func TestClientOrders(t *testing.T) {
+	api := newTestClient(t)
+	order := aetest.OrderRequest{Cart: []aetest.Item{
+		{ItemName: "Apples", Quantity: 2},
+		{SKU: "FRUIT-002", Quantity: 3},
+	}}
+
+	quote, err := api.QuoteOrder(order)
+	require.NoError(t, err)
+	require.Equal(t, 110, quote.TotalCost)
+	require.Empty(t, quote.OrderID)
+
+	explanation, err := api.ExplainPrice(aetest.ExplainPriceRequest{OrderRequest: order})
+	require.NoError(t, err)
+	require.Equal(t, quote.TotalCost, explanation.Order.TotalCost)
+	require.NotEmpty(t, explanation.Steps)
+
+	submitted, err := api.SubmitOrder(order)
+	require.NoError(t, err)
+	require.Equal(t, 110, submitted.TotalCost)
+
+	stored, err := api.GetOrder(submitted.OrderID)
+	require.NoError(t, err)
+	require.Equal(t, submitted.OrderID, stored.OrderID)
+
+	all_orders, err := api.GetAllOrders()
+	require.NoError(t, err)
+	require.Len(t, all_orders.Orders, 1)
+
+	in_range, err := api.GetOrdersInRange(aetest.OrdersInRangeRequest{
+		From: submitted.CreatedAt.Add(-time.Minute),
+		To:   submitted.CreatedAt.Add(time.Minute),
+	})
+	require.NoError(t, err)
+	require.Equal(t, all_orders, in_range)
+
+	cancelled, err := api.CancelOrder(submitted.OrderID)
+	require.NoError(t, err)
+	require.Equal(t, aetest.OrderCancelled, cancelled.Status)
+
+	// The reason given by the server is returned as the error.
+	_, err = api.CancelOrder(submitted.OrderID)
+	require.ErrorContains(t, err, "409")
+	require.ErrorContains(t, err, aetest.ErrOrderCancelled.Error())
+	_, err = api.SubmitOrder(aetest.OrderRequest{Cart: []aetest.Item{{ItemName: "Pears", Quantity: 1}}})
+	require.ErrorContains(t, err, aetest.ErrItemDoesNotExist.Error())
+}
+
+func TestClientCatalog(t *testing.T) {
+	api := newTestClient(t)
+
+	item, err := api.SetItemPrice(aetest.SetItemPriceRequest{ItemName: "Pears", Cost: 40})
+	require.NoError(t, err)
+	require.Equal(t, 40, item.Cost)
+
+	catalog, err := api.GetCatalog()
+	require.NoError(t, err)
+	require.Contains(t, catalog.Items, item)
+
+	history, err := api.GetPriceHistory(aetest.PriceHistoryRequest{SKU: item.SKU})
+	require.NoError(t, err)
+	require.Len(t, history.Prices, 1)
+
+	require.NoError(t, api.RemoveItem(aetest.RemoveItemRequest{SKU: item.SKU}))
+	require.Error(t, api.RemoveItem(aetest.RemoveItemRequest{SKU: item.SKU}))
+}
+
+func TestClientExportImport(t *testing.T) {
+	api := newTestClient(t)
+	_, err := api.SubmitOrder(aetest.OrderRequest{Cart: []aetest.Item{{ItemName: "Apples", Quantity: 1}}})
+	require.NoError(t, err)
+
+	for _, format := range []aetest.ExportFormat{aetest.ExportCSV, aetest.ExportJSONL} {
+		var exported bytes.Buffer
+		require.NoError(t, api.ExportOrders(format, &exported))
+		require.NotEmpty(t, exported.String())
+
+		// The orders are imported into another server.
+		imported, err := newTestClient(t).ImportOrders(format, &exported)
+		require.NoError(t, err)
+		require.Equal(t, 1, imported.Imported, format)
+	}
+
+	_, err = api.ImportOrders(aetest.ExportJSONL, strings.NewReader("not json\n"))
+	require.ErrorContains(t, err, "400")
+}
@@ -0,0 +1,635 @@
+// Command aectl is a command line client for the orders HTTP API.
+//
+//	aectl [-addr URL] [-json] <command> [arguments]
+//
+// Commands:
+//
+//	submit  [-f FILE] [--item NAME=QTY ...]  submit an order
+//	quote   [-f FILE] [--item NAME=QTY ...]  price an order without storing it
+//	explain [-at T] [-f FILE] [--item NAME=QTY ...]
+//	                                         show every step taken to price an order
+//	get     <order_id>                       get a single stored order
+//	cancel  <order_id>                       cancel a stored order
+//	list    [-item NAME] [-min-total N] [-max-total N] [-from T] [-to T]
+//	                                         list stored orders
+//	catalog list                             list orderable items
+//	catalog set [-sku SKU] [-alias NAME ...] [-from T] <item_name> <cost>
+//	                                         add or update an item, from T when given
+//	catalog history <item>                   list the past and scheduled costs of an item
+//	catalog remove <item>                    remove an item
+//	export  [-format csv|jsonl] [-o FILE]    export all stored orders
+//	import  [-format csv|jsonl] [FILE]       import exported orders
+//
+// An order for submit and quote is read from the JSON file given by -f, from
+// stdin when -f is "-", or built from one or more --item flags. When neither is
+// given the order is read from stdin.
+//
+// Items are referred to by name, matched ignoring case, or by SKU with a "sku:"
+// prefix, e.g. `--item sku:FRUIT-001=2` or `catalog remove sku:FRUIT-001`.
+//
+// submit, quote and explain also take -channel and -group, the channel and
+// customer group that select the price list the order is priced from.
+package main
+
+import (
+	"aetest"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	addr = flag.String(
+		"addr",
+		envOr("AECTL_ADDR", "http://localhost:3000"),
+		"orders API address, defaults to $AECTL_ADDR",
+	)
+	asJSON = flag.Bool("json", false, "print responses as JSON instead of tables")
+)
+
+// errUsage is returned when the command line arguments are invalid. The usage
+// message is printed instead of the error.
+var errUsage = errors.New("invalid usage")
+
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	api := newClient(*addr)
+	command, args := args[0], args[1:]
+
+	switch command {
+	case "submit", "quote":
+		fs := flag.NewFlagSet(command, flag.ContinueOnError)
+		request, err := parseOrder(fs, args, stdin)
+		if err != nil {
+			return err
+		}
+
+		var summary aetest.OrderSummary
+		if command == "submit" {
+			summary, err = api.SubmitOrder(request)
+		} else {
+			summary, err = api.QuoteOrder(request)
+		}
+		if err != nil {
+			return err
+		}
+		return printSummary(stdout, summary)
+
+	case "explain":
+		fs := flag.NewFlagSet(command, flag.ContinueOnError)
+		at := fs.String("at", "", "price the order as it would have been at this RFC 3339 time")
+		request, err := parseOrder(fs, args, stdin)
+		if err != nil {
+			return err
+		}
+
+		explain := aetest.ExplainPriceRequest{OrderRequest: request}
+		if *at != "" {
+			t, err := parseTime(*at)
+			if err != nil {
+				return err
+			}
+			explain.At = &t
+		}
+		explanation, err := api.ExplainPrice(explain)
+		if err != nil {
+			return err
+		}
+		return printExplanation(stdout, explanation)
+
+	case "get", "cancel":
+		if len(args) != 1 {
+			return errUsage
+		}
+
+		var summary aetest.OrderSummary
+		var err error
+		if command == "get" {
+			summary, err = api.GetOrder(args[0])
+		} else {
+			summary, err = api.CancelOrder(args[0])
+		}
+		if err != nil {
+			return err
+		}
+		return printSummary(stdout, summary)
+
+	case "list":
+		return listOrders(api, args, stdout)
+
+	case "catalog":
+		return catalog(api, args, stdout)
+
+	case "export":
+		return exportOrders(api, args, stdout)
+
+	case "import":
+		return importOrders(api, args, stdin, stdout)
+	}
+
+	return errUsage
+}
+
+// itemFlags collects repeated `--item NAME=QTY` flags into a cart.
+type itemFlags []aetest.Item
+
+func (f *itemFlags) String() string {
+	items := make([]string, 0, len(*f))
+	for _, item := range *f {
+		name := item.ItemName
+		if item.SKU != "" {
+			name = skuPrefix + item.SKU
+		}
+		items = append(items, fmt.Sprintf("%s=%d", name, item.Quantity))
+	}
+	return strings.Join(items, ",")
+}
+
+func (f *itemFlags) Set(value string) error {
+	name, quantity, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("item %q must be of the form NAME=QTY", value)
+	}
+
+	n, err := strconv.Atoi(quantity)
+	if err != nil {
+		return fmt.Errorf("item %q has an invalid quantity", value)
+	}
+
+	sku, name := itemRef(name)
+	*f = append(*f, aetest.Item{ItemName: name, Quantity: n, SKU: sku})
+	return nil
+}
+
+// skuPrefix marks an item argument as a SKU rather than a name.
+const skuPrefix = "sku:"
+
+// itemRef splits an item argument into either a SKU or a name.
+func itemRef(value string) (sku string, name string) {
+	if strings.HasPrefix(value, skuPrefix) {
+		return strings.TrimPrefix(value, skuPrefix), ""
+	}
+	return "", value
+}
+
+// parseOrder builds an OrderRequest from the submit, quote and explain
+// arguments. The order's flags are added to fs, which may already have flags
+// of its own.
+func parseOrder(
+	fs *flag.FlagSet,
+	args []string,
+	stdin io.Reader,
+) (aetest.OrderRequest, error) {
+	var items itemFlags
+	file := fs.String("f", "", "JSON order file, - reads from stdin")
+	fs.Var(&items, "item", "item to order as NAME=QTY, may be repeated")
+	channel := fs.String("channel", "", "channel the order is placed through, selecting its price list")
+	group := fs.String("group", "", "customer group of the order, selecting its price list")
+	if err := fs.Parse(args); err != nil {
+		return aetest.OrderRequest{}, err
+	}
+
+	// The flags take precedence over the channel and group of an order
+	// read from a file.
+	withPriceList := func(request aetest.OrderRequest) aetest.OrderRequest {
+		if *channel != "" {
+			request.Channel = *channel
+		}
+		if *group != "" {
+			request.CustomerGroup = *group
+		}
+		return request
+	}
+
+	if len(items) > 0 {
+		if *file != "" {
+			return aetest.OrderRequest{}, errors.New("-f and --item cannot be combined")
+		}
+		return withPriceList(aetest.OrderRequest{Cart: items}), nil
+	}
+
+	reader := stdin
+	if *file != "" && *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return aetest.OrderRequest{}, err
+		}
+		defer f.Close()
+		reader = f
+	}
+
+	var request aetest.OrderRequest
+	if err := json.NewDecoder(reader).Decode(&request); err != nil {
+		return aetest.OrderRequest{}, fmt.Errorf("reading order: %w", err)
+	}
+	return withPriceList(request), nil
+}
+
+// listOrders prints all stored orders that match the optional filters.
+func listOrders(api client, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	item := fs.String("item", "", "only orders containing this item")
+	min_total := fs.Int("min-total", 0, "only orders with a total cost of at least this")
+	max_total := fs.Int("max-total", -1, "only orders with a total cost of at most this")
+	from := fs.String("from", "", "only orders created at or after this RFC 3339 time")
+	to := fs.String("to", "", "only orders created before this RFC 3339 time")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var all_orders aetest.AllOrders
+	var err error
+	if *from != "" || *to != "" {
+		// The time range is filtered by the server, an open ended range is
+		// bounded by the unix epoch or the current time.
+		request := aetest.OrdersInRangeRequest{
+			From: time.Unix(0, 0),
+			To:   time.Now(),
+		}
+		if *from != "" {
+			if request.From, err = parseTime(*from); err != nil {
+				return err
+			}
+		}
+		if *to != "" {
+			if request.To, err = parseTime(*to); err != nil {
+				return err
+			}
+		}
+		all_orders, err = api.GetOrdersInRange(request)
+	} else {
+		all_orders, err = api.GetAllOrders()
+	}
+	if err != nil {
+		return err
+	}
+
+	var filtered []aetest.OrderSummary
+	for _, order := range all_orders.Orders {
+		if order.TotalCost < *min_total {
+			continue
+		}
+		if *max_total >= 0 && order.TotalCost > *max_total {
+			continue
+		}
+		if *item != "" && !containsItem(order, *item) {
+			continue
+		}
+		filtered = append(filtered, order)
+	}
+
+	if *asJSON {
+		return printJSON(stdout, aetest.AllOrders{Orders: filtered})
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ORDER ID\tCREATED\tSTATUS\tITEMS\tTOTAL")
+	for _, order := range filtered {
+		fmt.Fprintf(
+			w, "%s\t%s\t%s\t%d\t%d\n",
+			order.OrderID,
+			order.CreatedAt.Local().Format(time.DateTime),
+			order.Status,
+			len(order.Summary),
+			order.TotalCost,
+		)
+	}
+	return w.Flush()
+}
+
+// parseTime parses an RFC 3339 time.
+func parseTime(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected RFC 3339", value)
+	}
+	return t, nil
+}
+
+// containsItem reports whether the order has a line for the item, referred to
+// by name ignoring case or by SKU.
+func containsItem(order aetest.OrderSummary, ref string) bool {
+	sku, name := itemRef(ref)
+	for _, item := range order.Summary {
+		if sku != "" && strings.EqualFold(item.SKU, sku) {
+			return true
+		}
+		if name != "" && strings.EqualFold(item.ItemName, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// catalog handles the catalog admin subcommands.
+func catalog(api client, args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	switch args[0] {
+	case "list":
+		catalog, err := api.GetCatalog()
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return printJSON(stdout, catalog)
+		}
+
+		w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SKU\tITEM\tALIASES\tCOST")
+		for _, item := range catalog.Items {
+			fmt.Fprintf(
+				w, "%s\t%s\t%s\t%d\n",
+				item.SKU, item.ItemName, strings.Join(item.Aliases, ", "), item.Cost,
+			)
+		}
+		return w.Flush()
+
+	case "set":
+		fs := flag.NewFlagSet("catalog set", flag.ContinueOnError)
+		sku := fs.String("sku", "", "SKU of the item, derived from the name for new items when empty")
+		var aliases aliasFlags
+		fs.Var(&aliases, "alias", "another name for the item, may be repeated, replaces the existing aliases")
+		from := fs.String("from", "", "RFC 3339 time the cost applies from, immediately when empty")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 2 {
+			return errUsage
+		}
+		cost, err := strconv.Atoi(fs.Arg(1))
+		if err != nil {
+			return fmt.Errorf("invalid cost %q", fs.Arg(1))
+		}
+
+		request := aetest.SetItemPriceRequest{
+			SKU:      *sku,
+			ItemName: fs.Arg(0),
+			Aliases:  aliases,
+			Cost:     cost,
+		}
+		if *from != "" {
+			effective_from, err := parseTime(*from)
+			if err != nil {
+				return err
+			}
+			request.EffectiveFrom = &effective_from
+		}
+
+		item, err := api.SetItemPrice(request)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return printJSON(stdout, item)
+		}
+		if request.EffectiveFrom != nil {
+			fmt.Fprintf(
+				stdout, "%s (%s) costs %d from %s\n",
+				item.ItemName, item.SKU, item.Cost,
+				request.EffectiveFrom.Local().Format(time.DateTime),
+			)
+			return nil
+		}
+		fmt.Fprintf(stdout, "%s (%s) now costs %d\n", item.ItemName, item.SKU, item.Cost)
+		return nil
+
+	case "history":
+		if len(args) != 2 {
+			return errUsage
+		}
+		sku, name := itemRef(args[1])
+		history, err := api.GetPriceHistory(aetest.PriceHistoryRequest{SKU: sku, ItemName: name})
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return printJSON(stdout, history)
+		}
+
+		fmt.Fprintf(stdout, "%s (%s)\n\n", history.ItemName, history.SKU)
+		w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "FROM\tCOST\t")
+		for _, price := range history.Prices {
+			from, note := "-", ""
+			if price.EffectiveFrom != nil {
+				from = price.EffectiveFrom.Local().Format(time.DateTime)
+			}
+			if price.Scheduled {
+				note = "scheduled"
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\n", from, price.Cost, note)
+		}
+		return w.Flush()
+
+	case "remove":
+		if len(args) != 2 {
+			return errUsage
+		}
+		sku, name := itemRef(args[1])
+		err := api.RemoveItem(aetest.RemoveItemRequest{SKU: sku, ItemName: name})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%s removed\n", args[1])
+		return nil
+	}
+
+	return errUsage
+}
+
+// aliasFlags collects repeated `-alias NAME` flags.
+type aliasFlags []string
+
+func (f *aliasFlags) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *aliasFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// exportOrders writes every stored order to a file or stdout.
+func exportOrders(api client, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format_name := fs.String("format", "jsonl", "export format, csv or jsonl")
+	output := fs.String("o", "", "output file, defaults to stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	format, err := aetest.ParseExportFormat(*format_name)
+	if err != nil {
+		return err
+	}
+
+	w := stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	return api.ExportOrders(format, w)
+}
+
+// importOrders loads exported orders from a file or stdin. The format is
+// detected from the file extension unless given.
+func importOrders(
+	api client,
+	args []string,
+	stdin io.Reader,
+	stdout io.Writer,
+) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format_name := fs.String("format", "", "import format, csv or jsonl")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	reader := stdin
+	if path := fs.Arg(0); path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		reader = f
+
+		if *format_name == "" {
+			*format_name = strings.TrimPrefix(filepath.Ext(path), ".")
+		}
+	}
+
+	format, err := aetest.ParseExportFormat(*format_name)
+	if err != nil {
+		return err
+	}
+
+	imported, err := api.ImportOrders(format, reader)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(stdout, imported)
+	}
+	fmt.Fprintf(stdout, "imported %d orders\n", imported.Imported)
+	return nil
+}
+
+// printSummary prints a single OrderSummary as a table of its items followed
+// by the order total.
+func printSummary(stdout io.Writer, summary aetest.OrderSummary) error {
+	if *asJSON {
+		return printJSON(stdout, summary)
+	}
+
+	if summary.OrderID != "" {
+		fmt.Fprintf(
+			stdout, "Order %s created %s",
+			summary.OrderID,
+			summary.CreatedAt.Local().Format(time.DateTime),
+		)
+		if summary.Status == aetest.OrderCancelled {
+			fmt.Fprintf(
+				stdout, ", cancelled %s",
+				summary.UpdatedAt.Local().Format(time.DateTime),
+			)
+		}
+		fmt.Fprint(stdout, "\n\n")
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ITEM\tQUANTITY\tCOST")
+	for _, item := range summary.Summary {
+		fmt.Fprintf(w, "%s\t%d\t%d\n", item.ItemName, item.Quantity, item.Cost)
+	}
+	fmt.Fprintf(w, "\t\t\nTOTAL\t\t%d\n", summary.TotalCost)
+	return w.Flush()
+}
+
+// printExplanation prints every step taken to price an order.
+func printExplanation(stdout io.Writer, explanation aetest.PriceExplanation) error {
+	if *asJSON {
+		return printJSON(stdout, explanation)
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STEP\tSKU\tAMOUNT\tDETAIL")
+	for _, step := range explanation.Steps {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", step.Kind, step.SKU, step.Amount, step.Detail)
+	}
+	return w.Flush()
+}
+
+func printJSON(stdout io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func envOr(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: aectl [flags] <command> [arguments]
+
+commands:
+  submit  [-f FILE] [--item NAME=QTY ...]  submit an order
+  quote   [-f FILE] [--item NAME=QTY ...]  price an order without storing it
+  explain [-at T] [-f FILE] [--item NAME=QTY ...]
+                                           show every step taken to price an order
+  get     <order_id>                       get a single stored order
+  cancel  <order_id>                       cancel a stored order
+  list    [-item NAME] [-min-total N] [-max-total N] [-from T] [-to T]
+                                           list stored orders
+  catalog list                             list orderable items
+  catalog set [-sku SKU] [-alias NAME ...] [-from T] <item_name> <cost>
+                                           add or update an item, from T when given
+  catalog history <item>                   list the past and scheduled costs of an item
+  catalog remove <item>                    remove an item
+  export  [-format csv|jsonl] [-o FILE]    export all stored orders
+  import  [-format csv|jsonl] [FILE]       import exported orders
+
+items are referred to by name, or by SKU with a "sku:" prefix.
+submit, quote and explain take -channel and -group to select a price list.
+
+flags:
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if err := run(flag.Args(), os.Stdin, os.Stdout); err != nil {
+		if errors.Is(err, errUsage) {
+			flag.Usage()
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
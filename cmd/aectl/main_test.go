@@ -0,0 +1,132 @@
+package main
+
+import (
+	"aetest"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestItemFlags(t *testing.T) {
+	var items itemFlags
+	require.NoError(t, items.Set("Apples=2"))
+	require.NoError(t, items.Set("sku:FRUIT-002=3"))
+	require.Equal(t, itemFlags{
+		{ItemName: "Apples", Quantity: 2},
+		{SKU: "FRUIT-002", Quantity: 3},
+	}, items)
+	require.Equal(t, "Apples=2,sku:FRUIT-002=3", items.String())
+
+	for _, value := range []string{"Apples", "Apples=", "Apples=two"} {
+		require.Error(t, items.Set(value), value)
+	}
+}
+
+func TestParseOrder(t *testing.T) {
+	parse := func(args []string, stdin string) (aetest.OrderRequest, error) {
+		fs := flag.NewFlagSet("submit", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		return parseOrder(fs, args, strings.NewReader(stdin))
+	}
+
+	// Items are given as repeated flags.
+	request, err := parse([]string{"--item", "Apples=2", "-item", "sku:FRUIT-002=3", "-group", "trade"}, "")
+	require.NoError(t, err)
+	require.Equal(t, aetest.OrderRequest{
+		Cart: []aetest.Item{
+			{ItemName: "Apples", Quantity: 2},
+			{SKU: "FRUIT-002", Quantity: 3},
+		},
+		CustomerGroup: "trade",
+	}, request)
+
+	// Orders are read from stdin, or from a file, the flags taking
+	// precedence over its channel.
+	order := `{"cart": [{"item_name": "Apples", "quantity": 1}], "channel": "web"}`
+	request, err = parse(nil, order)
+	require.NoError(t, err)
+	require.Equal(t, "web", request.Channel)
+	require.Len(t, request.Cart, 1)
+
+	path := filepath.Join(t.TempDir(), "order.json")
+	require.NoError(t, os.WriteFile(path, []byte(order), 0o644))
+	request, err = parse([]string{"-f", path, "-channel", "pos"}, "")
+	require.NoError(t, err)
+	require.Equal(t, "pos", request.Channel)
+	require.Equal(t, "Apples", request.Cart[0].ItemName)
+
+	for name, args := range map[string][]string{
+		"file and items": {"-f", path, "--item", "Apples=1"},
+		"bad item":       {"--item", "Apples"},
+		"unknown flag":   {"-x"},
+		"missing file":   {"-f", filepath.Join(t.TempDir(), "missing.json")},
+	} {
+		_, err := parse(args, "")
+		require.Error(t, err, name)
+	}
+	_, err = parse(nil, "not json")
+	require.ErrorContains(t, err, "reading order")
+}
+
+func TestRun(t *testing.T) {
+	api := newTestClient(t)
+	defer func(previous string, previous_json bool) {
+		*addr, *asJSON = previous, previous_json
+	}(*addr, *asJSON)
+	*addr = api.addr
+
+	aectl := func(stdin string, args ...string) (string, error) {
+		var stdout bytes.Buffer
+		err := run(args, strings.NewReader(stdin), &stdout)
+		return stdout.String(), err
+	}
+
+	out, err := aectl("", "quote", "--item", "Apples=2", "--item", "Oranges=3")
+	require.NoError(t, err)
+	require.Contains(t, out, "TOTAL")
+	require.Contains(t, out, "110")
+
+	*asJSON = true
+	out, err = aectl(`{"cart": [{"item_name": "Apples", "quantity": 2}]}`, "submit")
+	require.NoError(t, err)
+	var summary aetest.OrderSummary
+	require.NoError(t, json.Unmarshal([]byte(out), &summary))
+	require.Equal(t, 60, summary.TotalCost)
+
+	out, err = aectl("", "list", "-item", "apples", "-min-total", "60")
+	require.NoError(t, err)
+	var listed aetest.AllOrders
+	require.NoError(t, json.Unmarshal([]byte(out), &listed))
+	require.Len(t, listed.Orders, 1)
+	*asJSON = false
+
+	out, err = aectl("", "catalog", "set", "-sku", "FRUIT-003", "Pears", "40")
+	require.NoError(t, err)
+	require.Equal(t, "Pears (FRUIT-003) now costs 40\n", out)
+	_, err = aectl("", "catalog", "remove", "sku:FRUIT-003")
+	require.NoError(t, err)
+
+	_, err = aectl("", "cancel", summary.OrderID)
+	require.NoError(t, err)
+	_, err = aectl("", "cancel", summary.OrderID)
+	require.ErrorContains(t, err, aetest.ErrOrderCancelled.Error())
+
+	for _, args := range [][]string{
+		{},
+		{"unknown"},
+		{"get"},
+		{"get", "a", "b"},
+		{"catalog"},
+		{"catalog", "set", "Pears"},
+	} {
+		_, err := aectl("", args...)
+		require.ErrorIs(t, err, errUsage, args)
+	}
+}
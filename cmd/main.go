@@ -3,52 +3,550 @@ package main
 import (
 	"aetest"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
+
+	// Promotions may be in any time zone, the database is embedded so that
+	// the server does not depend on the host having one.
+	_ "time/tzdata"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 var (
 	httpAddr = flag.String("http", ":3000", "http listen address")
+	grpcAddr = flag.String("grpc", ":3001", "grpc listen address")
+
+	requestTimeout    = flag.Duration("request-timeout", 10*time.Second, "deadline for handling each http request")
+	readHeaderTimeout = flag.Duration("read-header-timeout", 5*time.Second, "time allowed to read http request headers")
+	readTimeout       = flag.Duration("read-timeout", 15*time.Second, "time allowed to read an entire http request")
+	writeTimeout      = flag.Duration("write-timeout", 30*time.Second, "time allowed to write an http response")
+	idleTimeout       = flag.Duration("idle-timeout", 2*time.Minute, "time an idle keep-alive connection is kept open")
+	shutdownTimeout   = flag.Duration("shutdown-timeout", 20*time.Second, "time allowed to drain in-flight requests on shutdown")
+	shutdownDelay     = flag.Duration("shutdown-delay", 0, "time /readyz fails before the servers stop accepting requests on shutdown")
+	maxBodyBytes      = flag.Int64("max-body-bytes", 1<<20, "maximum size of an http request body in bytes, other than imports")
+	maxImportBytes    = flag.Int64("max-import-bytes", 64<<20, "maximum size of an /import-orders request body in bytes")
+	maxCartLines      = flag.Int("max-cart-lines", aetest.DefaultMaxCartLines, "maximum number of lines in an order's cart, 0 for no limit")
+	duplicateLines    = flag.String("duplicate-lines", "merge", "handling of carts with several lines for an item: merge or reject")
+	rateLimit         = flag.Float64("rate-limit", 10, "requests per second allowed for each API key or client IP, 0 for no limit")
+	rateBurst         = flag.Int("rate-burst", 20, "requests allowed in a burst above the rate limit")
+
+	tlsCert = flag.String("tls-cert", "", "TLS certificate file, serves https and grpc over TLS when set with -tls-key")
+	tlsKey  = flag.String("tls-key", "", "TLS private key file")
+
+	feedHistory   = flag.Int("feed-history", 1000, "recent order feed events kept for subscribers resuming with Last-Event-ID")
+	feedBuffer    = flag.Int("feed-buffer", 64, "order feed events queued for each subscriber")
+	slowConsumers = flag.String("slow-consumers", "disconnect", "order feed subscribers whose queue is full: disconnect or drop")
+
+	receiptStore    = flag.String("receipt-store", "AEtest", "store name printed at the top of receipts")
+	receiptFooter   = flag.String("receipt-footer", "", "text printed at the bottom of receipts")
+	receiptTemplate = flag.String("receipt-template", "", "HTML template file for receipts, the built in template when empty")
+
+	smtpAddr  = flag.String("smtp-addr", "", "SMTP server order confirmations are sent through, none are sent when empty; the password is read from SMTP_PASSWORD")
+	smtpUser  = flag.String("smtp-user", "", "SMTP username, no authentication when empty")
+	emailFrom = flag.String("email-from", "orders@localhost", "sender of order confirmation emails")
+
+	webhookAttempts = flag.Int("webhook-attempts", 5, "attempts made at each webhook delivery before it is dead-lettered")
+	webhookBackoff  = flag.Duration("webhook-backoff", time.Second, "wait before the first webhook retry, doubling after each attempt")
+	webhookHosts    = flag.String("webhook-hosts", "", "comma separated hosts webhooks may be subscribed to, *.example.com allows subdomains; none are allowed when empty")
+
+	promotionsFile = flag.String("promotions", "", "JSON file of time limited promotions")
+	discountPolicy = flag.String("discount-policy", "best-for-customer", "how the offers on an item are combined: best-for-customer, first-match or all-stackable")
+	priceListsFile = flag.String("price-lists", "", "JSON file of price lists for customer groups and channels")
+
+	storeFile = flag.String("store-file", "", "JSON lines file the orders are loaded from on start and saved to on shutdown")
+
+	events      = flag.String("events", "none", "where order and price events are published: none, file or nats")
+	eventsFile  = flag.String("events-file", "events.jsonl", "JSON lines file events are appended to with -events=file")
+	natsURL     = flag.String("nats-url", nats.DefaultURL, "NATS server events are published to with -events=nats")
+	natsSubject = flag.String("nats-subject", "aetest.events", "subject prefix of the events published to NATS")
+	outboxFile  = flag.String("outbox-file", "", "JSON lines file every event is written to until it is published, kept in memory when empty")
+
+	traceExporter = flag.String("trace-exporter", "none", "trace exporter: none, stdout or otlp")
+	otlpEndpoint  = flag.String("otlp-endpoint", "localhost:4317", "OTLP gRPC collector address")
+	otlpInsecure  = flag.Bool("otlp-insecure", false, "disable TLS to the OTLP collector")
 )
 
 func run() error {
 	// Parse input flags.
 	flag.Parse()
 	ctx := context.Background()
-	errChan := make(chan error)
+	if (*tlsCert == "") != (*tlsKey == "") {
+		return errors.New("-tls-cert and -tls-key must be set together")
+	}
+	duplicate_lines, err := aetest.ParseDuplicateLines(*duplicateLines)
+	if err != nil {
+		return err
+	}
+	discount_policy, err := aetest.ParseDiscountPolicy(*discountPolicy)
+	if err != nil {
+		return err
+	}
+	slow_consumers, err := aetest.ParseSlowConsumerPolicy(*slowConsumers)
+	if err != nil {
+		return err
+	}
+
+	// Both servers report fatal errors here, the channel is buffered so that
+	// neither blocks if the other has already failed.
+	errChan := make(chan error, 2)
+
+	// Receipts can be branded with a template of their own.
+	receipt_config := aetest.ReceiptConfig{
+		StoreName: *receiptStore,
+		Footer:    *receiptFooter,
+	}
+	if *receiptTemplate != "" {
+		receipt_config.HTMLTemplate, err = aetest.ParseReceiptTemplate(*receiptTemplate)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Structured JSON logs are written to stdout, gin's own debug output is
+	// silenced so that only JSON lines are written.
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	gin.SetMode(gin.ReleaseMode)
+
+	// Spans are exported as configured, the provider is set globally so that
+	// the service and router share it. Buffered spans are flushed on exit.
+	tracer_provider, err := aetest.NewTracerProvider(ctx, aetest.TracingConfig{
+		Exporter:     *traceExporter,
+		ServiceName:  "aetest",
+		OTLPEndpoint: *otlpEndpoint,
+		OTLPInsecure: *otlpInsecure,
+		Writer:       os.Stderr,
+	})
+	if err != nil {
+		return err
+	}
+	defer tracer_provider.Shutdown(ctx)
+	otel.SetTracerProvider(tracer_provider)
 
 	item_store, discount, order_store := aetest.NewStore()
 
-	// Create a new service that will handle the order API's requests.
-	service := aetest.New(item_store, discount, order_store)
-	router := aetest.NewOrdersRouter(service)
+	// Create a new service that will handle the order API's requests. The
+	// service is instrumented so that both HTTP and gRPC calls are recorded
+	// in the metrics served at `/metrics`, and the events of the changes made
+	// through either are relayed from the outbox to the order feed.
+	metrics := aetest.NewMetrics()
+	webhooks := aetest.NewWebhooks(aetest.WebhookConfig{
+		MaxAttempts:    *webhookAttempts,
+		InitialBackoff: *webhookBackoff,
+		AllowedHosts:   strings.FieldsFunc(*webhookHosts, func(r rune) bool { return r == ',' || r == ' ' }),
+		Logger:         logger,
+	})
+	service_opts := []aetest.Option{
+		aetest.WithLogger(logger),
+		aetest.WithMaxCartLines(*maxCartLines),
+		aetest.WithDuplicateLines(duplicate_lines),
+		aetest.WithDiscountPolicy(discount_policy),
+	}
+	if *promotionsFile != "" {
+		promotions, err := aetest.LoadPromotionsFile(*promotionsFile)
+		if err != nil {
+			return err
+		}
+		service_opts = append(service_opts, aetest.WithPromotions(promotions...))
+		logger.Info("promotions loaded", "file", *promotionsFile, "promotions", len(promotions))
+	}
+	if *priceListsFile != "" {
+		price_lists, err := aetest.LoadPriceListsFile(*priceListsFile)
+		if err != nil {
+			return err
+		}
+		service_opts = append(service_opts, aetest.WithPriceLists(price_lists...))
+		logger.Info("price lists loaded", "file", *priceListsFile, "price_lists", len(price_lists))
+	}
 
-	// Ignoring logging, TLS and timeouts for simplicity.
+	// Every change to the stores is recorded in the outbox and relayed in
+	// the background to the event publisher and the webhook subscribers.
+	relays, err := openOutbox(logger)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// The relays are closed by shutdown once the servers have started.
+		if relays.stop == nil {
+			relays.close()
+		}
+	}()
+	if err := relays.addPublisher(); err != nil {
+		return err
+	}
+	if err := relays.add("webhooks", webhooks, nil); err != nil {
+		return err
+	}
+	feed := aetest.NewOrderFeed(aetest.FeedConfig{
+		History:       *feedHistory,
+		Buffer:        *feedBuffer,
+		SlowConsumers: slow_consumers,
+	})
+	if err := relays.add("feed", feed, nil); err != nil {
+		return err
+	}
+	service_opts = append(service_opts, aetest.WithOutbox(relays.outbox))
+
+	service := metrics.Instrument(aetest.New(
+		item_store, discount, order_store,
+		service_opts...,
+	))
+
+	// Customers giving an email address are sent a confirmation with their
+	// receipt, relayed the order.created events from the outbox.
+	receipts := aetest.NewReceiptRenderer(receipt_config)
+	var emails *aetest.EmailNotifier
+	if *smtpAddr != "" {
+		emails = aetest.NewEmailNotifier(aetest.EmailConfig{
+			SMTPAddr: *smtpAddr,
+			Username: *smtpUser,
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     *emailFrom,
+			Logger:   logger,
+		}, receipts)
+		if err := relays.add("email", emails, nil); err != nil {
+			return err
+		}
+	}
+
+	// Restore the orders saved when the server was last shut down. Restoring
+	// is not a change, the orders are imported into a service sharing the
+	// stores without an outbox so that no events are recorded.
+	if *storeFile != "" {
+		restore := aetest.New(item_store, discount, order_store)
+		imported, err := aetest.LoadOrdersFile(ctx, restore, *storeFile)
+		if err != nil {
+			return err
+		}
+		logger.Info("orders loaded", "file", *storeFile, "orders", imported)
+	}
+
+	// Every relay has been added, the events still in the outbox from the
+	// last run are published first.
+	relays.start()
+
+	// The health probes are registered after the logging and metrics
+	// middleware so that probes are recorded like any other request, but
+	// before the rate limit so that probes are never rejected.
+	health := aetest.NewHealth(service)
+	router_opts := []aetest.RouterOption{
+		aetest.WithTracing(tracer_provider),
+		aetest.WithRequestLogging(logger),
+		aetest.WithMetrics(metrics),
+		aetest.WithHealth(health),
+	}
+	if *rateLimit > 0 {
+		limiter := aetest.NewRateLimiter(*rateLimit, *rateBurst)
+		router_opts = append(router_opts, aetest.WithRateLimit(limiter))
+	}
+	// The order feed streams for as long as the subscriber is connected, so
+	// it is registered before the request timeout.
+	router_opts = append(
+		router_opts,
+		aetest.WithOrderFeed(feed),
+		aetest.WithRequestTimeout(*requestTimeout),
+		aetest.WithMaxBodySize(*maxBodyBytes),
+		aetest.WithMaxImportSize(*maxImportBytes),
+		aetest.WithWebhooks(webhooks),
+		aetest.WithReceipts(service, receipts),
+	)
+	router := aetest.NewOrdersRouter(service, router_opts...)
+
+	// The read timeouts bound how long a slow client can hold a connection
+	// before its request reaches the router, the write timeout must be longer
+	// than the request timeout so that timed out requests still get a response.
+	// The order feed is exempt from the write timeout.
 	server := &http.Server{
-		Addr:    *httpAddr, // read from input flag
-		Handler: router,
+		Addr:              *httpAddr, // read from input flag
+		Handler:           aetest.StreamingHandler(router),
+		ReadHeaderTimeout: *readHeaderTimeout,
+		ReadTimeout:       *readTimeout,
+		WriteTimeout:      *writeTimeout,
+		IdleTimeout:       *idleTimeout,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
 	}
+	// Open feed streams would otherwise keep shutdown waiting until the
+	// timeout, subscribers reconnect when the server is back.
+	server.RegisterOnShutdown(feed.Close)
 
-	// Start server in goroutine with graceful termination on fatal error.
 	go func() {
-		if err := server.ListenAndServe(); err != nil {
-			errChan <- server.Shutdown(ctx)
+		var err error
+		if *tlsCert != "" {
+			err = server.ListenAndServeTLS(*tlsCert, *tlsKey)
+		} else {
+			err = server.ListenAndServe()
+		}
+		// ErrServerClosed is returned once shutdown has begun.
+		if !errors.Is(err, http.ErrServerClosed) {
+			errChan <- err
 		}
 	}()
 
-	// Listen for user termination signal (CTRL+C), ungracefully terminate upon
-	// receiving this signal.
+	// Start the gRPC server on its own listen address, this shares the same
+	// service and therefore the same stores as the HTTP server.
+	var grpc_opts []grpc.ServerOption
+	if *tlsCert != "" {
+		creds, err := credentials.NewServerTLSFromFile(*tlsCert, *tlsKey)
+		if err != nil {
+			return err
+		}
+		grpc_opts = append(grpc_opts, grpc.Creds(creds))
+	}
+	grpcServer := aetest.NewOrdersGRPCServer(service, grpc_opts...)
 	go func() {
-		ch := make(chan os.Signal, 1)
-		signal.Notify(ch, syscall.SIGTERM, syscall.SIGINT)
-		errChan <- fmt.Errorf("%s", <-ch)
+		listener, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			errChan <- err
+			return
+		}
+		if err := grpcServer.Serve(listener); err != nil {
+			errChan <- err
+		}
 	}()
 
-	return <-errChan
+	// Run until the user terminates the server (CTRL+C or SIGTERM), or either
+	// server fails, then shut down gracefully.
+	signal_ctx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	var serve_err error
+	select {
+	case <-signal_ctx.Done():
+		logger.Info("shutting down", "timeout", *shutdownTimeout)
+	case serve_err = <-errChan:
+		logger.Error("server failed, shutting down", "error", serve_err)
+	}
+	// A second signal terminates immediately.
+	stop()
+
+	// Fail readiness first, giving the orchestrator the shutdown delay to
+	// stop routing new requests here before the servers stop accepting them.
+	health.SetShuttingDown()
+	time.Sleep(*shutdownDelay)
+
+	return errors.Join(serve_err, shutdown(logger, server, grpcServer, webhooks, emails, relays, service))
+}
+
+// shutdown stops both servers accepting new requests and waits, up to the
+// shutdown timeout, for in-flight requests to complete. Requests still running
+// at the deadline are cut off. Webhook deliveries in progress are given the
+// rest of the timeout, pending retries are dead-lettered. Queued confirmation
+// emails are sent in the time left, those that are not are logged. Pending
+// events are published in the time left, those that are not stay in the
+// outbox file. Finally the orders are saved to the store file so that no
+// completed order is lost.
+func shutdown(
+	logger *slog.Logger,
+	server *http.Server,
+	grpcServer *grpc.Server,
+	webhooks *aetest.Webhooks,
+	emails *aetest.EmailNotifier,
+	relays *eventRelays,
+	service aetest.Service,
+) error {
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+
+	var errs []error
+	if err := server.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("draining http requests: %w", err))
+		server.Close()
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		errs = append(errs, errors.New("draining grpc requests: deadline exceeded"))
+		grpcServer.Stop()
+	}
+
+	// The relays hand the last events to the webhooks before they are
+	// closed.
+	if err := relays.flush(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("publishing events: %w", err))
+	}
+
+	if err := webhooks.Close(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("delivering webhooks: %w", err))
+	}
+
+	if emails != nil {
+		if err := emails.Close(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("sending confirmation emails: %w", err))
+		}
+	}
+
+	if err := relays.close(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if *storeFile != "" {
+		// The store is saved even if draining timed out, neither server
+		// accepts new requests by now.
+		if err := aetest.SaveOrdersFile(context.Background(), service, *storeFile); err != nil {
+			errs = append(errs, fmt.Errorf("saving orders: %w", err))
+		} else {
+			logger.Info("orders saved", "file", *storeFile)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// eventRelays publishes the outbox's events to each of their consumers in the
+// background, every consumer has a relay of its own so that a slow or failing
+// consumer never holds back the others.
+type eventRelays struct {
+	outbox  *aetest.Outbox
+	logger  *slog.Logger
+	relays  []*aetest.Relay
+	stop    context.CancelFunc
+	stopped sync.WaitGroup
+
+	// closers release the consumers once the relays have stopped.
+	closers []func() error
+}
+
+// openOutbox opens the outbox, kept in -outbox-file when it is set. The
+// events left unpublished when the server last stopped are published once
+// the relays start.
+func openOutbox(logger *slog.Logger) (*eventRelays, error) {
+	outbox := aetest.NewOutbox()
+	if *outboxFile != "" {
+		var err error
+		outbox, err = aetest.OpenOutbox(*outboxFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	pending, err := outbox.Pending()
+	if err == nil && len(pending) > 0 {
+		logger.Info("unpublished events found", "file", *outboxFile, "events", len(pending))
+	}
+
+	return &eventRelays{outbox: outbox, logger: logger}, nil
+}
+
+// add creates a relay publishing the events to the consumer, close is called
+// once the relays have stopped and may be nil. Every relay must be added
+// before they are started.
+func (r *eventRelays) add(name string, consumer aetest.EventPublisher, close func() error) error {
+	relay, err := aetest.NewRelay(r.outbox, consumer, aetest.RelayConfig{
+		Name:   name,
+		Logger: r.logger,
+	})
+	if err != nil {
+		return err
+	}
+
+	r.relays = append(r.relays, relay)
+	if close != nil {
+		r.closers = append(r.closers, close)
+	}
+	return nil
+}
+
+// addPublisher adds a relay to the publisher selected by -events, if any.
+func (r *eventRelays) addPublisher() error {
+	switch *events {
+	case "none":
+		return nil
+	case "file":
+		publisher, err := aetest.NewFilePublisher(*eventsFile)
+		if err != nil {
+			return err
+		}
+		return r.add("events", publisher, publisher.Close)
+	case "nats":
+		// The client reconnects by itself, events are held in the outbox
+		// while the server is unavailable.
+		conn, err := nats.Connect(*natsURL, nats.Name("aetest"), nats.MaxReconnects(-1))
+		if err != nil {
+			return fmt.Errorf("connecting to nats: %w", err)
+		}
+		publisher, err := aetest.NewNATSPublisher(conn, *natsSubject)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		return r.add("events", publisher, conn.Drain)
+	default:
+		return fmt.Errorf("unknown -events %q, expected none, file or nats", *events)
+	}
+}
+
+// start runs every relay in the background.
+func (r *eventRelays) start() {
+	ctx, stop := context.WithCancel(context.Background())
+	r.stop = stop
+	for _, relay := range r.relays {
+		r.stopped.Add(1)
+		go func() {
+			defer r.stopped.Done()
+			relay.Run(ctx)
+		}()
+	}
+}
+
+// flush stops the relays and publishes the events still pending, until ctx
+// is done.
+func (r *eventRelays) flush(ctx context.Context) error {
+	if r.stop != nil {
+		r.stop()
+		r.stopped.Wait()
+	}
+
+	var errs []error
+	for _, relay := range r.relays {
+		if err := relay.Flush(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// close closes the outbox and the consumers. Events that could not be
+// published stay in the outbox file, they are lost when there is none.
+func (r *eventRelays) close() error {
+	var errs []error
+	pending, err := r.outbox.Pending()
+	switch {
+	case err != nil:
+		errs = append(errs, err)
+	case len(pending) > 0 && *outboxFile != "":
+		r.logger.Info("events left unpublished", "file", *outboxFile, "events", len(pending))
+	case len(pending) > 0:
+		errs = append(errs, fmt.Errorf("%d events were not published", len(pending)))
+	}
+	if err := r.outbox.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("closing outbox: %w", err))
+	}
+	for _, close := range r.closers {
+		if err := close(); err != nil {
+			errs = append(errs, fmt.Errorf("closing event publisher: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
 }
 
 func main() {
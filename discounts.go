@@ -0,0 +1,253 @@
+package aetest
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/johncgriffin/overflow"
+)
+
+// pricedItem is an item of an order, with the lines it was ordered on and
+// the total quantity of those lines.
+type pricedItem struct {
+	sku      string
+	lines    []int
+	cost     int
+	quantity int
+}
+
+// offer is a discount on an item of an order, either the item's ItemDiscount
+// or a promotion. The ItemDiscount has priority 0 and is not stackable.
+type offer struct {
+	// promotion is the index of the promotion in the promotions being
+	// planned, -1 for the ItemDiscount. id is the promotion's ID.
+	promotion int
+	id        string
+	priority  int
+	stackable bool
+	discount  int
+
+	// uncapped is the discount before it was capped by the promotion's
+	// MaxOrderDiscount.
+	uncapped int
+}
+
+// discountPlan is the discount given on each item of an order by a set of
+// offers.
+type discountPlan struct {
+	discounts []int
+	total     int
+
+	// offers are the offers considered on each item, given are those given
+	// with the discount each gave.
+	offers [][]offer
+	given  [][]offer
+
+	// lead is the highest priority of the offers given, it is only set when
+	// discounted.
+	lead       int
+	discounted bool
+
+	// exclusive is the ID of the exclusive promotion the plan gives, when it
+	// is one, and excluded the other offers on each item that it is given
+	// instead of.
+	exclusive string
+	excluded  [][]offer
+}
+
+// planOrderDiscounts works out the discount on each item of an order from the
+// promotions active at the time it is priced and the items' ItemDiscounts.
+//
+// An exclusive promotion is given on its own, no other offer applies to any
+// item of the order. Each exclusive promotion is planned on its own as well as
+// the other offers together, the plan given is the one with the largest total
+// discount or, with DiscountFirstMatch, the one with the highest priority
+// offer. The choice between plans is recorded in steps.
+func (svc orderService) planOrderDiscounts(
+	items []pricedItem,
+	priced_at time.Time,
+	steps *pricingSteps,
+) discountPlan {
+	var promotions, exclusive []Promotion
+	for _, promotion := range svc.promotions {
+		switch {
+		case !promotion.ActiveAt(priced_at):
+			continue
+		case promotion.Exclusive:
+			exclusive = append(exclusive, promotion)
+		default:
+			promotions = append(promotions, promotion)
+		}
+	}
+
+	others := svc.planDiscounts(items, promotions, true)
+	plan := others
+	for _, promotion := range exclusive {
+		exclusive_plan := svc.planDiscounts(items, []Promotion{promotion}, false)
+		preferred := svc.discount_policy.prefer(exclusive_plan, plan)
+		steps.exclusive(svc.discount_policy, promotion, exclusive_plan, plan, preferred)
+		if preferred {
+			plan = exclusive_plan
+			plan.exclusive = promotion.ID
+			plan.excluded = others.offers
+		}
+	}
+	return plan
+}
+
+// planDiscounts works out the discount on each item of an order from the
+// promotions, and from the items' ItemDiscounts when item_discounts is set.
+// Promotions with a MaxOrderDiscount are given on the items in turn until
+// their cap is used up.
+func (svc orderService) planDiscounts(
+	items []pricedItem,
+	promotions []Promotion,
+	item_discounts bool,
+) discountPlan {
+	plan := discountPlan{
+		discounts: make([]int, len(items)),
+		offers:    make([][]offer, len(items)),
+		given:     make([][]offer, len(items)),
+	}
+
+	// remaining is what is left of each promotion's cap.
+	remaining := make([]int, len(promotions))
+	for i, promotion := range promotions {
+		remaining[i] = promotion.MaxOrderDiscount
+	}
+
+	for i, item := range items {
+		var offers []offer
+
+		// Using the ItemDiscount lookup whether a discount exists for that
+		// item. If a discount is not found by the above logic this does not
+		// mean that the item does not exist in the store, it is fine to skip
+		// the discount.
+		if item_discounts {
+			svc.mu.RLock()
+			calculate_discount, ok := svc.discount[item.sku]
+			svc.mu.RUnlock()
+			if ok {
+				discount := calculate_discount(item.cost, item.quantity)
+				offers = append(offers, offer{
+					promotion: -1,
+					discount:  discount,
+					uncapped:  discount,
+				})
+			}
+		}
+		for j, promotion := range promotions {
+			if !promotion.appliesTo(item.sku) {
+				continue
+			}
+			uncapped := promotion.discount(item.cost, item.quantity)
+			discount := uncapped
+			if promotion.MaxOrderDiscount > 0 {
+				discount = min(discount, remaining[j])
+			}
+			offers = append(offers, offer{
+				j, promotion.ID, promotion.Priority, promotion.Stackable, discount, uncapped,
+			})
+		}
+		plan.offers[i] = offers
+
+		// The offers given never take more than the cost of the item's
+		// lines, which is known not to overflow. Offers are given in order
+		// of priority so the highest priority offers are given in full.
+		line_cost := item.cost * item.quantity
+		for _, given := range svc.discount_policy.choose(offers) {
+			discount := min(given.discount, line_cost-plan.discounts[i])
+			plan.discounts[i] += discount
+			if given.promotion >= 0 {
+				remaining[given.promotion] -= discount
+			}
+			given.discount = discount
+			plan.given[i] = append(plan.given[i], given)
+			if !plan.discounted || given.priority > plan.lead {
+				plan.lead = given.priority
+				plan.discounted = true
+			}
+		}
+
+		// The discounts are at most the cost of the lines, which add up to
+		// the order's total without overflowing.
+		plan.total += plan.discounts[i]
+	}
+
+	return plan
+}
+
+// choose returns the offers on an item that are given under the policy, in
+// order of priority. Offers giving no discount are never given.
+func (policy DiscountPolicy) choose(offers []offer) []offer {
+	var giving []offer
+	for _, o := range offers {
+		if o.discount > 0 {
+			giving = append(giving, o)
+		}
+	}
+	if len(giving) == 0 {
+		return nil
+	}
+	// Offers of the same priority keep their order, the ItemDiscount first
+	// then the promotions in the order they were added.
+	sort.SliceStable(giving, func(i, j int) bool {
+		return giving[i].priority > giving[j].priority
+	})
+
+	var stackable []offer
+	for _, o := range giving {
+		if o.stackable {
+			stackable = append(stackable, o)
+		}
+	}
+
+	switch policy {
+	case DiscountAllStackable:
+		return giving
+	case DiscountFirstMatch:
+		if !giving[0].stackable {
+			return giving[:1]
+		}
+		return stackable
+	default:
+		best := giving[0]
+		for _, o := range giving[1:] {
+			if o.discount > best.discount {
+				best = o
+			}
+		}
+		if sumDiscounts(stackable) > best.discount {
+			return stackable
+		}
+		return []offer{best}
+	}
+}
+
+// prefer reports whether plan is preferred over another plan under the
+// policy. A plan giving no discount is never preferred.
+func (policy DiscountPolicy) prefer(plan discountPlan, over discountPlan) bool {
+	if !plan.discounted {
+		return false
+	}
+	if policy == DiscountFirstMatch {
+		return !over.discounted || plan.lead > over.lead
+	}
+	return plan.total > over.total
+}
+
+// sumDiscounts adds up the discounts of the offers. Each discount is at most
+// the cost of the item's lines but several together may overflow, the sum is
+// then the largest int as it is capped at the cost of the lines anyway.
+func sumDiscounts(offers []offer) int {
+	total := 0
+	for _, o := range offers {
+		var ok bool
+		total, ok = overflow.Add(total, o.discount)
+		if !ok {
+			return math.MaxInt
+		}
+	}
+	return total
+}
@@ -0,0 +1,104 @@
+package aetest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// quoteWith prices goodOrderRequest, 2 apples and 3 oranges costing 195
+// before discounts, with the promotions and policy.
+func quoteWith(t *testing.T, policy DiscountPolicy, promotions ...Promotion) OrderSummary {
+	items, discounts, orders := NewStore()
+	svc := New(items, discounts, orders, WithDiscountPolicy(policy), WithPromotions(promotions...))
+	summary, err := svc.Quote(context.Background(), goodOrderRequest)
+	require.NoError(t, err)
+	require.Equal(t, policy, summary.DiscountPolicy)
+	return summary
+}
+
+func TestDiscountPolicies(t *testing.T) {
+	// Apples have buy one get one free worth 60, as well as these offers.
+	// Oranges have 3 for 2 worth 25 and 40% off worth 30.
+	promotions := []Promotion{
+		{ID: "apples-off", SKUs: []string{"FRUIT-001"}, AmountOff: 10, Stackable: true, Priority: 2},
+		{ID: "half-price-apples", SKUs: []string{"FRUIT-001"}, PercentOff: 50, Stackable: true, Priority: 1},
+		{ID: "apple-deal", SKUs: []string{"FRUIT-001"}, AmountOff: 5, Priority: 5},
+		{ID: "oranges-off", SKUs: []string{"FRUIT-002"}, PercentOff: 40, Priority: 3},
+	}
+
+	for _, test := range []struct {
+		policy    DiscountPolicy
+		apples    int
+		oranges   int
+		totalCost int
+	}{
+		// The stackable apple offers together beat buy one get one free.
+		{DiscountBestForCustomer, 80, 30, 85},
+		// The apple deal has the highest priority and does not stack.
+		{DiscountFirstMatch, 10, 30, 155},
+		// Every offer is given, up to the cost of the lines.
+		{DiscountAllStackable, 120, 55, 20},
+	} {
+		summary := quoteWith(t, test.policy, promotions...)
+		require.Equal(t, test.apples, summary.Summary[0].Discount, test.policy)
+		require.Equal(t, test.oranges, summary.Summary[1].Discount, test.policy)
+		require.Equal(t, test.totalCost, summary.TotalCost, test.policy)
+	}
+
+	// Without promotions every policy gives the item discounts.
+	for _, policy := range []DiscountPolicy{DiscountBestForCustomer, DiscountFirstMatch, DiscountAllStackable} {
+		require.Equal(t, 110, quoteWith(t, policy).TotalCost, policy)
+	}
+}
+
+func TestExclusivePromotions(t *testing.T) {
+	staff := func(percent_off int) Promotion {
+		return Promotion{
+			ID:         "staff",
+			SKUs:       []string{"FRUIT-001", "FRUIT-002"},
+			PercentOff: percent_off,
+			Priority:   1,
+			Exclusive:  true,
+		}
+	}
+
+	// 30% off everything is less than the item discounts, 60% off is more
+	// and is given without them.
+	require.Equal(t, 110, quoteWith(t, DiscountBestForCustomer, staff(30)).TotalCost)
+	summary := quoteWith(t, DiscountBestForCustomer, staff(60))
+	require.Equal(t, 78, summary.TotalCost)
+	require.Equal(t, 72, summary.Summary[0].Discount)
+	require.Equal(t, 45, summary.Summary[1].Discount)
+
+	// The staff discount has a higher priority than the item discounts.
+	require.Equal(t, 137, quoteWith(t, DiscountFirstMatch, staff(30)).TotalCost)
+
+	// Exclusive promotions are not stacked, even when every offer is.
+	require.Equal(t, 78, quoteWith(t, DiscountAllStackable, staff(60)).TotalCost)
+}
+
+func TestMaxOrderDiscount(t *testing.T) {
+	// 20 off every item, at most 50 per order. The apples take 40 of it,
+	// leaving 10 off the oranges.
+	summary := quoteWith(t, DiscountAllStackable, Promotion{
+		ID:               "twenty-off",
+		SKUs:             []string{"FRUIT-001", "FRUIT-002"},
+		AmountOff:        20,
+		MaxOrderDiscount: 50,
+		Stackable:        true,
+	})
+	require.Equal(t, 100, summary.Summary[0].Discount)
+	require.Equal(t, 35, summary.Summary[1].Discount)
+	require.Equal(t, 60, summary.TotalCost)
+}
+
+func TestParseDiscountPolicy(t *testing.T) {
+	policy, err := ParseDiscountPolicy("first-match")
+	require.NoError(t, err)
+	require.Equal(t, DiscountFirstMatch, policy)
+
+	_, err = ParseDiscountPolicy("cheapest")
+	require.Error(t, err)
+}
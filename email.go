@@ -0,0 +1,364 @@
+package aetest
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"sync"
+	"time"
+)
+
+// ErrEmailQueueFull is returned when a confirmation cannot be queued because
+// the queue is full, the confirmation is not sent.
+var ErrEmailQueueFull = errors.New("email queue full")
+
+// ErrEmailNotifierClosed is returned when queueing a confirmation with a
+// closed EmailNotifier.
+var ErrEmailNotifierClosed = errors.New("email notifier closed")
+
+// EmailConfig configures the sending of order confirmations, zero values use
+// the defaults.
+type EmailConfig struct {
+	// SMTPAddr is the host and port of the SMTP server, e.g.
+	// "smtp.example.com:587". STARTTLS is used when the server supports it.
+	SMTPAddr string
+
+	// Username and Password authenticate with the server using PLAIN auth
+	// when Username is set. Credentials are only sent over TLS, or to a
+	// server on localhost.
+	Username string
+	Password string
+
+	// From is the sender of every confirmation.
+	From string
+
+	// Workers is the number of emails sent at once, defaulting to 2.
+	// QueueSize is the number of confirmations waiting to be sent before
+	// further confirmations are dropped, defaulting to 1000.
+	Workers   int
+	QueueSize int
+
+	// MaxAttempts is the number of times sending an email is attempted
+	// before it is given up, defaulting to 5.
+	MaxAttempts int
+
+	// InitialBackoff is the wait before the first retry, it doubles after
+	// every failed attempt up to MaxBackoff. Defaults to 1s and 1m.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// Timeout limits each attempt, from connecting to the server to the
+	// email being accepted, defaulting to 30s.
+	Timeout time.Duration
+
+	// Logger logs confirmations that could not be sent, nothing is logged
+	// by default.
+	Logger *slog.Logger
+}
+
+// EmailNotifier emails a confirmation to the customer of every order placed
+// with an email address. The email has the order's receipt both as plain text
+// and as HTML. It is an EventPublisher, relayed the order.created events from
+// the Outbox.
+//
+// Confirmations are queued and sent in the background so that a slow or
+// unavailable SMTP server never delays an order. Failed attempts are retried
+// with exponential backoff, confirmations that fail every attempt are logged.
+type EmailNotifier struct {
+	config   EmailConfig
+	receipts *ReceiptRenderer
+
+	mu     sync.Mutex
+	queue  chan confirmationEmail
+	closed bool
+
+	// abandon is closed when Close gives up waiting, queued emails and
+	// pending retries are dropped.
+	abandon      chan struct{}
+	abandon_once sync.Once
+	wg           sync.WaitGroup
+}
+
+// confirmationEmail is a queued confirmation.
+type confirmationEmail struct {
+	to    string
+	order OrderSummary
+}
+
+// NewEmailNotifier creates an EmailNotifier and starts its workers. Receipts
+// in the emails are rendered by receipts.
+func NewEmailNotifier(config EmailConfig, receipts *ReceiptRenderer) *EmailNotifier {
+	if config.Workers <= 0 {
+		config.Workers = 2
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 1000
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 5
+	}
+	if config.InitialBackoff <= 0 {
+		config.InitialBackoff = time.Second
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = time.Minute
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 30 * time.Second
+	}
+	if config.Logger == nil {
+		config.Logger = discardLogger
+	}
+	config.Logger = withRequestID(config.Logger)
+
+	n := &EmailNotifier{
+		config:   config,
+		receipts: receipts,
+		queue:    make(chan confirmationEmail, config.QueueSize),
+		abandon:  make(chan struct{}),
+	}
+	for i := 0; i < config.Workers; i++ {
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			n.work()
+		}()
+	}
+	return n
+}
+
+// Send queues a confirmation of the order to be emailed to the address. It
+// never blocks, the confirmation is dropped and an error returned if the
+// queue is full or the notifier is closed.
+func (n *EmailNotifier) Send(to string, order OrderSummary) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.closed {
+		return ErrEmailNotifierClosed
+	}
+	select {
+	case n.queue <- confirmationEmail{to, order}:
+		return nil
+	default:
+		return ErrEmailQueueFull
+	}
+}
+
+// Publish queues a confirmation for an order.created event with an email
+// address, other events are ignored. A confirmation that cannot be queued
+// stays in the outbox, the relay publishes it again once the queue has room.
+func (n *EmailNotifier) Publish(ctx context.Context, event Event) error {
+	if event.Type != EventOrderCreated || event.Order == nil || event.Email == "" {
+		return nil
+	}
+	return n.Send(event.Email, *event.Order)
+}
+
+// sendsConfirmations marks the EmailNotifier as the publisher relayed the
+// customer's email address.
+func (n *EmailNotifier) sendsConfirmations() {}
+
+// Close stops accepting confirmations and waits, until ctx is done, for the
+// queued confirmations to be sent. Confirmations still queued or waiting to
+// be retried when ctx is done are dropped and logged.
+func (n *EmailNotifier) Close(ctx context.Context) error {
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.queue)
+	}
+	n.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		n.abandon_once.Do(func() { close(n.abandon) })
+		<-done
+		return ctx.Err()
+	}
+}
+
+// work sends queued confirmations until the queue is closed.
+func (n *EmailNotifier) work() {
+	for email := range n.queue {
+		select {
+		case <-n.abandon:
+			n.failed(email, 0, errors.New("shut down before sending"))
+			continue
+		default:
+		}
+		n.attempt(email)
+	}
+}
+
+// attempt makes every attempt at sending a confirmation, waiting between
+// attempts.
+func (n *EmailNotifier) attempt(email confirmationEmail) {
+	message, err := n.message(email)
+	if err != nil {
+		n.failed(email, 0, err)
+		return
+	}
+
+	backoff := n.config.InitialBackoff
+	for attempts := 1; ; attempts++ {
+		err = n.send(email.to, message)
+		if err == nil {
+			return
+		}
+		if attempts >= n.config.MaxAttempts {
+			n.failed(email, attempts, err)
+			return
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-n.abandon:
+			timer.Stop()
+			n.failed(email, attempts, fmt.Errorf("shut down before retrying: %w", err))
+			return
+		}
+		backoff = min(backoff*2, n.config.MaxBackoff)
+	}
+}
+
+func (n *EmailNotifier) failed(email confirmationEmail, attempts int, err error) {
+	n.config.Logger.Warn(
+		"order confirmation email failed",
+		"order_id", email.order.OrderID,
+		"attempts", attempts,
+		"error", err,
+	)
+}
+
+// message builds the confirmation as a multipart/alternative email, with the
+// plain text receipt followed by the preferred HTML receipt.
+func (n *EmailNotifier) message(email confirmationEmail) ([]byte, error) {
+	var text, html bytes.Buffer
+	if err := n.receipts.Text(&text, email.order); err != nil {
+		return nil, err
+	}
+	if err := n.receipts.HTML(&html, email.order); err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		content_type string
+		content      []byte
+	}{
+		{"text/plain; charset=utf-8", text.Bytes()},
+		{"text/html; charset=utf-8", html.Bytes()},
+	} {
+		writer, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.content_type},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoder := quotedprintable.NewWriter(writer)
+		if _, err := encoder.Write(part.content); err != nil {
+			return nil, err
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	subject := fmt.Sprintf("%s order confirmation %s", n.receipts.config.StoreName, email.order.OrderID)
+	var message bytes.Buffer
+	for _, header := range [][2]string{
+		{"From", n.config.From},
+		{"To", email.to},
+		{"Subject", mime.QEncoding.Encode("utf-8", subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		// The Message-ID is derived from the order so that a
+		// confirmation sent twice can be recognised as a duplicate.
+		{"Message-ID", fmt.Sprintf("<%s.confirmation@aetest>", email.order.OrderID)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + parts.Boundary()},
+	} {
+		fmt.Fprintf(&message, "%s: %s\r\n", header[0], header[1])
+	}
+	message.WriteString("\r\n")
+	message.Write(body.Bytes())
+	return message.Bytes(), nil
+}
+
+// send makes a single attempt at sending an email.
+func (n *EmailNotifier) send(to string, message []byte) error {
+	host, _, err := net.SplitHostPort(n.config.SMTPAddr)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialTimeout("tcp", n.config.SMTPAddr, n.config.Timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(n.config.Timeout))
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if n.config.Username != "" {
+		auth := smtp.PlainAuth("", n.config.Username, n.config.Password, host)
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(n.config.From); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+	data, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := data.Write(message); err != nil {
+		return err
+	}
+	if err := data.Close(); err != nil {
+		return err
+	}
+
+	// The email has been accepted, failing to say goodbye must not send it
+	// again.
+	client.Quit()
+	return nil
+}
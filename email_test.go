@@ -0,0 +1,230 @@
+package aetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSMTPServer speaks just enough SMTP for a client to send emails, without
+// TLS or authentication. Received emails are recorded rather than delivered.
+type fakeSMTPServer struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	failures int
+	messages []*mail.Message
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := &fakeSMTPServer{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return server
+}
+
+func (s *fakeSMTPServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// failNext makes the server reject the next count emails with a temporary
+// failure.
+func (s *fakeSMTPServer) failNext(count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = count
+}
+
+func (s *fakeSMTPServer) Messages() []*mail.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*mail.Message{}, s.messages...)
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 fake ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command, _, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(command) {
+		case "EHLO", "HELO":
+			text.PrintfLine("250 fake")
+		case "MAIL":
+			s.mu.Lock()
+			fail := s.failures > 0
+			if fail {
+				s.failures--
+			}
+			s.mu.Unlock()
+			if fail {
+				text.PrintfLine("451 try again later")
+				continue
+			}
+			text.PrintfLine("250 OK")
+		case "RCPT", "RSET", "NOOP":
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 end data with <CR><LF>.<CR><LF>")
+			message, err := mail.ReadMessage(text.DotReader())
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, message)
+			s.mu.Unlock()
+			text.PrintfLine("250 OK")
+		case "QUIT":
+			text.PrintfLine("221 bye")
+			return
+		default:
+			text.PrintfLine("502 command not implemented")
+		}
+	}
+}
+
+// emailParts returns the content of each part of a multipart email by content
+// type. The parts are decoded from quoted-printable as they are read.
+func emailParts(t *testing.T, message *mail.Message) map[string]string {
+	media_type, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	require.Equal(t, "multipart/alternative", media_type)
+
+	parts := map[string]string{}
+	reader := multipart.NewReader(message.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return parts
+		}
+		require.NoError(t, err)
+		content, err := io.ReadAll(part)
+		require.NoError(t, err)
+		media_type, _, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		require.NoError(t, err)
+		parts[media_type] = string(content)
+	}
+}
+
+func TestEmailConfirmation(t *testing.T) {
+	ctx := context.Background()
+	server := newFakeSMTPServer(t)
+	notifier := NewEmailNotifier(EmailConfig{
+		SMTPAddr: server.Addr(),
+		From:     "orders@example.com",
+	}, NewReceiptRenderer(ReceiptConfig{}))
+	outbox := NewOutbox()
+	relay, err := NewRelay(outbox, notifier, RelayConfig{Name: "email"})
+	require.NoError(t, err)
+	items, discounts, orders := NewStore()
+	svc := New(items, discounts, orders, WithOutbox(outbox))
+
+	request := goodOrderRequest
+	request.Email = "customer@example.com"
+	summary, err := svc.SimpleSummary(ctx, request)
+	require.NoError(t, err)
+
+	// Orders without an email address are not confirmed, invalid addresses
+	// are rejected with the order.
+	_, err = svc.SimpleSummary(ctx, goodOrderRequest)
+	require.NoError(t, err)
+	request.Email = "not an email"
+	_, err = svc.SimpleSummary(ctx, request)
+	require.ErrorIs(t, err, ErrInvalidRequest)
+
+	// Only created orders are confirmed.
+	_, err = svc.CancelOrder(ctx, CancelOrderRequest{summary.OrderID})
+	require.NoError(t, err)
+	require.NoError(t, relay.Flush(ctx))
+
+	// Closing waits for the queued confirmation to be sent.
+	require.NoError(t, notifier.Close(ctx))
+	messages := server.Messages()
+	require.Len(t, messages, 1)
+
+	message := messages[0]
+	require.Equal(t, "customer@example.com", message.Header.Get("To"))
+	require.Equal(t, "orders@example.com", message.Header.Get("From"))
+	subject, err := new(mime.WordDecoder).DecodeHeader(message.Header.Get("Subject"))
+	require.NoError(t, err)
+	require.Equal(t, "AEtest order confirmation "+summary.OrderID, subject)
+
+	// The email has the receipt as plain text and as HTML.
+	parts := emailParts(t, message)
+	require.Contains(t, parts["text/plain"], "Order "+summary.OrderID)
+	require.Contains(t, parts["text/plain"], "Apples")
+	require.Contains(t, parts["text/html"], "<td>Apples <small>FRUIT-001</small></td>")
+}
+
+func TestEmailRetries(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	server.failNext(2)
+	notifier := NewEmailNotifier(EmailConfig{
+		SMTPAddr:       server.Addr(),
+		From:           "orders@example.com",
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+	}, NewReceiptRenderer(ReceiptConfig{}))
+
+	order := OrderSummary{OrderID: "order-1", Status: OrderPlaced}
+	require.NoError(t, notifier.Send("customer@example.com", order))
+	require.Eventually(t, func() bool { return len(server.Messages()) == 1 }, time.Second, time.Millisecond)
+
+	// Emails failing every attempt are given up.
+	server.failNext(3)
+	require.NoError(t, notifier.Send("customer@example.com", order))
+	require.NoError(t, notifier.Close(context.Background()))
+	require.Len(t, server.Messages(), 1)
+
+	require.ErrorIs(t, notifier.Send("customer@example.com", order), ErrEmailNotifierClosed)
+}
+
+func TestEmailCloseAbandonsRetries(t *testing.T) {
+	// Nothing is listening, every attempt fails.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	notifier := NewEmailNotifier(EmailConfig{
+		SMTPAddr:       addr,
+		From:           "orders@example.com",
+		InitialBackoff: time.Hour,
+	}, NewReceiptRenderer(ReceiptConfig{}))
+	for i := 0; i < 3; i++ {
+		require.NoError(t, notifier.Send("customer@example.com", OrderSummary{OrderID: fmt.Sprint(i)}))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, notifier.Close(ctx), context.DeadlineExceeded)
+}
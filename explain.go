@@ -0,0 +1,388 @@
+package aetest
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The kinds of PricingStep, in the order they are taken.
+const (
+	// StepPriceList is the choice of the price list the order is priced
+	// from.
+	StepPriceList = "price_list"
+
+	// StepLookup is the lookup of the cost of a cart line.
+	StepLookup = "lookup"
+
+	// StepOverflowCheck is a check that part of the order's cost fits in an
+	// int, orders that overflow are rejected with ErrIntegerOverflow.
+	StepOverflowCheck = "overflow_check"
+
+	// StepSubtotal is the cost of the order before discounts.
+	StepSubtotal = "subtotal"
+
+	// StepDiscount is an offer considered on the order, whether or not it
+	// was given.
+	StepDiscount = "discount"
+
+	// StepTotal is the total cost of the order.
+	StepTotal = "total"
+)
+
+// ItemDiscountOffer is the Offer of a discount step for an item's
+// ItemDiscount.
+const ItemDiscountOffer = "item_discount"
+
+// pricingSteps records the steps taken to price an order that is being
+// explained. Its methods do nothing on a nil *pricingSteps, so that orders
+// that are not being explained are priced without describing every step.
+type pricingSteps struct {
+	steps []PricingStep
+}
+
+func (s *pricingSteps) add(step PricingStep) {
+	s.steps = append(s.steps, step)
+}
+
+// priceList records the choice of the price list, price_list is the list
+// chosen or nil for the catalog.
+func (s *pricingSteps) priceList(req OrderRequest, price_list *PriceList) {
+	if s == nil {
+		return
+	}
+
+	var detail string
+	switch {
+	case price_list != nil && req.CustomerGroup != "" &&
+		containsFold(price_list.CustomerGroups, req.CustomerGroup):
+		detail = fmt.Sprintf(
+			"customer group %q selects price list %q", req.CustomerGroup, price_list.Name,
+		)
+	case price_list != nil:
+		detail = fmt.Sprintf(
+			"channel %q selects price list %q", req.Channel, price_list.Name,
+		)
+	case req.CustomerGroup != "" || req.Channel != "":
+		detail = fmt.Sprintf(
+			"no price list for customer group %q or channel %q, items are priced from the catalog",
+			req.CustomerGroup, req.Channel,
+		)
+	default:
+		detail = "no customer group or channel, items are priced from the catalog"
+	}
+	s.add(PricingStep{Kind: StepPriceList, Detail: detail})
+}
+
+// lookups records the cost of every line of the cart, looked up from the named
+// price list or the catalog at the time the order is priced.
+func (s *pricingSteps) lookups(
+	cart_with_costs []ItemWithCost,
+	price_list string,
+	priced_at time.Time,
+) {
+	if s == nil {
+		return
+	}
+
+	for i, item := range cart_with_costs {
+		name := fmt.Sprintf("%s (%s)", item.ItemName, item.SKU)
+		var detail string
+		switch {
+		case item.PriceList == price_list && price_list == DefaultPriceList:
+			detail = fmt.Sprintf(
+				"%s costs %d in the catalog at %s",
+				name, item.Cost, priced_at.Format(time.RFC3339),
+			)
+		case item.PriceList == price_list:
+			detail = fmt.Sprintf(
+				"%s costs %d in price list %q", name, item.Cost, price_list,
+			)
+		case item.PriceList == DefaultPriceList:
+			detail = fmt.Sprintf(
+				"%s is not in price list %q or its fallbacks, it costs %d in the catalog at %s",
+				name, price_list, item.Cost, priced_at.Format(time.RFC3339),
+			)
+		default:
+			detail = fmt.Sprintf(
+				"%s is not in price list %q, it costs %d in fallback price list %q",
+				name, price_list, item.Cost, item.PriceList,
+			)
+		}
+		s.add(PricingStep{
+			Kind:   StepLookup,
+			Lines:  []int{i},
+			SKU:    item.SKU,
+			Amount: item.Cost,
+			Detail: detail,
+		})
+	}
+}
+
+// quantityChecked records that the quantities of an item ordered on several
+// lines add up without overflowing.
+func (s *pricingSteps) quantityChecked(item pricedItem) {
+	if s == nil || len(item.lines) == 1 {
+		return
+	}
+	s.add(PricingStep{
+		Kind:   StepOverflowCheck,
+		Lines:  item.lines,
+		SKU:    item.sku,
+		Amount: item.quantity,
+		Detail: fmt.Sprintf(
+			"the %d lines of %s add up to a quantity of %d without overflowing",
+			len(item.lines), item.sku, item.quantity,
+		),
+	})
+}
+
+// lineCostChecked records that the cost of an item's lines does not overflow.
+func (s *pricingSteps) lineCostChecked(item pricedItem, line_cost int) {
+	if s == nil {
+		return
+	}
+	s.add(PricingStep{
+		Kind:   StepOverflowCheck,
+		Lines:  item.lines,
+		SKU:    item.sku,
+		Amount: line_cost,
+		Detail: fmt.Sprintf(
+			"cost %d × quantity %d = %d without overflowing",
+			item.cost, item.quantity, line_cost,
+		),
+	})
+}
+
+// subtotalChecked records that adding the cost of an item's lines to the
+// subtotal does not overflow.
+func (s *pricingSteps) subtotalChecked(item pricedItem, before int, line_cost int, after int) {
+	if s == nil {
+		return
+	}
+	s.add(PricingStep{
+		Kind:   StepOverflowCheck,
+		Lines:  item.lines,
+		SKU:    item.sku,
+		Amount: after,
+		Detail: fmt.Sprintf(
+			"subtotal %d + %d = %d without overflowing", before, line_cost, after,
+		),
+	})
+}
+
+func (s *pricingSteps) subtotal(subtotal int) {
+	if s == nil {
+		return
+	}
+	s.add(PricingStep{
+		Kind:   StepSubtotal,
+		Amount: subtotal,
+		Detail: fmt.Sprintf("the order costs %d before discounts", subtotal),
+	})
+}
+
+// discountPolicy records the policy the discounts are chosen by.
+func (s *pricingSteps) discountPolicy(policy DiscountPolicy) {
+	if s == nil {
+		return
+	}
+	s.add(PricingStep{
+		Kind:   StepDiscount,
+		Detail: fmt.Sprintf("the offers on each item are chosen by the %s policy", policy),
+	})
+}
+
+// exclusive records whether an exclusive promotion is given instead of the
+// other offers on the order.
+func (s *pricingSteps) exclusive(
+	policy DiscountPolicy,
+	promotion Promotion,
+	plan discountPlan,
+	over discountPlan,
+	preferred bool,
+) {
+	if s == nil {
+		return
+	}
+
+	var detail string
+	switch {
+	case !plan.discounted:
+		detail = "exclusive, gives no discount on this order"
+	case preferred && policy == DiscountFirstMatch:
+		detail = "exclusive, comes first by priority so no other offer is given"
+	case preferred:
+		detail = fmt.Sprintf(
+			"exclusive, gives %d in total which is more than the other offers (%d), so no other offer is given",
+			plan.total, over.total,
+		)
+	case policy == DiscountFirstMatch:
+		detail = "exclusive, the other offers come first by priority"
+	default:
+		detail = fmt.Sprintf(
+			"exclusive, gives %d in total which is no more than the other offers (%d)",
+			plan.total, over.total,
+		)
+	}
+	s.add(PricingStep{
+		Kind:    StepDiscount,
+		Offer:   promotion.ID,
+		Amount:  plan.total,
+		Applied: &preferred,
+		Detail:  detail,
+	})
+}
+
+// discounts records every offer considered on each item of the order, and
+// whether it was given under the policy. Promotions for the item that are not
+// active at the time the order is priced are recorded as not given, as are the
+// offers an exclusive promotion is given instead of.
+func (s *pricingSteps) discounts(
+	policy DiscountPolicy,
+	items []pricedItem,
+	plan discountPlan,
+	promotions []Promotion,
+	priced_at time.Time,
+) {
+	if s == nil {
+		return
+	}
+
+	for i, item := range items {
+		for _, promotion := range promotions {
+			if promotion.appliesTo(item.sku) && !promotion.ActiveAt(priced_at) {
+				s.add(PricingStep{
+					Kind:    StepDiscount,
+					Lines:   item.lines,
+					SKU:     item.sku,
+					Offer:   promotion.ID,
+					Applied: new(bool),
+					Detail: fmt.Sprintf(
+						"promotion %q is not active at %s",
+						promotion.ID, priced_at.Format(time.RFC3339),
+					),
+				})
+			}
+		}
+
+		for _, considered := range plan.offers[i] {
+			step := PricingStep{
+				Kind:   StepDiscount,
+				Lines:  item.lines,
+				SKU:    item.sku,
+				Offer:  offerID(considered),
+				Amount: considered.discount,
+			}
+			applied := false
+			for _, given := range plan.given[i] {
+				if given.promotion == considered.promotion {
+					applied = true
+					step.Amount = given.discount
+					step.Detail = givenDetail(considered, given)
+				}
+			}
+			if !applied {
+				step.Detail = notGivenDetail(policy, considered, plan.given[i], item.quantity)
+			}
+			step.Applied = &applied
+			s.add(step)
+		}
+
+		if plan.exclusive == "" {
+			continue
+		}
+		for _, excluded := range plan.excluded[i] {
+			s.add(PricingStep{
+				Kind:    StepDiscount,
+				Lines:   item.lines,
+				SKU:     item.sku,
+				Offer:   offerID(excluded),
+				Amount:  excluded.discount,
+				Applied: new(bool),
+				Detail: fmt.Sprintf(
+					"%s was not given, exclusive promotion %q is given on the order instead",
+					offerName(excluded), plan.exclusive,
+				),
+			})
+		}
+	}
+}
+
+func (s *pricingSteps) total(subtotal int, discount int, total int) {
+	if s == nil {
+		return
+	}
+	s.add(PricingStep{
+		Kind:   StepTotal,
+		Amount: total,
+		Detail: fmt.Sprintf(
+			"subtotal %d less discounts of %d = %d", subtotal, discount, total,
+		),
+	})
+}
+
+func offerID(o offer) string {
+	if o.promotion < 0 {
+		return ItemDiscountOffer
+	}
+	return o.id
+}
+
+func offerName(o offer) string {
+	if o.promotion < 0 {
+		return "the item discount"
+	}
+	return fmt.Sprintf("promotion %q", o.id)
+}
+
+// givenDetail describes an offer that was given, and why it gave less than it
+// offered.
+func givenDetail(considered offer, given offer) string {
+	detail := []string{fmt.Sprintf("%s gives %d off", offerName(considered), given.discount)}
+	if considered.uncapped > considered.discount {
+		detail = append(detail, fmt.Sprintf(
+			"capped from %d by the promotion's max_order_discount", considered.uncapped,
+		))
+	}
+	if given.discount < considered.discount {
+		detail = append(detail, fmt.Sprintf(
+			"reduced from %d as an item's discounts cannot exceed the cost of its lines",
+			considered.discount,
+		))
+	}
+	return strings.Join(detail, ", ")
+}
+
+// notGivenDetail describes why an offer was not given under the policy.
+func notGivenDetail(policy DiscountPolicy, considered offer, given []offer, quantity int) string {
+	name := offerName(considered)
+	switch {
+	case considered.discount <= 0 && considered.uncapped > 0:
+		return fmt.Sprintf(
+			"%s was not given, its max_order_discount is used up by earlier items", name,
+		)
+	case considered.discount <= 0:
+		return fmt.Sprintf("%s gives no discount on a quantity of %d", name, quantity)
+	case policy == DiscountFirstMatch && !given[0].stackable:
+		return fmt.Sprintf(
+			"%s was not given, %s comes first by priority and does not stack",
+			name, offerName(given[0]),
+		)
+	case policy == DiscountFirstMatch:
+		return fmt.Sprintf(
+			"%s was not given, it does not stack with %s which comes first by priority",
+			name, offerName(given[0]),
+		)
+	case len(given) == 1:
+		return fmt.Sprintf(
+			"%s was not given, %s gives at least as much (%d)",
+			name, offerName(given[0]), given[0].discount,
+		)
+	default:
+		return fmt.Sprintf(
+			"%s was not given, the stackable offers together give more (%d)",
+			name, sumDiscounts(given),
+		)
+	}
+}
@@ -0,0 +1,151 @@
+package aetest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplainPrice(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	start := clock.Now().Add(time.Hour)
+	items, discounts, orders := NewStore()
+	svc := New(items, discounts, orders, WithClock(clock.Now), WithPromotions(
+		Promotion{ID: "half-price-apples", SKUs: []string{"FRUIT-001"}, PercentOff: 50, Start: &start},
+		Promotion{ID: "oranges-off", SKUs: []string{"FRUIT-002"}, AmountOff: 5},
+	))
+
+	request := ExplainPriceRequest{OrderRequest: OrderRequest{Cart: []Item{
+		{ItemName: "Apples", Quantity: 1},
+		{ItemName: "Oranges", Quantity: 3},
+		{ItemName: "Apples", Quantity: 1},
+	}}}
+	explanation, err := svc.ExplainPrice(ctx, request)
+	require.NoError(t, err)
+
+	// The explained order is priced exactly as a quote.
+	quote, err := svc.Quote(ctx, request.OrderRequest)
+	require.NoError(t, err)
+	require.Equal(t, quote, explanation.Order)
+	require.Equal(t, 110, explanation.Order.TotalCost)
+
+	kinds := []string{}
+	for _, step := range explanation.Steps {
+		kinds = append(kinds, step.Kind)
+	}
+	require.Equal(t, []string{
+		StepPriceList,
+		StepLookup, StepLookup, StepLookup,
+		// Apples are on two lines, their quantities are added first.
+		StepOverflowCheck, StepOverflowCheck, StepOverflowCheck,
+		StepOverflowCheck, StepOverflowCheck,
+		StepSubtotal,
+		StepDiscount,
+		// Half price apples, then buy one get one free.
+		StepDiscount, StepDiscount,
+		// 3 for 2 oranges, then 5 off each orange.
+		StepDiscount, StepDiscount,
+		StepTotal,
+	}, kinds)
+
+	steps := explanation.Steps
+	require.Equal(t, 60, steps[1].Amount)
+	require.Equal(t, []int{0, 2}, steps[4].Lines)
+	require.Equal(t, 2, steps[4].Amount)
+	require.Equal(t, 195, steps[9].Amount)
+
+	// Every discount step says whether the offer was given.
+	given := map[string]bool{}
+	for _, step := range steps[11:15] {
+		require.NotNil(t, step.Applied)
+		given[step.SKU+" "+step.Offer] = *step.Applied
+	}
+	require.Equal(t, map[string]bool{
+		"FRUIT-001 half-price-apples": false,
+		"FRUIT-001 item_discount":     true,
+		"FRUIT-002 item_discount":     true,
+		"FRUIT-002 oranges-off":       false,
+	}, given)
+	require.Contains(t, steps[11].Detail, "not active")
+	require.Contains(t, steps[14].Detail, "the item discount gives at least as much (25)")
+	require.Equal(t, 110, steps[15].Amount)
+
+	// Later the apples are half price, which is as much as buy one get one
+	// free.
+	later := start.Add(time.Minute)
+	request.At = &later
+	explanation, err = svc.ExplainPrice(ctx, request)
+	require.NoError(t, err)
+	require.Equal(t, later, explanation.Order.CreatedAt)
+	require.Equal(t, 110, explanation.Order.TotalCost)
+
+	// Orders that cannot be priced are not explained.
+	request.Cart = append(request.Cart, Item{ItemName: "Pears", Quantity: 1})
+	_, err = svc.ExplainPrice(ctx, request)
+	require.ErrorIs(t, err, ErrItemDoesNotExist)
+}
+
+func TestExplainPriceExclusive(t *testing.T) {
+	ctx := context.Background()
+	items, discounts, orders := NewStore()
+	svc := New(items, discounts, orders, WithPromotions(
+		Promotion{ID: "half-price", SKUs: []string{"FRUIT-001", "FRUIT-002"}, PercentOff: 50, Exclusive: true},
+		Promotion{ID: "oranges-off", SKUs: []string{"FRUIT-002"}, AmountOff: 5},
+	))
+
+	// Half price gives 60 + 37, more than buy one get one free and 3 for 2
+	// together (60 + 25).
+	explanation, err := svc.ExplainPrice(ctx, ExplainPriceRequest{OrderRequest: goodOrderRequest})
+	require.NoError(t, err)
+	require.Equal(t, 98, explanation.Order.TotalCost)
+
+	type discountStep struct {
+		sku     string
+		offer   string
+		amount  int
+		applied bool
+	}
+	var steps []discountStep
+	for _, step := range explanation.Steps {
+		if step.Kind != StepDiscount || step.SKU == "" {
+			continue
+		}
+		require.NotNil(t, step.Applied)
+		steps = append(steps, discountStep{step.SKU, step.Offer, step.Amount, *step.Applied})
+		if !*step.Applied {
+			require.Contains(t, step.Detail, `exclusive promotion "half-price" is given on the order instead`)
+		}
+	}
+
+	// The offers the exclusive promotion is given instead of are recorded
+	// as not given.
+	require.Equal(t, []discountStep{
+		{"FRUIT-001", "half-price", 60, true},
+		{"FRUIT-001", ItemDiscountOffer, 60, false},
+		{"FRUIT-002", "half-price", 37, true},
+		{"FRUIT-002", ItemDiscountOffer, 25, false},
+		{"FRUIT-002", "oranges-off", 15, false},
+	}, steps)
+}
+
+func TestExplainPriceHTTP(t *testing.T) {
+	response := postJSON(t, router, "/explain-price", ExplainPriceRequest{OrderRequest: goodOrderRequest})
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	var explanation PriceExplanation
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&explanation))
+	require.Equal(t, 110, explanation.Order.TotalCost)
+	require.Empty(t, explanation.Order.OrderID)
+	require.NotEmpty(t, explanation.Steps)
+	require.Equal(t, StepTotal, explanation.Steps[len(explanation.Steps)-1].Kind)
+
+	response = postJSON(t, router, "/explain-price", map[string]interface{}{
+		"cart": []Item{{ItemName: "Apples", Quantity: 0}},
+	})
+	require.Equal(t, http.StatusBadRequest, response.StatusCode)
+}
@@ -0,0 +1,338 @@
+package aetest
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExportFormat is a file format that orders can be exported to and imported
+// from.
+type ExportFormat string
+
+const (
+	// ExportCSV writes one row per `ItemWithCost` line, the order_id,
+	// total_cost, timestamps and status are repeated on every line of the
+	// order. Files exported by earlier versions, with fewer of the trailing
+	// columns, can still be imported.
+	ExportCSV ExportFormat = "csv"
+
+	// ExportJSONL writes one `OrderSummary` JSON object per line.
+	ExportJSONL ExportFormat = "jsonl"
+)
+
+// ErrUnknownFormat is returned when an export or import format is not
+// supported.
+var ErrUnknownFormat = errors.New("unknown format, expected csv or jsonl")
+
+// ParseExportFormat returns the ExportFormat for the supplied name. An empty
+// name defaults to JSON-lines.
+func ParseExportFormat(name string) (ExportFormat, error) {
+	switch strings.ToLower(name) {
+	case "", "jsonl", "ndjson":
+		return ExportJSONL, nil
+	case "csv":
+		return ExportCSV, nil
+	}
+	return "", ErrUnknownFormat
+}
+
+// ContentType is the MIME type of the format used in HTTP responses.
+func (f ExportFormat) ContentType() string {
+	if f == ExportCSV {
+		return "text/csv"
+	}
+	return "application/x-ndjson"
+}
+
+var csvHeader = []string{
+	"order_id", "item_name", "quantity", "cost", "discount", "total_cost",
+	"created_at", "updated_at", "sku", "status", "price_list", "discount_policy",
+}
+
+// csvColumns are the numbers of columns of every CSV export there has been,
+// each a prefix of csvHeader. The first had no timestamps, columns were then
+// added for the timestamps, sku, status, price_list and discount_policy.
+var csvColumns = []int{6, 8, 9, 10, 11, 12}
+
+// OrderWriter writes orders to an underlying writer one at a time so exports
+// can be streamed without holding the whole encoded store in memory.
+type OrderWriter struct {
+	format ExportFormat
+	csv    *csv.Writer
+	json   *json.Encoder
+}
+
+// NewOrderWriter creates an OrderWriter for the supplied format. For CSV the
+// header row is written immediately.
+func NewOrderWriter(w io.Writer, format ExportFormat) (*OrderWriter, error) {
+	switch format {
+	case ExportCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(csvHeader); err != nil {
+			return nil, err
+		}
+		return &OrderWriter{format: format, csv: writer}, nil
+	case ExportJSONL:
+		return &OrderWriter{format: format, json: json.NewEncoder(w)}, nil
+	}
+	return nil, ErrUnknownFormat
+}
+
+// Write encodes a single order.
+func (ow *OrderWriter) Write(order OrderSummary) error {
+	if ow.format == ExportJSONL {
+		// Encode terminates every value with a newline.
+		return ow.json.Encode(order)
+	}
+
+	total := strconv.Itoa(order.TotalCost)
+	created_at := order.CreatedAt.Format(time.RFC3339Nano)
+	updated_at := order.UpdatedAt.Format(time.RFC3339Nano)
+	for _, item := range order.Summary {
+		err := ow.csv.Write([]string{
+			order.OrderID,
+			item.ItemName,
+			strconv.Itoa(item.Quantity),
+			strconv.Itoa(item.Cost),
+			strconv.Itoa(item.Discount),
+			total,
+			created_at,
+			updated_at,
+			item.SKU,
+			order.Status,
+			item.PriceList,
+			string(order.DiscountPolicy),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes any buffered data to the underlying writer.
+func (ow *OrderWriter) Flush() error {
+	if ow.csv != nil {
+		ow.csv.Flush()
+		return ow.csv.Error()
+	}
+	return nil
+}
+
+// WriteOrders writes all the supplied orders in the given format.
+func WriteOrders(w io.Writer, format ExportFormat, orders []OrderSummary) error {
+	writer, err := NewOrderWriter(w, format)
+	if err != nil {
+		return err
+	}
+
+	for _, order := range orders {
+		if err := writer.Write(order); err != nil {
+			return err
+		}
+	}
+
+	return writer.Flush()
+}
+
+// SaveOrdersFile writes every order in the Service to the file at path as
+// JSON lines, so that the orders can be restored by LoadOrdersFile when the
+// server next starts. The orders are written to a temporary file that then
+// replaces path, a failed save never leaves a partially written file behind.
+func SaveOrdersFile(ctx context.Context, svc Service, path string) error {
+	orders, err := svc.GetAllOrders(ctx)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := WriteOrders(f, ExportJSONL, orders.Orders); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// LoadOrdersFile imports the orders saved by SaveOrdersFile into the Service,
+// returning the number of orders imported. A missing file is not an error,
+// nothing is imported.
+func LoadOrdersFile(ctx context.Context, svc Service, path string) (int, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	orders, err := ReadOrders(f, ExportJSONL)
+	if err != nil {
+		return 0, fmt.Errorf("reading %s: %w", path, err)
+	}
+	if len(orders) == 0 {
+		return 0, nil
+	}
+
+	response, err := svc.ImportOrders(ctx, orders)
+	if err != nil {
+		return 0, fmt.Errorf("importing %s: %w", path, err)
+	}
+
+	return response.Imported, nil
+}
+
+// ReadOrders reads orders previously written by WriteOrders. The orders are
+// only decoded, they are validated when imported into a Service.
+func ReadOrders(r io.Reader, format ExportFormat) ([]OrderSummary, error) {
+	switch format {
+	case ExportCSV:
+		return readOrdersCSV(r)
+	case ExportJSONL:
+		return readOrdersJSONL(r)
+	}
+	return nil, ErrUnknownFormat
+}
+
+func readOrdersJSONL(r io.Reader) ([]OrderSummary, error) {
+	var orders []OrderSummary
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var order OrderSummary
+		if err := json.Unmarshal([]byte(text), &order); err != nil {
+			// The last line is cut short when reading fails part way
+			// through it, the failure is the cause.
+			if scanner.Err() != nil {
+				return nil, scanner.Err()
+			}
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		orders = append(orders, order)
+	}
+
+	return orders, scanner.Err()
+}
+
+func readOrdersCSV(r io.Reader) ([]OrderSummary, error) {
+	reader := csv.NewReader(r)
+
+	// Every row must have as many fields as the header.
+	reader.FieldsPerRecord = 0
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading csv header: %w", err)
+	}
+	// Older exports have a prefix of the current columns.
+	if !slices.Contains(csvColumns, len(header)) ||
+		strings.Join(header, ",") != strings.Join(csvHeader[:len(header)], ",") {
+		return nil, fmt.Errorf("csv header must be %s", strings.Join(csvHeader, ","))
+	}
+
+	// Rows are grouped by order_id, keeping the orders in the order they
+	// first appear.
+	var orders []OrderSummary
+	index := map[string]int{}
+	row := 1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		row++
+
+		numbers := make([]int, 4)
+		for i, field := range record[2:6] {
+			n, err := strconv.Atoi(field)
+			if err != nil {
+				return nil, fmt.Errorf("row %d: invalid %s %q", row, csvHeader[i+2], field)
+			}
+			numbers[i] = n
+		}
+
+		// Orders without timestamps are timestamped when imported.
+		times := make([]time.Time, 2)
+		for i, field := range record[6:min(len(record), 8)] {
+			at, err := time.Parse(time.RFC3339Nano, field)
+			if err != nil {
+				return nil, fmt.Errorf("row %d: invalid %s %q", row, csvHeader[i+6], field)
+			}
+			times[i] = at
+		}
+
+		i, ok := index[record[0]]
+		if !ok {
+			i = len(orders)
+			index[record[0]] = i
+			order := OrderSummary{
+				OrderID:   record[0],
+				TotalCost: numbers[3],
+				CreatedAt: times[0],
+				UpdatedAt: times[1],
+			}
+			if len(record) > 9 {
+				order.Status = record[9]
+			}
+			if len(record) > 11 {
+				order.DiscountPolicy = DiscountPolicy(record[11])
+			}
+			orders = append(orders, order)
+		}
+		if orders[i].TotalCost != numbers[3] {
+			return nil, fmt.Errorf("row %d: total_cost differs between lines of order %s", row, record[0])
+		}
+
+		sku, price_list := "", ""
+		if len(record) > 8 {
+			sku = record[8]
+		}
+		if len(record) > 10 {
+			price_list = record[10]
+		}
+		orders[i].Summary = append(orders[i].Summary, ItemWithCost{
+			ItemName:  record[1],
+			Quantity:  numbers[0],
+			Cost:      numbers[1],
+			Discount:  numbers[2],
+			SKU:       sku,
+			PriceList: price_list,
+		})
+	}
+
+	return orders, nil
+}
@@ -0,0 +1,202 @@
+package aetest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	for _, format := range []ExportFormat{ExportCSV, ExportJSONL} {
+		items, discounts, _ := NewStore()
+		source := New(items, discounts, make(OrderStore))
+		source_router := NewOrdersRouter(source)
+
+		for i := 0; i < 3; i++ {
+			_, err := source.SimpleSummary(ctx, goodOrderRequest)
+			require.NoError(t, err)
+		}
+
+		// Statuses are exported, one order is cancelled.
+		placed, err := source.GetAllOrders(ctx)
+		require.NoError(t, err)
+		_, err = source.CancelOrder(ctx, CancelOrderRequest{placed.Orders[0].OrderID})
+		require.NoError(t, err)
+
+		request := httptest.NewRequest("GET", "/export-orders?format="+string(format), nil)
+		rec := httptest.NewRecorder()
+		source_router.ServeHTTP(rec, request)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, format.ContentType(), rec.Header().Get("Content-Type"))
+
+		// Import the export into a second, empty, store.
+		target := New(items, discounts, make(OrderStore))
+		target_router := NewOrdersRouter(target)
+
+		request = httptest.NewRequest("POST", "/import-orders?format="+string(format), bytes.NewReader(rec.Body.Bytes()))
+		rec = httptest.NewRecorder()
+		target_router.ServeHTTP(rec, request)
+		require.Equalf(t, http.StatusOK, rec.Code, "format %s: %s", format, rec.Body)
+
+		var imported ImportOrdersResponse
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&imported))
+		require.Equal(t, 3, imported.Imported)
+		source_orders, err := source.GetAllOrders(ctx)
+		require.NoError(t, err)
+		target_orders, err := target.GetAllOrders(ctx)
+		require.NoError(t, err)
+		require.ElementsMatch(t, source_orders.Orders, target_orders.Orders)
+
+		// Importing the same orders again conflicts with the stored orders.
+		var buf bytes.Buffer
+		require.NoError(t, WriteOrders(&buf, format, source_orders.Orders))
+		request = httptest.NewRequest("POST", "/import-orders?format="+string(format), &buf)
+		rec = httptest.NewRecorder()
+		target_router.ServeHTTP(rec, request)
+		require.Equal(t, http.StatusConflict, rec.Code)
+	}
+}
+
+func TestImportRejectsInvalidOrders(t *testing.T) {
+	items, discounts, _ := NewStore()
+	orders := make(OrderStore)
+	svc := New(items, discounts, orders)
+
+	good := OrderSummary{
+		OrderID:   uuid.NewV4().String(),
+		Summary:   []ItemWithCost{{"Apples", 2, 60, 60, "FRUIT-001", DefaultPriceList}},
+		TotalCost: 60,
+	}
+	wrong_total := OrderSummary{
+		OrderID:   uuid.NewV4().String(),
+		Summary:   []ItemWithCost{{"Apples", 2, 60, 0, "FRUIT-001", DefaultPriceList}},
+		TotalCost: 60,
+	}
+	bad_id := OrderSummary{
+		OrderID:   "not an id",
+		Summary:   []ItemWithCost{{"Apples", 1, 60, 0, "FRUIT-001", DefaultPriceList}},
+		TotalCost: 60,
+	}
+
+	testCases := []struct {
+		name   string
+		orders []OrderSummary
+	}{
+		{"wrong total", []OrderSummary{good, wrong_total}},
+		{"invalid order id", []OrderSummary{good, bad_id}},
+		{"duplicate order id", []OrderSummary{good, good}},
+		{"no lines", []OrderSummary{{OrderID: uuid.NewV4().String()}}},
+	}
+
+	for _, tc := range testCases {
+		_, err := svc.ImportOrders(context.Background(), tc.orders)
+		require.ErrorIsf(t, err, ErrInvalidRequest, "case: %v", tc.name)
+
+		// Nothing is imported when any order is invalid.
+		require.Emptyf(t, orders, "case: %v", tc.name)
+	}
+}
+
+func TestSaveAndLoadOrdersFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "orders.jsonl")
+
+	// Loading a file that does not exist yet imports nothing.
+	items, discounts, _ := NewStore()
+	source := New(items, discounts, make(OrderStore))
+	imported, err := LoadOrdersFile(ctx, source, path)
+	require.NoError(t, err)
+	require.Zero(t, imported)
+
+	for i := 0; i < 2; i++ {
+		_, err := source.SimpleSummary(ctx, goodOrderRequest)
+		require.NoError(t, err)
+	}
+	require.NoError(t, SaveOrdersFile(ctx, source, path))
+
+	items, discounts, _ = NewStore()
+	target := New(items, discounts, make(OrderStore))
+	imported, err = LoadOrdersFile(ctx, target, path)
+	require.NoError(t, err)
+	require.Equal(t, 2, imported)
+
+	source_orders, err := source.GetAllOrders(ctx)
+	require.NoError(t, err)
+	target_orders, err := target.GetAllOrders(ctx)
+	require.NoError(t, err)
+	require.Equal(t, source_orders, target_orders)
+
+	// Only the saved file is left in the directory.
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func TestReadOlderCSVExports(t *testing.T) {
+	id := uuid.NewV4().String()
+	rows := []string{
+		// The first export, without timestamps.
+		"order_id,item_name,quantity,cost,discount,total_cost\n" +
+			id + ",Apples,2,60,60,60\n",
+		"order_id,item_name,quantity,cost,discount,total_cost,created_at,updated_at\n" +
+			id + ",Apples,2,60,60,60,2024-05-01T10:00:00Z,2024-05-01T10:00:00Z\n",
+		"order_id,item_name,quantity,cost,discount,total_cost,created_at,updated_at,sku,status\n" +
+			id + ",Apples,2,60,60,60,2024-05-01T10:00:00Z,2024-05-01T11:00:00Z,FRUIT-001,cancelled\n",
+	}
+	for _, content := range rows {
+		orders, err := ReadOrders(bytes.NewBufferString(content), ExportCSV)
+		require.NoError(t, err, content)
+		require.Len(t, orders, 1)
+		require.Equal(t, id, orders[0].OrderID)
+		require.Equal(t, 60, orders[0].TotalCost)
+	}
+
+	// Columns are only ever added at the end, in the order of the current
+	// header.
+	for _, header := range []string{
+		"order_id,item_name,quantity,cost,discount",
+		"order_id,item_name,quantity,cost,discount,total_cost,created_at",
+		"order_id,item_name,quantity,cost,discount,total_cost,updated_at,created_at",
+	} {
+		_, err := ReadOrders(bytes.NewBufferString(header+"\n"), ExportCSV)
+		require.Error(t, err, header)
+	}
+}
+
+func TestMaxImportSize(t *testing.T) {
+	items, discounts, _ := NewStore()
+	source := New(items, discounts, make(OrderStore))
+	for i := 0; i < 10; i++ {
+		_, err := source.SimpleSummary(context.Background(), goodOrderRequest)
+		require.NoError(t, err)
+	}
+	all_orders, err := source.GetAllOrders(context.Background())
+	require.NoError(t, err)
+	var export bytes.Buffer
+	require.NoError(t, WriteOrders(&export, ExportJSONL, all_orders.Orders))
+
+	// Imports are not limited by the body size of other requests.
+	import_orders := func(router http.Handler) int {
+		request := httptest.NewRequest("POST", "/import-orders", bytes.NewReader(export.Bytes()))
+		// The length is not declared so the body is cut off as it is read.
+		request.ContentLength = -1
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, request)
+		return rec.Code
+	}
+	target := func(opts ...RouterOption) http.Handler {
+		return NewOrdersRouter(New(items, discounts, make(OrderStore)), opts...)
+	}
+	require.Equal(t, http.StatusOK, import_orders(target(WithMaxBodySize(256))))
+	require.Equal(t, http.StatusOK, import_orders(target(WithMaxImportSize(int64(export.Len())))))
+	require.Equal(t, http.StatusRequestEntityTooLarge, import_orders(target(WithMaxImportSize(256))))
+}
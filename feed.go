@@ -0,0 +1,327 @@
+package aetest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EventFeedReset is sent to a subscriber resuming from an event that is no
+// longer in the feed's history. Orders may have changed since, the subscriber
+// should fetch every order again before relying on the feed.
+const EventFeedReset = "feed.reset"
+
+// ErrFeedClosed is returned when subscribing to a closed OrderFeed.
+var ErrFeedClosed = errors.New("order feed closed")
+
+// orderFeedPath is the route the feed is served at.
+const orderFeedPath = "/order-feed"
+
+// SlowConsumerPolicy is what an OrderFeed does with a subscriber whose buffer
+// is full when an event is broadcast.
+type SlowConsumerPolicy string
+
+const (
+	// DisconnectSlowConsumers ends the subscriber's stream. The subscriber
+	// can reconnect with the `Last-Event-ID` of the last event it received
+	// and resume without losing events, provided they are still in the
+	// feed's history. This is the default.
+	DisconnectSlowConsumers SlowConsumerPolicy = "disconnect"
+
+	// DropSlowConsumerEvents skips the event for that subscriber, it can
+	// detect the gap in the event IDs.
+	DropSlowConsumerEvents SlowConsumerPolicy = "drop"
+)
+
+// FeedConfig configures an OrderFeed, zero values use the defaults.
+type FeedConfig struct {
+	// History is the number of recent events kept for subscribers resuming
+	// with `Last-Event-ID`, defaulting to 1000.
+	History int
+
+	// Buffer is the number of events queued for each subscriber, defaulting
+	// to 64. Subscribers that fall further behind are handled according to
+	// SlowConsumers.
+	Buffer        int
+	SlowConsumers SlowConsumerPolicy
+
+	// Heartbeat is the interval at which a comment is sent to idle
+	// subscribers so that proxies keep the stream open, defaulting to 15s.
+	Heartbeat time.Duration
+}
+
+// OrderFeed broadcasts new and updated orders to subscribers as Server-Sent
+// Events, it is an EventPublisher relayed the order events from the Outbox.
+// Each event has an ID one greater than the event before it, IDs start again
+// from 1 when the server restarts.
+type OrderFeed struct {
+	config FeedConfig
+
+	mu          sync.Mutex
+	last_id     uint64
+	history     []feedEvent
+	subscribers map[*feedSubscriber]struct{}
+	closed      bool
+}
+
+// feedEvent is an event encoded once for every subscriber.
+type feedEvent struct {
+	id         uint64
+	event_type string
+	data       []byte
+}
+
+// feedSubscriber receives events on a buffered channel, the channel is closed
+// when the subscriber is disconnected.
+type feedSubscriber struct {
+	events chan feedEvent
+}
+
+// NewOrderFeed creates an OrderFeed with no subscribers.
+func NewOrderFeed(config FeedConfig) *OrderFeed {
+	if config.History <= 0 {
+		config.History = 1000
+	}
+	if config.Buffer <= 0 {
+		config.Buffer = 64
+	}
+	if config.SlowConsumers == "" {
+		config.SlowConsumers = DisconnectSlowConsumers
+	}
+	if config.Heartbeat <= 0 {
+		config.Heartbeat = 15 * time.Second
+	}
+
+	return &OrderFeed{
+		config:      config,
+		subscribers: make(map[*feedSubscriber]struct{}),
+	}
+}
+
+// ParseSlowConsumerPolicy returns the SlowConsumerPolicy with the given name,
+// either "disconnect" or "drop".
+func ParseSlowConsumerPolicy(name string) (SlowConsumerPolicy, error) {
+	switch policy := SlowConsumerPolicy(name); policy {
+	case DisconnectSlowConsumers, DropSlowConsumerEvents:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown slow consumer policy %q, expected disconnect or drop", name)
+}
+
+// Publish broadcasts the order of an order event to every subscriber, other
+// events are ignored. It never blocks on a subscriber. The feed is live, events
+// published once it is closed are dropped rather than kept in the outbox.
+func (f *OrderFeed) Publish(ctx context.Context, event Event) error {
+	if event.Order == nil {
+		return nil
+	}
+	data, err := json.Marshal(event.Order)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil
+	}
+
+	f.last_id++
+	feed_event := feedEvent{f.last_id, event.Type, data}
+	f.history = append(f.history, feed_event)
+	if len(f.history) > f.config.History {
+		f.history = f.history[len(f.history)-f.config.History:]
+	}
+
+	for subscriber := range f.subscribers {
+		select {
+		case subscriber.events <- feed_event:
+		default:
+			if f.config.SlowConsumers == DisconnectSlowConsumers {
+				delete(f.subscribers, subscriber)
+				close(subscriber.events)
+			}
+		}
+	}
+	return nil
+}
+
+// Close disconnects every subscriber, no further events are published. It is
+// called on shutdown as otherwise open streams would keep the server running.
+func (f *OrderFeed) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closed = true
+	for subscriber := range f.subscribers {
+		delete(f.subscribers, subscriber)
+		close(subscriber.events)
+	}
+}
+
+// subscribe adds a subscriber. When resuming, the events after last_id are
+// returned to be sent before any new event, reset is true if some of them are
+// no longer in the history. The backlog is taken under the same lock as the
+// subscriber is added so no event is missed or sent twice.
+func (f *OrderFeed) subscribe(
+	resume bool,
+	last_id uint64,
+) (_ *feedSubscriber, backlog []feedEvent, reset bool, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil, nil, false, ErrFeedClosed
+	}
+
+	if resume {
+		// An ID from before a restart may be ahead of the feed.
+		oldest := f.last_id + 1
+		if len(f.history) > 0 {
+			oldest = f.history[0].id
+		}
+		if last_id > f.last_id || last_id+1 < oldest {
+			reset = true
+		} else {
+			for _, event := range f.history {
+				if event.id > last_id {
+					backlog = append(backlog, event)
+				}
+			}
+		}
+	}
+
+	subscriber := &feedSubscriber{events: make(chan feedEvent, f.config.Buffer)}
+	f.subscribers[subscriber] = struct{}{}
+	return subscriber, backlog, reset, nil
+}
+
+func (f *OrderFeed) unsubscribe(subscriber *feedSubscriber) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.subscribers[subscriber]; ok {
+		delete(f.subscribers, subscriber)
+		close(subscriber.events)
+	}
+}
+
+// lastID returns the ID of the most recent event.
+func (f *OrderFeed) lastID() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.last_id
+}
+
+// WithOrderFeed serves the feed as Server-Sent Events at `/order-feed`. Each
+// event's data is an OrderSummary and its type is the change, e.g.
+// "order.created". A subscriber resumes from the `Last-Event-ID` header, or
+// the `last_event_id` query parameter, receiving every event since.
+//
+// The stream is long lived, the option must come before WithRequestTimeout,
+// and the server's write timeout lifted with StreamingHandler.
+func WithOrderFeed(f *OrderFeed) RouterOption {
+	return func(router *gin.Engine) {
+		router.GET(orderFeedPath, func(c *gin.Context) {
+			last_event_id := c.GetHeader("Last-Event-ID")
+			if last_event_id == "" {
+				last_event_id = c.Query("last_event_id")
+			}
+			resume := last_event_id != ""
+			last_id, err := strconv.ParseUint(last_event_id, 10, 64)
+			if resume && err != nil {
+				c.JSON(http.StatusBadRequest, GenericErrResponse{
+					Err: fmt.Sprintf("invalid last event id %q", last_event_id),
+				})
+				return
+			}
+
+			subscriber, backlog, reset, err := f.subscribe(resume, last_id)
+			if err != nil {
+				c.JSON(http.StatusServiceUnavailable, GenericErrResponse{
+					Err: err.Error(),
+				})
+				return
+			}
+			defer f.unsubscribe(subscriber)
+
+			c.Header("Content-Type", "text/event-stream")
+			c.Header("Cache-Control", "no-cache")
+			c.Header("Connection", "keep-alive")
+			// Disable buffering by nginx so events are sent immediately.
+			c.Header("X-Accel-Buffering", "no")
+			c.Status(http.StatusOK)
+
+			if reset {
+				// The reset takes the ID of the latest event, so that
+				// resuming after it does not reset again.
+				data := fmt.Sprintf(`{"last_event_id":%d}`, last_id)
+				if !writeSSE(c, feedEvent{f.lastID(), EventFeedReset, []byte(data)}) {
+					return
+				}
+			}
+			for _, event := range backlog {
+				if !writeSSE(c, event) {
+					return
+				}
+			}
+			c.Writer.Flush()
+
+			heartbeat := time.NewTicker(f.config.Heartbeat)
+			defer heartbeat.Stop()
+
+			for {
+				select {
+				case event, ok := <-subscriber.events:
+					if !ok {
+						// Disconnected as a slow consumer, or on
+						// shutdown.
+						return
+					}
+					if !writeSSE(c, event) {
+						return
+					}
+				case <-heartbeat.C:
+					if _, err := c.Writer.WriteString(": heartbeat\n\n"); err != nil {
+						return
+					}
+				case <-c.Request.Context().Done():
+					return
+				}
+				c.Writer.Flush()
+			}
+		})
+	}
+}
+
+// writeSSE writes a single event, returning false if the subscriber has gone
+// away. The data is JSON so it never spans lines.
+func writeSSE(c *gin.Context, event feedEvent) bool {
+	_, err := fmt.Fprintf(
+		c.Writer, "id: %d\nevent: %s\ndata: %s\n\n",
+		event.id, event.event_type, event.data,
+	)
+	return err == nil
+}
+
+// StreamingHandler lifts the server's write timeout for GET requests to the
+// order feed, so that its streams are not cut off. Every other request keeps
+// the timeout, whatever it accepts.
+func StreamingHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == orderFeedPath {
+			// Servers without a write timeout do not support this, the
+			// error can be ignored.
+			http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,232 @@
+package aetest
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// sseEvent is an event read from a Server-Sent Events stream.
+type sseEvent struct {
+	id         string
+	event_type string
+	data       string
+}
+
+// openFeed subscribes to the order feed, resuming after last_event_id when it
+// is not empty. The returned channel yields each event received.
+func openFeed(t *testing.T, url string, last_event_id string) (*http.Response, <-chan sseEvent) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	request, err := http.NewRequestWithContext(ctx, "GET", url+"/order-feed", nil)
+	require.NoError(t, err)
+	request.Header.Set("Accept", "text/event-stream")
+	if last_event_id != "" {
+		request.Header.Set("Last-Event-ID", last_event_id)
+	}
+	response, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	t.Cleanup(func() { response.Body.Close() })
+
+	events := make(chan sseEvent, 16)
+	go func() {
+		defer close(events)
+		var event sseEvent
+		scanner := bufio.NewScanner(response.Body)
+		for scanner.Scan() {
+			field, value, _ := strings.Cut(scanner.Text(), ": ")
+			switch field {
+			case "id":
+				event.id = value
+			case "event":
+				event.event_type = value
+			case "data":
+				event.data = value
+			case "":
+				// A blank line ends the event, heartbeats have no
+				// fields.
+				if event.event_type != "" {
+					events <- event
+				}
+				event = sseEvent{}
+			}
+		}
+	}()
+	return response, events
+}
+
+func nextEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	select {
+	case event, ok := <-events:
+		require.True(t, ok, "stream ended")
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+	}
+	return sseEvent{}
+}
+
+func TestOrderFeed(t *testing.T) {
+	ctx := context.Background()
+	feed := NewOrderFeed(FeedConfig{})
+	outbox := NewOutbox()
+	relay, err := NewRelay(outbox, feed, RelayConfig{Name: "feed"})
+	require.NoError(t, err)
+	items, discounts, orders := NewStore()
+	svc := New(items, discounts, orders, WithOutbox(outbox))
+	server := httptest.NewServer(NewOrdersRouter(svc, WithOrderFeed(feed)))
+	t.Cleanup(server.Close)
+
+	response, events := openFeed(t, server.URL, "")
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+
+	placed, err := svc.SimpleSummary(ctx, goodOrderRequest)
+	require.NoError(t, err)
+	cancelled, err := svc.CancelOrder(ctx, CancelOrderRequest{placed.OrderID})
+	require.NoError(t, err)
+	require.NoError(t, relay.Flush(ctx))
+
+	event := nextEvent(t, events)
+	require.Equal(t, "1", event.id)
+	require.Equal(t, EventOrderCreated, event.event_type)
+	var summary OrderSummary
+	require.NoError(t, json.Unmarshal([]byte(event.data), &summary))
+	require.Equal(t, placed, summary)
+
+	event = nextEvent(t, events)
+	require.Equal(t, "2", event.id)
+	require.Equal(t, EventOrderCancelled, event.event_type)
+	require.NoError(t, json.Unmarshal([]byte(event.data), &summary))
+	require.Equal(t, cancelled, summary)
+
+	// Quotes, rejected orders and changes to the catalog are not published.
+	_, err = svc.Quote(ctx, goodOrderRequest)
+	require.NoError(t, err)
+	_, err = svc.CancelOrder(ctx, CancelOrderRequest{placed.OrderID})
+	require.Error(t, err)
+	require.NoError(t, feed.Publish(ctx, newEvent(EventPriceChanged, time.Now())))
+
+	// A subscriber resuming after the first event receives the second,
+	// then new events as they happen.
+	_, resumed := openFeed(t, server.URL, "1")
+	event = nextEvent(t, resumed)
+	require.Equal(t, "2", event.id)
+	_, err = svc.SimpleSummary(ctx, goodOrderRequest)
+	require.NoError(t, err)
+	require.NoError(t, relay.Flush(ctx))
+	require.Equal(t, "3", nextEvent(t, resumed).id)
+	require.Equal(t, "3", nextEvent(t, events).id)
+
+	// Closing the feed ends every stream, events relayed afterwards are
+	// dropped.
+	feed.Close()
+	_, ok := <-events
+	require.False(t, ok)
+	_, err = svc.SimpleSummary(ctx, goodOrderRequest)
+	require.NoError(t, err)
+	require.NoError(t, relay.Flush(ctx))
+}
+
+func TestOrderFeedResetsWhenHistoryIsGone(t *testing.T) {
+	feed := NewOrderFeed(FeedConfig{History: 2})
+	server := httptest.NewServer(NewOrdersRouter(service, WithOrderFeed(feed)))
+	t.Cleanup(server.Close)
+
+	for i := 0; i < 5; i++ {
+		order := OrderSummary{OrderID: strconv.Itoa(i)}
+		require.NoError(t, feed.Publish(context.Background(), orderEvent(EventOrderCreated, order)))
+	}
+
+	// Events 2 and 3 are no longer kept.
+	_, events := openFeed(t, server.URL, "1")
+	event := nextEvent(t, events)
+	require.Equal(t, EventFeedReset, event.event_type)
+	require.Equal(t, "5", event.id)
+
+	// An ID from before a restart is ahead of the feed.
+	_, events = openFeed(t, server.URL, "99")
+	require.Equal(t, EventFeedReset, nextEvent(t, events).event_type)
+
+	// Resuming within the history is not a reset.
+	_, events = openFeed(t, server.URL, "3")
+	require.Equal(t, "4", nextEvent(t, events).id)
+	require.Equal(t, "5", nextEvent(t, events).id)
+
+	response, _ := openFeed(t, server.URL, "not a number")
+	require.Equal(t, http.StatusBadRequest, response.StatusCode)
+}
+
+func TestOrderFeedSlowConsumers(t *testing.T) {
+	// A subscriber that reads nothing fills its buffer of two events.
+	feed := NewOrderFeed(FeedConfig{Buffer: 2})
+	slow, _, _, err := feed.subscribe(false, 0)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, feed.Publish(context.Background(), orderEvent(EventOrderCreated, OrderSummary{})))
+	}
+
+	// It is sent what fitted in the buffer, then disconnected.
+	require.Equal(t, uint64(1), (<-slow.events).id)
+	require.Equal(t, uint64(2), (<-slow.events).id)
+	_, ok := <-slow.events
+	require.False(t, ok)
+
+	// With the drop policy the subscriber misses events instead.
+	feed = NewOrderFeed(FeedConfig{Buffer: 2, SlowConsumers: DropSlowConsumerEvents})
+	slow, _, _, err = feed.subscribe(false, 0)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, feed.Publish(context.Background(), orderEvent(EventOrderCreated, OrderSummary{})))
+	}
+	require.Equal(t, uint64(1), (<-slow.events).id)
+	require.Equal(t, uint64(2), (<-slow.events).id)
+	require.NoError(t, feed.Publish(context.Background(), orderEvent(EventOrderCreated, OrderSummary{})))
+	require.Equal(t, uint64(4), (<-slow.events).id)
+}
+
+func TestStreamingHandlerLiftsWriteTimeout(t *testing.T) {
+	feed := NewOrderFeed(FeedConfig{})
+	server := httptest.NewUnstartedServer(StreamingHandler(NewOrdersRouter(service, WithOrderFeed(feed))))
+	server.Config.WriteTimeout = 50 * time.Millisecond
+	server.Start()
+	t.Cleanup(server.Close)
+
+	// Events are still received after the write timeout has passed.
+	_, events := openFeed(t, server.URL, "")
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, feed.Publish(context.Background(), orderEvent(EventOrderCreated, OrderSummary{})))
+	require.Equal(t, "1", nextEvent(t, events).id)
+
+	// Other requests keep the timeout, even when they accept an event
+	// stream.
+	slow := StreamingHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("late"))
+	}))
+	server = httptest.NewUnstartedServer(slow)
+	server.Config.WriteTimeout = 50 * time.Millisecond
+	server.Start()
+	t.Cleanup(server.Close)
+	request, err := http.NewRequest("GET", server.URL+"/orders", nil)
+	require.NoError(t, err)
+	request.Header.Set("Accept", "text/event-stream")
+	response, err := http.DefaultClient.Do(request)
+	if err == nil {
+		// The response may have been sent before the connection was
+		// closed, but never its body.
+		defer response.Body.Close()
+		_, err = io.ReadAll(response.Body)
+	}
+	require.Error(t, err)
+}
@@ -0,0 +1,185 @@
+package aetest
+
+// The proto is imported from its own directory so that it is registered as
+// "orders.proto", the module option writes the code to orderspb.
+//go:generate -command protoc protoc -Iorderspb
+//go:generate protoc --go_out=. --go_opt=module=aetest orders.proto
+//go:generate protoc --go-grpc_out=. --go-grpc_opt=module=aetest orders.proto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"aetest/orderspb"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcServer is a private struct that adapts the Service to the generated
+// `orderspb.OrdersServer` interface. All business logic remains within the
+// Service, this struct only converts between the protobuf and service types.
+type grpcServer struct {
+	orderspb.UnimplementedOrdersServer
+	svc Service
+}
+
+// NewOrdersGRPCServer creates a gRPC server with the orders Service
+// registered. The returned server is not yet listening, the caller is
+// responsible for calling `Serve` with a listener. Every call is assigned a
+// request ID, taken from the `x-request-id` metadata when supplied.
+func NewOrdersGRPCServer(svc Service, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append([]grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(grpcRequestID),
+		grpc.ChainStreamInterceptor(grpcStreamRequestID),
+	}, opts...)
+	server := grpc.NewServer(opts...)
+	orderspb.RegisterOrdersServer(server, grpcServer{svc: svc})
+	return server
+}
+
+func (s grpcServer) SimpleSummary(
+	ctx context.Context,
+	req *orderspb.OrderRequest,
+) (*orderspb.OrderSummary, error) {
+	cart := make([]Item, 0, len(req.GetCart()))
+	for _, item := range req.GetCart() {
+		cart = append(cart, Item{
+			ItemName: item.GetItemName(),
+			Quantity: int(item.GetQuantity()),
+			SKU:      item.GetSku(),
+		})
+	}
+
+	summary, err := s.svc.SimpleSummary(ctx, OrderRequest{
+		Cart:          cart,
+		Email:         req.GetEmail(),
+		Channel:       req.GetChannel(),
+		CustomerGroup: req.GetCustomerGroup(),
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return toProtoSummary(summary), nil
+}
+
+func (s grpcServer) GetSingleOrder(
+	ctx context.Context,
+	req *orderspb.GetSingleOrderRequest,
+) (*orderspb.OrderSummary, error) {
+	summary, err := s.svc.GetSingleOrder(
+		ctx,
+		GetSingleOrderRequest{OrderID: req.GetOrderId()},
+	)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return toProtoSummary(summary), nil
+}
+
+func (s grpcServer) GetAllOrders(
+	req *orderspb.GetAllOrdersRequest,
+	stream orderspb.Orders_GetAllOrdersServer,
+) error {
+	orders, err := s.svc.GetAllOrders(stream.Context())
+	if err != nil {
+		return grpcError(err)
+	}
+
+	// Stream each stored order individually rather than sending the whole
+	// store in a single message.
+	for _, order := range orders.Orders {
+		if err := stream.Send(toProtoSummary(order)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// toProtoSummary converts an OrderSummary into its protobuf representation.
+func toProtoSummary(summary OrderSummary) *orderspb.OrderSummary {
+	items := make([]*orderspb.ItemWithCost, 0, len(summary.Summary))
+	for _, item := range summary.Summary {
+		items = append(items, &orderspb.ItemWithCost{
+			ItemName:  item.ItemName,
+			Quantity:  int64(item.Quantity),
+			Cost:      int64(item.Cost),
+			Discount:  int64(item.Discount),
+			Sku:       item.SKU,
+			PriceList: item.PriceList,
+		})
+	}
+
+	return &orderspb.OrderSummary{
+		OrderId:        summary.OrderID,
+		Summary:        items,
+		TotalCost:      int64(summary.TotalCost),
+		CreatedAt:      timestamppb.New(summary.CreatedAt),
+		UpdatedAt:      timestamppb.New(summary.UpdatedAt),
+		Status:         summary.Status,
+		DiscountPolicy: string(summary.DiscountPolicy),
+	}
+}
+
+// grpcError maps the Service' errors to the appropriate gRPC status codes.
+// Unknown items are described by a BadRequest detail with a field violation
+// for each unknown cart line.
+func grpcError(err error) error {
+	var unknown *UnknownItemsError
+	if errors.As(err, &unknown) {
+		return unknownItemsStatus(unknown)
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, ErrRequestCancelled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, ErrOrderNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrIntegerOverflow):
+		return status.Error(codes.OutOfRange, err.Error())
+	case errors.Is(err, ErrEventNotRecorded):
+		return status.Error(codes.Unavailable, err.Error())
+	default:
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+}
+
+// unknownItemsStatus converts an *UnknownItemsError into an InvalidArgument
+// status with a field violation per unknown item, giving any suggestions.
+func unknownItemsStatus(unknown *UnknownItemsError) error {
+	violations := make([]*errdetails.BadRequest_FieldViolation, 0, len(unknown.Items))
+	for _, item := range unknown.Items {
+		field, ref := "item_name", item.ItemName
+		if item.SKU != "" {
+			field, ref = "sku", item.SKU
+		}
+
+		description := fmt.Sprintf("item %q does not exist", ref)
+		if len(item.Suggestions) > 0 {
+			description += ", did you mean " + strings.Join(item.Suggestions, " or ") + "?"
+		}
+
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{
+			Field:       fmt.Sprintf("cart[%d].%s", item.Line, field),
+			Description: description,
+		})
+	}
+
+	st := status.New(codes.InvalidArgument, unknown.Error())
+	if with_details, err := st.WithDetails(&errdetails.BadRequest{
+		FieldViolations: violations,
+	}); err == nil {
+		st = with_details
+	}
+	return st.Err()
+}
@@ -0,0 +1,123 @@
+package aetest
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"aetest/orderspb"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newTestGRPCClient starts an in-memory gRPC server for the supplied Service
+// and returns a client connected to it.
+func newTestGRPCClient(t *testing.T, svc Service) orderspb.OrdersClient {
+	listener := bufconn.Listen(1024 * 1024)
+	server := NewOrdersGRPCServer(svc)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient(
+		"passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return orderspb.NewOrdersClient(conn)
+}
+
+func TestGRPCSubmitAndGetOrder(t *testing.T) {
+	items, discounts, _ := NewStore()
+	client := newTestGRPCClient(t, New(items, discounts, make(OrderStore)))
+	ctx := context.Background()
+
+	summary, err := client.SimpleSummary(ctx, &orderspb.OrderRequest{
+		Cart: []*orderspb.Item{
+			{ItemName: "Apples", Quantity: 2},
+			{ItemName: "Oranges", Quantity: 3},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(110), summary.GetTotalCost(), "incorrect order total")
+
+	stored, err := client.GetSingleOrder(ctx, &orderspb.GetSingleOrderRequest{
+		OrderId: summary.GetOrderId(),
+	})
+	require.NoError(t, err)
+	require.Equal(t, summary.GetOrderId(), stored.GetOrderId())
+	require.Equal(t, summary.GetTotalCost(), stored.GetTotalCost())
+}
+
+func TestGRPCErrorCodes(t *testing.T) {
+	items, discounts, _ := NewStore()
+	client := newTestGRPCClient(t, New(items, discounts, make(OrderStore)))
+	ctx := context.Background()
+
+	_, err := client.SimpleSummary(ctx, &orderspb.OrderRequest{
+		Cart: []*orderspb.Item{{ItemName: "Magazine", Quantity: 1}},
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.GetSingleOrder(ctx, &orderspb.GetSingleOrderRequest{
+		OrderId: uuid.NewV4().String(),
+	})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestGRPCStreamAllOrders(t *testing.T) {
+	items, discounts, _ := NewStore()
+	client := newTestGRPCClient(t, New(items, discounts, make(OrderStore)))
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		_, err := client.SimpleSummary(ctx, &orderspb.OrderRequest{
+			Cart: []*orderspb.Item{{ItemName: "Apples", Quantity: 1}},
+		})
+		require.NoError(t, err)
+	}
+
+	stream, err := client.GetAllOrders(ctx, &orderspb.GetAllOrdersRequest{})
+	require.NoError(t, err)
+
+	received := 0
+	for {
+		_, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		received++
+	}
+	require.Equal(t, 3, received, "all stored orders should be streamed")
+}
+
+func TestGRPCUnknownItemDetails(t *testing.T) {
+	items, discounts, _ := NewStore()
+	client := newTestGRPCClient(t, New(items, discounts, make(OrderStore)))
+
+	_, err := client.SimpleSummary(context.Background(), &orderspb.OrderRequest{
+		Cart: []*orderspb.Item{{ItemName: "Aples", Quantity: 1}},
+	})
+	st := status.Convert(err)
+	require.Equal(t, codes.InvalidArgument, st.Code())
+
+	require.Len(t, st.Details(), 1)
+	bad_request, ok := st.Details()[0].(*errdetails.BadRequest)
+	require.True(t, ok)
+	require.Len(t, bad_request.GetFieldViolations(), 1)
+	require.Equal(t, "cart[0].item_name", bad_request.GetFieldViolations()[0].GetField())
+	require.Contains(t, bad_request.GetFieldViolations()[0].GetDescription(), "did you mean Apples?")
+}
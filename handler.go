@@ -1,19 +1,123 @@
 package aetest
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-func NewOrdersRouter(svc Service) http.Handler {
+// RouterOption configures optional behaviour of the router created by
+// NewOrdersRouter, i.e. middleware and any extra routes.
+type RouterOption func(*gin.Engine)
+
+// StatusClientClosedRequest is the non-standard status code, popularised by
+// nginx, recorded when the caller goes away before its request completes. The
+// caller never sees it but it is logged and counted like any other status.
+const StatusClientClosedRequest = 499
+
+// WithRequestTimeout sets a deadline on the context of every request. A
+// request that has not completed within the timeout is abandoned by the
+// Service and answered with `504 Gateway Timeout`.
+func WithRequestTimeout(timeout time.Duration) RouterOption {
+	return func(router *gin.Engine) {
+		router.Use(func(c *gin.Context) {
+			ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+			defer cancel()
+
+			c.Request = c.Request.WithContext(ctx)
+			c.Next()
+		})
+	}
+}
+
+// WithMaxBodySize limits request bodies to max bytes. Requests declaring a
+// larger Content-Length are rejected with `413 Request Entity Too Large`
+// before being read, larger bodies without a declared length fail to decode
+// once max bytes have been read. Imports are exempt, their limit is set by
+// WithMaxImportSize.
+func WithMaxBodySize(max int64) RouterOption {
+	return limitBodies(max, func(path string) bool { return path != importPath })
+}
+
+// WithMaxImportSize limits the bodies of `/import-orders` requests to max
+// bytes, in the same way as WithMaxBodySize. An export of every order is far
+// larger than any other request, imports are not limited unless this is set.
+func WithMaxImportSize(max int64) RouterOption {
+	return limitBodies(max, func(path string) bool { return path == importPath })
+}
+
+// importPath is the route exported orders are imported on.
+const importPath = "/import-orders"
+
+// limitBodies limits the bodies of the requests to paths for which applies
+// returns true to max bytes.
+func limitBodies(max int64, applies func(path string) bool) RouterOption {
+	return func(router *gin.Engine) {
+		router.Use(func(c *gin.Context) {
+			if !applies(c.Request.URL.Path) {
+				c.Next()
+				return
+			}
+			if c.Request.ContentLength > max {
+				c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, GenericErrResponse{
+					Err: "request body too large",
+				})
+				return
+			}
+
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, max)
+			c.Next()
+		})
+	}
+}
+
+// errorResponse returns the GenericErrResponse for an error returned by the
+// Service, listing the unknown items of an *UnknownItemsError so that callers
+// can correct them.
+func errorResponse(err error) GenericErrResponse {
+	response := GenericErrResponse{Err: err.Error()}
+
+	var unknown *UnknownItemsError
+	if errors.As(err, &unknown) {
+		response.UnknownItems = unknown.Items
+	}
+	return response
+}
+
+// errorStatus returns the status code for an error returned by the Service.
+// Requests abandoned because their deadline passed, or because the caller went
+// away, have their own status codes, any other error uses fallback.
+func errorStatus(err error, fallback int) int {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, ErrRequestCancelled):
+		return StatusClientClosedRequest
+	case errors.Is(err, ErrEventNotRecorded):
+		return http.StatusServiceUnavailable
+	default:
+		return fallback
+	}
+}
+
+// NewOrdersRouter creates the HTTP routes for the orders Service. Options are
+// applied before the orders routes are registered so that any middleware they
+// add applies to every route.
+func NewOrdersRouter(svc Service, opts ...RouterOption) http.Handler {
 	router := gin.New()
 
 	// Ignoring extra router options i.e. cors, timeouts, allowed methods etc.
 	// for simplicity.
 	router.Use(gin.Recovery())
 
+	for _, opt := range opts {
+		opt(router)
+	}
+
 	router.POST("/submit-order", func(c *gin.Context) {
 		var request OrderRequest
 
@@ -30,13 +134,12 @@ func NewOrdersRouter(svc Service) http.Handler {
 		// Submit an order request to the `Service`, if successful this will
 		// return an OrderSummary and a nil error. If an error has occurred,
 		// this returns and empty OrderSummary and an error.
-		response, err := svc.SimpleSummary(request)
+		response, err := svc.SimpleSummary(c.Request.Context(), request)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, GenericErrResponse{
-				Err: err.Error(),
-			})
+			c.JSON(errorStatus(err, http.StatusBadRequest), errorResponse(err))
 			return
 		}
+		c.Set(orderIDKey, response.OrderID)
 
 		// Serialize response as JSON and return to caller with a `Ok` status.
 		c.JSON(http.StatusOK, response)
@@ -58,7 +161,8 @@ func NewOrdersRouter(svc Service) http.Handler {
 		// Submit a get single order to the `Service`, if successful this will
 		// return an OrderSummary and a nil error. If an error has occurred,
 		// this returns and empty OrderSummary and an error.
-		response, err := svc.GetSingleOrder(request)
+		c.Set(orderIDKey, request.OrderID)
+		response, err := svc.GetSingleOrder(c.Request.Context(), request)
 		if err != nil {
 			// Malformed request, respond with 400
 			if ok := errors.Is(err, ErrInvalidRequest); ok {
@@ -69,7 +173,7 @@ func NewOrdersRouter(svc Service) http.Handler {
 			}
 
 			// Order not found, respond with 404
-			c.JSON(http.StatusNotFound, GenericErrResponse{
+			c.JSON(errorStatus(err, http.StatusNotFound), GenericErrResponse{
 				Err: err.Error(),
 			})
 			return
@@ -79,13 +183,322 @@ func NewOrdersRouter(svc Service) http.Handler {
 		c.JSON(http.StatusOK, response)
 	})
 
+	router.POST("/cancel-order", func(c *gin.Context) {
+		var request CancelOrderRequest
+
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, GenericErrResponse{
+				Err: err.Error(),
+			})
+			return
+		}
+
+		// Cancel the order, respond with the cancelled OrderSummary.
+		c.Set(orderIDKey, request.OrderID)
+		response, err := svc.CancelOrder(c.Request.Context(), request)
+		if err != nil {
+			switch {
+			// Malformed request, respond with 400
+			case errors.Is(err, ErrInvalidRequest):
+				c.JSON(http.StatusBadRequest, GenericErrResponse{
+					Err: err.Error(),
+				})
+			// Already cancelled, respond with 409
+			case errors.Is(err, ErrOrderCancelled):
+				c.JSON(http.StatusConflict, GenericErrResponse{
+					Err: err.Error(),
+				})
+			// Order not found, respond with 404
+			default:
+				c.JSON(errorStatus(err, http.StatusNotFound), GenericErrResponse{
+					Err: err.Error(),
+				})
+			}
+			return
+		}
+
+		c.JSON(http.StatusOK, response)
+	})
+
 	router.GET("get-all-orders", func(c *gin.Context) {
 		// Submit a get all orders request to the `Service`. This will return
 		// a GetAllOrders object. If no orders exist in the OrderStore, this
 		// returns an Okay status with an empty response.
-		orders := svc.GetAllOrders()
+		orders, err := svc.GetAllOrders(c.Request.Context())
+		if err != nil {
+			c.JSON(errorStatus(err, http.StatusInternalServerError), GenericErrResponse{
+				Err: err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, orders)
+	})
+
+	router.POST("/get-orders-in-range", func(c *gin.Context) {
+		var request OrdersInRangeRequest
+
+		// Deserialize JSON POST request into the OrdersInRangeRequest struct,
+		// times must be in RFC 3339 format. If serialization fails return a
+		// `GenericErrResponse` to the caller with the appropriate status code
+		// for bad request.
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, GenericErrResponse{
+				Err: err.Error(),
+			})
+			return
+		}
+
+		// Submit a get orders in range request to the `Service`. If no orders
+		// were created within the range, this returns an Okay status with an
+		// empty response.
+		orders, err := svc.GetOrdersInRange(c.Request.Context(), request)
+		if err != nil {
+			c.JSON(errorStatus(err, http.StatusBadRequest), GenericErrResponse{
+				Err: err.Error(),
+			})
+			return
+		}
+
 		c.JSON(http.StatusOK, orders)
 	})
 
+	router.POST("/quote-order", func(c *gin.Context) {
+		var request OrderRequest
+
+		// Deserialize JSON POST request into the OrderRequest struct, if
+		// serialization fails return a `GenericErrResponse` to the caller with
+		// the appropriate status code for bad request.
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, GenericErrResponse{
+				Err: err.Error(),
+			})
+			return
+		}
+
+		// Price the order request without storing it. The OrderSummary
+		// returned has no order_id.
+		response, err := svc.Quote(c.Request.Context(), request)
+		if err != nil {
+			c.JSON(errorStatus(err, http.StatusBadRequest), errorResponse(err))
+			return
+		}
+
+		c.JSON(http.StatusOK, response)
+	})
+
+	router.POST("/explain-price", func(c *gin.Context) {
+		var request ExplainPriceRequest
+
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, GenericErrResponse{
+				Err: err.Error(),
+			})
+			return
+		}
+
+		// Price the order request without storing it, returning every step
+		// taken to price it.
+		response, err := svc.ExplainPrice(c.Request.Context(), request)
+		if err != nil {
+			c.JSON(errorStatus(err, http.StatusBadRequest), errorResponse(err))
+			return
+		}
+
+		c.JSON(http.StatusOK, response)
+	})
+
+	router.GET("/get-catalog", func(c *gin.Context) {
+		catalog, err := svc.GetCatalog(c.Request.Context())
+		if err != nil {
+			c.JSON(errorStatus(err, http.StatusInternalServerError), GenericErrResponse{
+				Err: err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, catalog)
+	})
+
+	router.POST("/set-item-price", func(c *gin.Context) {
+		var request SetItemPriceRequest
+
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, GenericErrResponse{
+				Err: err.Error(),
+			})
+			return
+		}
+
+		// Add or update the item in the catalog, respond with the stored
+		// CatalogItem.
+		response, err := svc.SetItemPrice(c.Request.Context(), request)
+		if err != nil {
+			c.JSON(errorStatus(err, http.StatusBadRequest), GenericErrResponse{
+				Err: err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, response)
+	})
+
+	router.POST("/get-price-history", func(c *gin.Context) {
+		var request PriceHistoryRequest
+
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, GenericErrResponse{
+				Err: err.Error(),
+			})
+			return
+		}
+
+		// Respond with every price of the item, past and scheduled.
+		response, err := svc.GetPriceHistory(c.Request.Context(), request)
+		if err != nil {
+			// Malformed request, respond with 400
+			if ok := errors.Is(err, ErrInvalidRequest); ok {
+				c.JSON(http.StatusBadRequest, GenericErrResponse{
+					Err: err.Error(),
+				})
+				return
+			}
+
+			// Item not found, respond with 404
+			c.JSON(errorStatus(err, http.StatusNotFound), GenericErrResponse{
+				Err: err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, response)
+	})
+
+	router.POST("/remove-item", func(c *gin.Context) {
+		var request RemoveItemRequest
+
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, GenericErrResponse{
+				Err: err.Error(),
+			})
+			return
+		}
+
+		if err := svc.RemoveItem(c.Request.Context(), request); err != nil {
+			// Malformed request, respond with 400
+			if ok := errors.Is(err, ErrInvalidRequest); ok {
+				c.JSON(http.StatusBadRequest, GenericErrResponse{
+					Err: err.Error(),
+				})
+				return
+			}
+
+			// Item not found, respond with 404
+			c.JSON(errorStatus(err, http.StatusNotFound), GenericErrResponse{
+				Err: err.Error(),
+			})
+			return
+		}
+
+		// Nothing to return on success.
+		c.Status(http.StatusNoContent)
+	})
+
+	router.GET("/export-orders", func(c *gin.Context) {
+		format, err := ParseExportFormat(c.Query("format"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, GenericErrResponse{
+				Err: err.Error(),
+			})
+			return
+		}
+
+		orders, err := svc.GetAllOrders(c.Request.Context())
+		if err != nil {
+			c.JSON(errorStatus(err, http.StatusInternalServerError), GenericErrResponse{
+				Err: err.Error(),
+			})
+			return
+		}
+
+		// The writer is created before the status is set so that a failure
+		// can still be reported.
+		writer, err := NewOrderWriter(c.Writer, format)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrUnknownFormat) {
+				status = http.StatusBadRequest
+			}
+			c.JSON(status, GenericErrResponse{
+				Err: err.Error(),
+			})
+			return
+		}
+
+		// Stream the orders to the caller one at a time, flushing after
+		// each so large stores are not buffered in full.
+		c.Header("Content-Type", format.ContentType())
+		c.Header(
+			"Content-Disposition",
+			"attachment; filename=orders."+string(format),
+		)
+		c.Status(http.StatusOK)
+		for _, order := range orders.Orders {
+			if err := writer.Write(order); err != nil {
+				// The caller has gone away, nothing more can be sent.
+				return
+			}
+			writer.Flush()
+			c.Writer.Flush()
+		}
+		writer.Flush()
+	})
+
+	router.POST(importPath, func(c *gin.Context) {
+		format, err := ParseExportFormat(c.Query("format"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, GenericErrResponse{
+				Err: err.Error(),
+			})
+			return
+		}
+
+		orders, err := ReadOrders(c.Request.Body, format)
+		if err != nil {
+			// The body was cut off by the import size limit, respond with
+			// 413 rather than the error decoding the truncated body.
+			var too_large *http.MaxBytesError
+			if errors.As(err, &too_large) {
+				c.JSON(http.StatusRequestEntityTooLarge, GenericErrResponse{
+					Err: fmt.Sprintf("import larger than %d bytes", too_large.Limit),
+				})
+				return
+			}
+
+			c.JSON(http.StatusBadRequest, GenericErrResponse{
+				Err: err.Error(),
+			})
+			return
+		}
+
+		response, err := svc.ImportOrders(c.Request.Context(), orders)
+		if err != nil {
+			// Conflicting order_id, respond with 409
+			if ok := errors.Is(err, ErrOrderExists); ok {
+				c.JSON(http.StatusConflict, GenericErrResponse{
+					Err: err.Error(),
+				})
+				return
+			}
+
+			c.JSON(errorStatus(err, http.StatusBadRequest), GenericErrResponse{
+				Err: err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, response)
+	})
+
 	return router
 }
@@ -0,0 +1,25 @@
+package aetest
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxBodySize(t *testing.T) {
+	items, discounts, _ := NewStore()
+	orders := make(OrderStore)
+	router := NewOrdersRouter(New(items, discounts, orders), WithMaxBodySize(256))
+
+	response := postJSON(t, router, "/submit-order", goodOrderRequest)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	large := OrderRequest{}
+	for i := 0; i < 20; i++ {
+		large.Cart = append(large.Cart, Item{ItemName: "Apples", Quantity: 1})
+	}
+	response = postJSON(t, router, "/submit-order", large)
+	require.Equal(t, http.StatusRequestEntityTooLarge, response.StatusCode)
+	require.Len(t, orders, 1)
+}
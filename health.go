@@ -0,0 +1,166 @@
+package aetest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Version and Commit identify the build, they are reported by `/version`.
+// Both are set at link time, e.g.
+//
+//	go build -ldflags "-X aetest.Version=1.4.0 -X aetest.Commit=$(git rev-parse HEAD)" ./cmd
+//
+// When Commit is not set the VCS revision embedded by the Go toolchain is used.
+var (
+	Version = "dev"
+	Commit  = ""
+)
+
+// readinessTimeout bounds how long the readiness checks may take, a probe that
+// cannot reach the store promptly is failing.
+const readinessTimeout = time.Second
+
+// Health reports the liveness and readiness of the server. The server is ready
+// once its store is reachable and its catalog is loaded, and stops being ready
+// as soon as SetShuttingDown is called so that no new requests are routed to
+// it while in-flight requests drain.
+type Health struct {
+	svc           Service
+	shutting_down atomic.Bool
+}
+
+// NewHealth creates the Health of a server running the Service.
+func NewHealth(svc Service) *Health {
+	return &Health{svc: svc}
+}
+
+// SetShuttingDown marks the server as shutting down, `/readyz` fails from
+// then on.
+func (h *Health) SetShuttingDown() {
+	h.shutting_down.Store(true)
+}
+
+// WithHealth serves the `/healthz`, `/readyz` and `/version` endpoints.
+//
+// `/healthz` always succeeds while the process can handle requests. `/readyz`
+// responds with `503 Service Unavailable` when any readiness check fails.
+func WithHealth(h *Health) RouterOption {
+	return func(router *gin.Engine) {
+		router.GET("/healthz", func(c *gin.Context) {
+			c.JSON(http.StatusOK, HealthStatus{Status: "ok"})
+		})
+
+		router.GET("/readyz", func(c *gin.Context) {
+			response := h.Ready(c.Request.Context())
+			if response.Status != "ok" {
+				c.JSON(http.StatusServiceUnavailable, response)
+				return
+			}
+			c.JSON(http.StatusOK, response)
+		})
+
+		router.GET("/version", func(c *gin.Context) {
+			catalog_version, err := CatalogVersion(c.Request.Context(), h.svc)
+			if err != nil {
+				c.JSON(errorStatus(err, http.StatusInternalServerError), GenericErrResponse{
+					Err: err.Error(),
+				})
+				return
+			}
+
+			c.JSON(http.StatusOK, VersionInfo{
+				Version:        Version,
+				Commit:         buildCommit(),
+				CatalogVersion: catalog_version,
+			})
+		})
+	}
+}
+
+// Ready runs the readiness checks, the status is "ok" only if every check
+// passes.
+func (h *Health) Ready(ctx context.Context) HealthStatus {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	checks := map[string]string{
+		"store":    "ok",
+		"catalog":  "ok",
+		"shutdown": "ok",
+	}
+
+	stats, err := h.svc.Stats(ctx)
+	if err != nil {
+		checks["store"] = err.Error()
+		checks["catalog"] = "unknown"
+	} else if stats.Items == 0 {
+		checks["catalog"] = "no items in catalog"
+	}
+	if h.shutting_down.Load() {
+		checks["shutdown"] = "shutting down"
+	}
+
+	status := "ok"
+	for _, result := range checks {
+		if result != "ok" {
+			status = "unavailable"
+		}
+	}
+
+	return HealthStatus{Status: status, Checks: checks}
+}
+
+// CatalogVersion returns a short hash of the Service' catalog, the items'
+// names and every price in their history, including those scheduled for
+// the future. Two servers report the same version only if their catalogs
+// are identical, so the version changes whenever a price is set or
+// scheduled, or an item removed.
+func CatalogVersion(ctx context.Context, svc Service) (string, error) {
+	catalog, err := svc.GetCatalog(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	for _, item := range catalog.Items {
+		history, err := svc.GetPriceHistory(ctx, PriceHistoryRequest{SKU: item.SKU})
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(hash, "%s %q %q=%d", item.SKU, item.ItemName, item.Aliases, item.Cost)
+		for _, price := range history.Prices {
+			if price.EffectiveFrom == nil {
+				fmt.Fprintf(hash, " %d", price.Cost)
+				continue
+			}
+			fmt.Fprintf(hash, " %d@%s", price.Cost, price.EffectiveFrom.UTC().Format(time.RFC3339Nano))
+		}
+		fmt.Fprintln(hash)
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16], nil
+}
+
+// buildCommit returns Commit, or the VCS revision recorded in the binary when
+// Commit was not set at link time.
+func buildCommit() string {
+	if Commit != "" {
+		return Commit
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+	}
+	return "unknown"
+}
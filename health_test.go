@@ -0,0 +1,80 @@
+package aetest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func getJSON(t *testing.T, router http.Handler, path string, response interface{}) int {
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(response))
+	return rec.Code
+}
+
+func TestHealthProbes(t *testing.T) {
+	items, discounts, orders := NewStore()
+	svc := New(items, discounts, orders)
+	health := NewHealth(svc)
+	router := NewOrdersRouter(svc, WithHealth(health))
+
+	var status HealthStatus
+	require.Equal(t, http.StatusOK, getJSON(t, router, "/healthz", &status))
+	require.Equal(t, "ok", status.Status)
+
+	require.Equal(t, http.StatusOK, getJSON(t, router, "/readyz", &status))
+	require.Equal(t, "ok", status.Status)
+
+	// Readiness fails once shutdown begins, liveness does not.
+	health.SetShuttingDown()
+	require.Equal(t, http.StatusServiceUnavailable, getJSON(t, router, "/readyz", &status))
+	require.Equal(t, "unavailable", status.Status)
+	require.Equal(t, "shutting down", status.Checks["shutdown"])
+	require.Equal(t, http.StatusOK, getJSON(t, router, "/healthz", &status))
+}
+
+func TestNotReadyWithEmptyCatalog(t *testing.T) {
+	svc := New(make(ItemStore), make(ItemDiscount), make(OrderStore))
+	router := NewOrdersRouter(svc, WithHealth(NewHealth(svc)))
+
+	var status HealthStatus
+	require.Equal(t, http.StatusServiceUnavailable, getJSON(t, router, "/readyz", &status))
+	require.Equal(t, "no items in catalog", status.Checks["catalog"])
+	require.Equal(t, "ok", status.Checks["store"])
+}
+
+func TestVersion(t *testing.T) {
+	items, discounts, orders := NewStore()
+	svc := New(items, discounts, orders)
+	router := NewOrdersRouter(svc, WithHealth(NewHealth(svc)))
+
+	var before VersionInfo
+	require.Equal(t, http.StatusOK, getJSON(t, router, "/version", &before))
+	require.Equal(t, Version, before.Version)
+	require.NotEmpty(t, before.Commit)
+	require.Len(t, before.CatalogVersion, 16)
+
+	// The catalog version changes with the catalog's prices.
+	response := postJSON(t, router, "/set-item-price", SetItemPriceRequest{ItemName: "Apples", Cost: 70})
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	var after VersionInfo
+	require.Equal(t, http.StatusOK, getJSON(t, router, "/version", &after))
+	require.NotEqual(t, before.CatalogVersion, after.CatalogVersion)
+
+	// So does scheduling a price, although the current prices are unchanged.
+	from := time.Now().Add(time.Hour)
+	response = postJSON(t, router, "/set-item-price", SetItemPriceRequest{
+		ItemName: "Apples", Cost: 80, EffectiveFrom: &from,
+	})
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	var scheduled VersionInfo
+	require.Equal(t, http.StatusOK, getJSON(t, router, "/version", &scheduled))
+	require.NotEqual(t, after.CatalogVersion, scheduled.CatalogVersion)
+}
@@ -0,0 +1,190 @@
+package aetest
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	uuid "github.com/satori/go.uuid"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// RequestIDHeader is the HTTP header, and gRPC metadata key, used to correlate
+// all log lines written while handling a single request. A request ID supplied
+// by the caller is reused, otherwise one is generated.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength limits the size of caller supplied request IDs so that a
+// caller cannot inflate every log line, longer IDs are replaced.
+const maxRequestIDLength = 128
+
+// requestIDKey is the context key for the request ID.
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a copy of ctx carrying the request ID.
+func ContextWithRequestID(ctx context.Context, request_id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, request_id)
+}
+
+// RequestIDFromContext returns the request ID carried by ctx, or the empty
+// string if there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	request_id, _ := ctx.Value(requestIDKey{}).(string)
+	return request_id
+}
+
+// requestIDOrNew returns the supplied request ID if it is usable, otherwise a
+// newly generated one.
+func requestIDOrNew(request_id string) string {
+	if request_id == "" || len(request_id) > maxRequestIDLength {
+		return uuid.NewV4().String()
+	}
+	for _, r := range request_id {
+		// Only printable ASCII is accepted to keep log lines clean.
+		if r < 0x21 || r > 0x7e {
+			return uuid.NewV4().String()
+		}
+	}
+	return request_id
+}
+
+// contextHandler is a slog.Handler that adds the request ID, and trace ID if
+// any, carried by the context of each log call, so that the Service does not
+// need to add them to every log line itself.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if request_id := RequestIDFromContext(ctx); request_id != "" {
+		record.AddAttrs(slog.String("request_id", request_id))
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		record.AddAttrs(slog.String("trace_id", span.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// withRequestID wraps the logger's handler so that request IDs are logged.
+func withRequestID(logger *slog.Logger) *slog.Logger {
+	if _, ok := logger.Handler().(contextHandler); ok {
+		return logger
+	}
+	return slog.New(contextHandler{logger.Handler()})
+}
+
+// discardLogger is the default logger of the Service, nothing is logged.
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// WithLogger sets the logger used by the Service. Log lines written during a
+// request include the request ID carried by the context passed to the Service.
+func WithLogger(logger *slog.Logger) Option {
+	return func(svc *orderService) {
+		svc.logger = withRequestID(logger)
+	}
+}
+
+// WithRequestLogging assigns every request a request ID, which is returned in
+// the `X-Request-ID` response header and carried by the request's context into
+// the Service, and logs a line per request once it has been handled.
+func WithRequestLogging(logger *slog.Logger) RouterOption {
+	logger = withRequestID(logger)
+
+	return func(router *gin.Engine) {
+		router.Use(func(c *gin.Context) {
+			start := time.Now()
+
+			request_id := requestIDOrNew(c.GetHeader(RequestIDHeader))
+			c.Header(RequestIDHeader, request_id)
+			ctx := ContextWithRequestID(c.Request.Context(), request_id)
+			c.Request = c.Request.WithContext(ctx)
+
+			c.Next()
+
+			status := c.Writer.Status()
+			level := slog.LevelInfo
+			if status >= 500 {
+				level = slog.LevelError
+			} else if status >= 400 {
+				level = slog.LevelWarn
+			}
+
+			attrs := []slog.Attr{
+				slog.String("method", c.Request.Method),
+				slog.String("route", c.FullPath()),
+				slog.String("path", c.Request.URL.Path),
+				slog.Int("status", status),
+				slog.Duration("latency", time.Since(start)),
+				slog.String("client_ip", c.ClientIP()),
+			}
+			// Handlers that deal with a single order record its order_id.
+			if order_id := c.GetString(orderIDKey); order_id != "" {
+				attrs = append(attrs, slog.String("order_id", order_id))
+			}
+
+			logger.LogAttrs(ctx, level, "request handled", attrs...)
+		})
+	}
+}
+
+// orderIDKey is the gin context key that handlers set to the order_id of the
+// order they handled, for use in the request log.
+const orderIDKey = "order_id"
+
+// grpcRequestID is a unary interceptor that carries the request ID from the
+// incoming metadata, or a newly generated one, into the handler's context.
+func grpcRequestID(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	return handler(grpcContextWithRequestID(ctx), req)
+}
+
+// grpcStreamRequestID is the streaming equivalent of grpcRequestID.
+func grpcStreamRequestID(
+	srv interface{},
+	stream grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	return handler(srv, requestIDStream{
+		stream,
+		grpcContextWithRequestID(stream.Context()),
+	})
+}
+
+func grpcContextWithRequestID(ctx context.Context) context.Context {
+	request_id := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(RequestIDHeader); len(values) > 0 {
+			request_id = values[0]
+		}
+	}
+	request_id = requestIDOrNew(request_id)
+	grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, request_id))
+	return ContextWithRequestID(ctx, request_id)
+}
+
+// requestIDStream overrides the context of a grpc.ServerStream.
+type requestIDStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s requestIDStream) Context() context.Context {
+	return s.ctx
+}
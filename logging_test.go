@@ -0,0 +1,78 @@
+package aetest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http/httptest"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// decodeLogLines decodes every JSON log line written to buf.
+func decodeLogLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestRequestLogsAreCorrelated(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	items, discounts, _ := NewStore()
+	svc := New(items, discounts, make(OrderStore), WithLogger(logger))
+	router := NewOrdersRouter(svc, WithRequestLogging(logger))
+
+	JSON, err := json.Marshal(goodOrderRequest)
+	require.NoError(t, err)
+	request := httptest.NewRequest("POST", "/submit-order", bytes.NewReader(JSON))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(RequestIDHeader, "checkout-42")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, request)
+
+	require.Equal(t, "checkout-42", rec.Header().Get(RequestIDHeader))
+	var summary OrderSummary
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&summary))
+
+	// Both the service log line and the request log line carry the request
+	// ID and the order ID.
+	lines := decodeLogLines(t, &buf)
+	require.Len(t, lines, 2)
+	require.Equal(t, "order submitted", lines[0]["msg"])
+	require.Equal(t, "request handled", lines[1]["msg"])
+	for _, line := range lines {
+		require.Equal(t, "checkout-42", line["request_id"])
+		require.Equal(t, summary.OrderID, line["order_id"])
+	}
+	require.Equal(t, "/submit-order", lines[1]["route"])
+	require.Equal(t, float64(200), lines[1]["status"])
+	require.Equal(t, "INFO", lines[1]["level"])
+}
+
+func TestRequestIDIsGenerated(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	router := NewOrdersRouter(service, WithRequestLogging(logger))
+
+	request := httptest.NewRequest("GET", "/get-all-orders", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, request)
+
+	request_id := rec.Header().Get(RequestIDHeader)
+	_, err := uuid.FromString(request_id)
+	require.NoError(t, err, "a uuid request id should be generated")
+
+	lines := decodeLogLines(t, &buf)
+	require.Len(t, lines, 1)
+	require.Equal(t, request_id, lines[0]["request_id"])
+}
@@ -0,0 +1,320 @@
+package aetest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// errorLabels are the label values used for each of the Service' sentinel
+// errors. Errors that do not match a sentinel are labelled "other".
+var errorLabels = []struct {
+	err   error
+	label string
+}{
+	{ErrInvalidRequest, "invalid_request"},
+	{ErrItemDoesNotExist, "item_does_not_exist"},
+	{ErrIntegerOverflow, "integer_overflow"},
+	{ErrOrderNotFound, "order_not_found"},
+	{ErrOrderExists, "order_exists"},
+	{ErrOrderCancelled, "order_cancelled"},
+	{ErrRequestCancelled, "request_cancelled"},
+	{ErrEventNotRecorded, "event_not_recorded"},
+}
+
+// Metrics holds the Prometheus collectors for the orders service. The
+// collectors are registered with a dedicated registry that is served by
+// Handler, rather than the global default registry. skus are the label values
+// of the revenue and discount counters, so that they can be deleted once
+// their item is removed from the catalog.
+type Metrics struct {
+	registry  *prometheus.Registry
+	requests  *prometheus.CounterVec
+	latency   *prometheus.HistogramVec
+	orders    prometheus.Counter
+	revenue   *prometheus.CounterVec
+	discounts *prometheus.CounterVec
+	errors    *prometheus.CounterVec
+
+	mu   sync.Mutex
+	skus map[string]bool
+}
+
+// NewMetrics creates and registers the orders service collectors along with
+// the standard Go runtime and process collectors.
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "aetest_http_requests_total",
+			Help: "Number of HTTP requests by route, method and status code.",
+		}, []string{"route", "method", "status"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "aetest_http_request_duration_seconds",
+			Help:    "Latency of HTTP requests by route and method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		orders: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "aetest_orders_submitted_total",
+			Help: "Number of orders successfully submitted.",
+		}),
+		revenue: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "aetest_item_revenue_total",
+			Help: "Revenue of submitted orders by item SKU, after discounts.",
+		}, []string{"sku"}),
+		discounts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "aetest_item_discount_total",
+			Help: "Discount given on submitted orders by item SKU.",
+		}, []string{"sku"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "aetest_errors_total",
+			Help: "Number of errors returned by the service by method and error.",
+		}, []string{"method", "error"}),
+		skus: map[string]bool{},
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.latency,
+		m.orders,
+		m.revenue,
+		m.discounts,
+		m.errors,
+	)
+
+	return m
+}
+
+// WithMetrics records request metrics for every route of the router and
+// serves the metrics at `/metrics`.
+func WithMetrics(m *Metrics) RouterOption {
+	return func(router *gin.Engine) {
+		router.Use(m.Middleware())
+		router.GET("/metrics", gin.WrapH(m.Handler()))
+	}
+}
+
+// Handler serves the registered metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Middleware records the count and latency of every request by the route it
+// was registered with, requests that match no route are labelled
+// "unmatched".
+func (m *Metrics) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+
+		m.requests.WithLabelValues(route, c.Request.Method, status).Inc()
+		m.latency.WithLabelValues(route, c.Request.Method).
+			Observe(time.Since(start).Seconds())
+	}
+}
+
+// Instrument wraps the Service so that submitted orders and errors are
+// recorded, and registers gauges reporting the size of its stores. Instrument
+// must only be called once for each Metrics.
+func (m *Metrics) Instrument(svc Service) Service {
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "aetest_stored_orders",
+			Help: "Number of orders in the order store.",
+		}, func() float64 { return float64(storeStats(svc).Orders) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "aetest_catalog_items",
+			Help: "Number of items in the catalog.",
+		}, func() float64 { return float64(storeStats(svc).Items) }),
+	)
+
+	return instrumentedService{svc, m}
+}
+
+// storeStats returns the Service' store sizes for the gauges. The context is
+// never cancelled so the error can be ignored.
+func storeStats(svc Service) StoreStats {
+	stats, _ := svc.Stats(context.Background())
+	return stats
+}
+
+// observeError increments the error counter when err is non-nil.
+func (m *Metrics) observeError(method string, err error) {
+	if err == nil {
+		return
+	}
+
+	label := "other"
+	for _, sentinel := range errorLabels {
+		if errors.Is(err, sentinel.err) {
+			label = sentinel.label
+			break
+		}
+	}
+	m.errors.WithLabelValues(method, label).Inc()
+}
+
+// observeItems adds the revenue and discount of each of an order's lines to
+// the counters of its item's SKU.
+func (m *Metrics) observeItems(lines []ItemWithCost) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, item := range lines {
+		line_total := item.Cost*item.Quantity - item.Discount
+		m.revenue.WithLabelValues(item.SKU).Add(float64(line_total))
+		m.discounts.WithLabelValues(item.SKU).Add(float64(item.Discount))
+		m.skus[item.SKU] = true
+	}
+}
+
+// forgetRemovedItems deletes the revenue and discount counters of the SKUs
+// that are no longer in the catalog. The removed item may have been referred
+// to by name, so the SKUs are compared with the whole catalog rather than
+// the request.
+func (m *Metrics) forgetRemovedItems(ctx context.Context, svc Service) {
+	catalog, err := svc.GetCatalog(ctx)
+	if err != nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	in_catalog := make(map[string]bool, len(catalog.Items))
+	for _, item := range catalog.Items {
+		in_catalog[item.SKU] = true
+	}
+	for sku := range m.skus {
+		if in_catalog[sku] {
+			continue
+		}
+		m.revenue.DeleteLabelValues(sku)
+		m.discounts.DeleteLabelValues(sku)
+		delete(m.skus, sku)
+	}
+}
+
+// instrumentedService is a Service decorator that records metrics for the
+// calls made to the underlying Service. Methods that only fail when the
+// context is cancelled are passed through by the embedded Service.
+type instrumentedService struct {
+	Service
+	metrics *Metrics
+}
+
+func (svc instrumentedService) SimpleSummary(
+	ctx context.Context,
+	req OrderRequest,
+) (OrderSummary, error) {
+	summary, err := svc.Service.SimpleSummary(ctx, req)
+	svc.metrics.observeError("SimpleSummary", err)
+	if err != nil {
+		return summary, err
+	}
+
+	svc.metrics.orders.Inc()
+	svc.metrics.observeItems(summary.Summary)
+
+	return summary, nil
+}
+
+func (svc instrumentedService) GetSingleOrder(
+	ctx context.Context,
+	req GetSingleOrderRequest,
+) (OrderSummary, error) {
+	summary, err := svc.Service.GetSingleOrder(ctx, req)
+	svc.metrics.observeError("GetSingleOrder", err)
+	return summary, err
+}
+
+func (svc instrumentedService) CancelOrder(
+	ctx context.Context,
+	req CancelOrderRequest,
+) (OrderSummary, error) {
+	summary, err := svc.Service.CancelOrder(ctx, req)
+	svc.metrics.observeError("CancelOrder", err)
+	return summary, err
+}
+
+func (svc instrumentedService) GetOrdersInRange(
+	ctx context.Context,
+	req OrdersInRangeRequest,
+) (AllOrders, error) {
+	orders, err := svc.Service.GetOrdersInRange(ctx, req)
+	svc.metrics.observeError("GetOrdersInRange", err)
+	return orders, err
+}
+
+func (svc instrumentedService) Quote(
+	ctx context.Context,
+	req OrderRequest,
+) (OrderSummary, error) {
+	summary, err := svc.Service.Quote(ctx, req)
+	svc.metrics.observeError("Quote", err)
+	return summary, err
+}
+
+func (svc instrumentedService) ExplainPrice(
+	ctx context.Context,
+	req ExplainPriceRequest,
+) (PriceExplanation, error) {
+	explanation, err := svc.Service.ExplainPrice(ctx, req)
+	svc.metrics.observeError("ExplainPrice", err)
+	return explanation, err
+}
+
+func (svc instrumentedService) SetItemPrice(
+	ctx context.Context,
+	req SetItemPriceRequest,
+) (CatalogItem, error) {
+	item, err := svc.Service.SetItemPrice(ctx, req)
+	svc.metrics.observeError("SetItemPrice", err)
+	return item, err
+}
+
+func (svc instrumentedService) GetPriceHistory(
+	ctx context.Context,
+	req PriceHistoryRequest,
+) (PriceHistory, error) {
+	history, err := svc.Service.GetPriceHistory(ctx, req)
+	svc.metrics.observeError("GetPriceHistory", err)
+	return history, err
+}
+
+func (svc instrumentedService) RemoveItem(
+	ctx context.Context,
+	req RemoveItemRequest,
+) error {
+	err := svc.Service.RemoveItem(ctx, req)
+	svc.metrics.observeError("RemoveItem", err)
+	if err == nil {
+		svc.metrics.forgetRemovedItems(ctx, svc.Service)
+	}
+	return err
+}
+
+func (svc instrumentedService) ImportOrders(
+	ctx context.Context,
+	orders []OrderSummary,
+) (ImportOrdersResponse, error) {
+	response, err := svc.Service.ImportOrders(ctx, orders)
+	svc.metrics.observeError("ImportOrders", err)
+	return response, err
+}
@@ -0,0 +1,53 @@
+package aetest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	items, discounts, _ := NewStore()
+	metrics := NewMetrics()
+	svc := metrics.Instrument(New(items, discounts, make(OrderStore)))
+	router := NewOrdersRouter(svc, WithMetrics(metrics))
+
+	// One successful order and one of each of two different errors.
+	postJSON(t, router, "/submit-order", goodOrderRequest)
+	postJSON(t, router, "/submit-order", OrderRequest{
+		Cart: []Item{{ItemName: "Magazine", Quantity: 1}},
+	})
+	postJSON(t, router, "/get-order", GetSingleOrderRequest{uuid.NewV4().String()})
+
+	request := httptest.NewRequest("GET", "/metrics", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, request)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	body := rec.Body.String()
+	for _, expected := range []string{
+		`aetest_http_requests_total{method="POST",route="/submit-order",status="200"} 1`,
+		`aetest_http_requests_total{method="POST",route="/submit-order",status="400"} 1`,
+		`aetest_http_request_duration_seconds_count{method="POST",route="/get-order"} 1`,
+		`aetest_orders_submitted_total 1`,
+		`aetest_item_revenue_total{sku="FRUIT-001"} 60`,
+		`aetest_item_discount_total{sku="FRUIT-002"} 25`,
+		`aetest_errors_total{error="item_does_not_exist",method="SimpleSummary"} 1`,
+		`aetest_errors_total{error="order_not_found",method="GetSingleOrder"} 1`,
+		`aetest_stored_orders 1`,
+		`aetest_catalog_items 2`,
+	} {
+		require.Contains(t, body, expected)
+	}
+
+	// Removing an item, here by name, removes its revenue and discount.
+	postJSON(t, router, "/remove-item", RemoveItemRequest{ItemName: "apples"})
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body = rec.Body.String()
+	require.NotContains(t, body, `sku="FRUIT-001"`)
+	require.Contains(t, body, `aetest_item_revenue_total{sku="FRUIT-002"} 50`)
+}
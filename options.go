@@ -0,0 +1,113 @@
+package aetest
+
+import (
+	"fmt"
+	"time"
+)
+
+// Option configures optional behaviour of the Service created by New.
+type Option func(*orderService)
+
+// Clock returns the current time. It is injected into the Service so that
+// tests can control the timestamps recorded on orders.
+type Clock func() time.Time
+
+// systemClock is the default Clock, times are recorded in UTC.
+func systemClock() time.Time {
+	return time.Now().UTC()
+}
+
+// DefaultMaxCartLines is the largest number of lines accepted in an order's
+// cart unless changed with WithMaxCartLines.
+const DefaultMaxCartLines = 100
+
+// WithMaxCartLines sets the largest number of lines accepted in an order's
+// cart, larger orders are rejected with ErrInvalidRequest. Zero removes the
+// limit.
+func WithMaxCartLines(max int) Option {
+	return func(svc *orderService) {
+		svc.max_cart_lines = max
+	}
+}
+
+// DuplicateLines is how the Service handles an order with more than one cart
+// line for the same item.
+type DuplicateLines string
+
+const (
+	// MergeDuplicateLines prices all the lines of an item together, so the
+	// item's discount applies to the total quantity ordered. The lines are
+	// kept as submitted in the OrderSummary, each with its share of the
+	// discount. This is the default.
+	MergeDuplicateLines DuplicateLines = "merge"
+
+	// RejectDuplicateLines rejects the order with ErrInvalidRequest.
+	RejectDuplicateLines DuplicateLines = "reject"
+)
+
+// ParseDuplicateLines returns the DuplicateLines policy with the given name,
+// either "merge" or "reject".
+func ParseDuplicateLines(name string) (DuplicateLines, error) {
+	switch policy := DuplicateLines(name); policy {
+	case MergeDuplicateLines, RejectDuplicateLines:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown duplicate lines policy %q, expected merge or reject", name)
+}
+
+// WithDuplicateLines sets how orders with several cart lines for the same item
+// are handled.
+func WithDuplicateLines(policy DuplicateLines) Option {
+	return func(svc *orderService) {
+		svc.duplicate_lines = policy
+	}
+}
+
+// DiscountPolicy is how the Service chooses between the offers on an item when
+// more than one applies, its ItemDiscount and any active promotions. An offer
+// is either stackable, combining with the other stackable offers, or given
+// only on its own. Exclusive promotions are given on their own across the
+// whole order, whichever policy is used.
+type DiscountPolicy string
+
+const (
+	// DiscountBestForCustomer gives the largest discount, either the best
+	// single offer or all the stackable offers together. This is the
+	// default.
+	DiscountBestForCustomer DiscountPolicy = "best-for-customer"
+
+	// DiscountFirstMatch gives the offer with the highest priority, along
+	// with the other stackable offers if it is stackable.
+	DiscountFirstMatch DiscountPolicy = "first-match"
+
+	// DiscountAllStackable treats every offer as stackable, giving them all
+	// together.
+	DiscountAllStackable DiscountPolicy = "all-stackable"
+)
+
+// ParseDiscountPolicy returns the DiscountPolicy with the given name, either
+// "best-for-customer", "first-match" or "all-stackable".
+func ParseDiscountPolicy(name string) (DiscountPolicy, error) {
+	switch policy := DiscountPolicy(name); policy {
+	case DiscountBestForCustomer, DiscountFirstMatch, DiscountAllStackable:
+		return policy, nil
+	}
+	return "", fmt.Errorf(
+		"unknown discount policy %q, expected best-for-customer, first-match or all-stackable", name,
+	)
+}
+
+// WithDiscountPolicy sets how the Service chooses between the offers on an
+// item.
+func WithDiscountPolicy(policy DiscountPolicy) Option {
+	return func(svc *orderService) {
+		svc.discount_policy = policy
+	}
+}
+
+// WithClock sets the Clock used to timestamp orders.
+func WithClock(clock Clock) Option {
+	return func(svc *orderService) {
+		svc.now = clock
+	}
+}
@@ -0,0 +1,213 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: orders.proto
+
+package orderspb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Orders_SimpleSummary_FullMethodName  = "/aetest.orders.v1.Orders/SimpleSummary"
+	Orders_GetSingleOrder_FullMethodName = "/aetest.orders.v1.Orders/GetSingleOrder"
+	Orders_GetAllOrders_FullMethodName   = "/aetest.orders.v1.Orders/GetAllOrders"
+)
+
+// OrdersClient is the client API for Orders service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Orders exposes the order Service over gRPC. The messages mirror the JSON
+// types served by the HTTP API.
+type OrdersClient interface {
+	// SimpleSummary creates an OrderSummary from a submitted order request.
+	SimpleSummary(ctx context.Context, in *OrderRequest, opts ...grpc.CallOption) (*OrderSummary, error)
+	// GetSingleOrder returns a previously stored OrderSummary by its order_id.
+	GetSingleOrder(ctx context.Context, in *GetSingleOrderRequest, opts ...grpc.CallOption) (*OrderSummary, error)
+	// GetAllOrders streams every stored OrderSummary to the caller.
+	GetAllOrders(ctx context.Context, in *GetAllOrdersRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[OrderSummary], error)
+}
+
+type ordersClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewOrdersClient(cc grpc.ClientConnInterface) OrdersClient {
+	return &ordersClient{cc}
+}
+
+func (c *ordersClient) SimpleSummary(ctx context.Context, in *OrderRequest, opts ...grpc.CallOption) (*OrderSummary, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(OrderSummary)
+	err := c.cc.Invoke(ctx, Orders_SimpleSummary_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ordersClient) GetSingleOrder(ctx context.Context, in *GetSingleOrderRequest, opts ...grpc.CallOption) (*OrderSummary, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(OrderSummary)
+	err := c.cc.Invoke(ctx, Orders_GetSingleOrder_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ordersClient) GetAllOrders(ctx context.Context, in *GetAllOrdersRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[OrderSummary], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Orders_ServiceDesc.Streams[0], Orders_GetAllOrders_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[GetAllOrdersRequest, OrderSummary]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Orders_GetAllOrdersClient = grpc.ServerStreamingClient[OrderSummary]
+
+// OrdersServer is the server API for Orders service.
+// All implementations must embed UnimplementedOrdersServer
+// for forward compatibility.
+//
+// Orders exposes the order Service over gRPC. The messages mirror the JSON
+// types served by the HTTP API.
+type OrdersServer interface {
+	// SimpleSummary creates an OrderSummary from a submitted order request.
+	SimpleSummary(context.Context, *OrderRequest) (*OrderSummary, error)
+	// GetSingleOrder returns a previously stored OrderSummary by its order_id.
+	GetSingleOrder(context.Context, *GetSingleOrderRequest) (*OrderSummary, error)
+	// GetAllOrders streams every stored OrderSummary to the caller.
+	GetAllOrders(*GetAllOrdersRequest, grpc.ServerStreamingServer[OrderSummary]) error
+	mustEmbedUnimplementedOrdersServer()
+}
+
+// UnimplementedOrdersServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedOrdersServer struct{}
+
+func (UnimplementedOrdersServer) SimpleSummary(context.Context, *OrderRequest) (*OrderSummary, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SimpleSummary not implemented")
+}
+func (UnimplementedOrdersServer) GetSingleOrder(context.Context, *GetSingleOrderRequest) (*OrderSummary, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSingleOrder not implemented")
+}
+func (UnimplementedOrdersServer) GetAllOrders(*GetAllOrdersRequest, grpc.ServerStreamingServer[OrderSummary]) error {
+	return status.Errorf(codes.Unimplemented, "method GetAllOrders not implemented")
+}
+func (UnimplementedOrdersServer) mustEmbedUnimplementedOrdersServer() {}
+func (UnimplementedOrdersServer) testEmbeddedByValue()                {}
+
+// UnsafeOrdersServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to OrdersServer will
+// result in compilation errors.
+type UnsafeOrdersServer interface {
+	mustEmbedUnimplementedOrdersServer()
+}
+
+func RegisterOrdersServer(s grpc.ServiceRegistrar, srv OrdersServer) {
+	// If the following call pancis, it indicates UnimplementedOrdersServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Orders_ServiceDesc, srv)
+}
+
+func _Orders_SimpleSummary_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(OrderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrdersServer).SimpleSummary(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Orders_SimpleSummary_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrdersServer).SimpleSummary(ctx, req.(*OrderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Orders_GetSingleOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetSingleOrderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrdersServer).GetSingleOrder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Orders_GetSingleOrder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrdersServer).GetSingleOrder(ctx, req.(*GetSingleOrderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Orders_GetAllOrders_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetAllOrdersRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(OrdersServer).GetAllOrders(m, &grpc.GenericServerStream[GetAllOrdersRequest, OrderSummary]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Orders_GetAllOrdersServer = grpc.ServerStreamingServer[OrderSummary]
+
+// Orders_ServiceDesc is the grpc.ServiceDesc for Orders service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Orders_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "aetest.orders.v1.Orders",
+	HandlerType: (*OrdersServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "SimpleSummary",
+			Handler:    _Orders_SimpleSummary_Handler,
+		},
+		{
+			MethodName: "GetSingleOrder",
+			Handler:    _Orders_GetSingleOrder_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "GetAllOrders",
+			Handler:       _Orders_GetAllOrders_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "orders.proto",
+}
@@ -0,0 +1,624 @@
+package aetest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// The types of the domain events recorded in the Outbox.
+const (
+	// EventOrderCreated is recorded when an order is submitted.
+	EventOrderCreated = "order.created"
+
+	// EventOrderCancelled is recorded when an order is cancelled.
+	EventOrderCancelled = "order.cancelled"
+
+	// EventOrderImported is recorded for every order imported, including
+	// those restored from an export.
+	EventOrderImported = "order.imported"
+
+	// EventPriceChanged is recorded when an item is added to the catalog or
+	// its cost is changed.
+	EventPriceChanged = "price.changed"
+
+	// EventItemRemoved is recorded when an item is removed from the catalog,
+	// the event's item has the cost it had when it was removed.
+	EventItemRemoved = "item.removed"
+)
+
+var (
+	// ErrEventNotRecorded is returned when the event describing a change
+	// could not be recorded in the outbox, the change is not made.
+	ErrEventNotRecorded = errors.New("event could not be recorded")
+
+	// ErrOutboxClosed is returned when an Outbox is used after it has been
+	// closed.
+	ErrOutboxClosed = errors.New("outbox closed")
+)
+
+// newEvent creates an Event of the given type with a unique ID.
+func newEvent(event_type string, occurred_at time.Time) Event {
+	return Event{
+		ID:         uuid.NewV4().String(),
+		Type:       event_type,
+		OccurredAt: occurred_at,
+	}
+}
+
+// orderEvent creates an Event for a change to an order, the event occurred
+// when the order was last updated.
+func orderEvent(event_type string, order OrderSummary) Event {
+	event := newEvent(event_type, order.UpdatedAt)
+	event.Order = &order
+	return event
+}
+
+// Outbox holds the events recorded by the Service until every Relay reading
+// it has published them. Events are recorded in the same critical section as
+// the change they describe, before it is applied, and the change is abandoned
+// if its event cannot be recorded, so no change is made without its event.
+// Each relay publishes the events in the order they were recorded.
+//
+// An Outbox created by NewOutbox holds its events in memory. One opened by
+// OpenOutbox appends them to a file, syncing each event to disk before its
+// change is made, so that no event is lost if the process stops. The stores
+// are not written with their events, a change held only in memory is lost
+// if the process stops while its event is still published afterwards. The
+// position of each relay is kept in a second file, saved whenever the relay
+// has published some events, and the events file is emptied once every relay
+// has published all of it.
+//
+// Publishing is at least once, a relay publishes again the events after its
+// last saved position when the process restarts. Consumers should use the
+// event ID to ignore duplicates.
+type Outbox struct {
+	mu  sync.Mutex
+	log outboxLog
+	end int64
+
+	// cursors are the offsets in the log of the next event each relay
+	// publishes, by relay name. saved are the cursors read from the cursor
+	// file, a relay created with one of their names starts from it.
+	cursors     map[string]int64
+	saved       map[string]int64
+	cursor_path string
+
+	// ready is signalled for every relay when events are added, each channel
+	// is buffered so that recording an event never blocks.
+	ready map[string]chan struct{}
+}
+
+// outboxLog stores an Outbox's events as JSON lines.
+type outboxLog interface {
+	io.ReaderAt
+
+	// append writes the lines at offset end, the end of the log, returning
+	// once they are stored. Lines that fail to be stored are never read.
+	append(end int64, lines []byte) error
+
+	// truncate removes every event from the log.
+	truncate() error
+
+	Close() error
+}
+
+// memoryLog is the outboxLog of an Outbox created by NewOutbox.
+type memoryLog struct {
+	data []byte
+}
+
+func (l *memoryLog) ReadAt(p []byte, off int64) (int, error) {
+	return bytes.NewReader(l.data).ReadAt(p, off)
+}
+
+func (l *memoryLog) append(end int64, lines []byte) error {
+	l.data = append(l.data, lines...)
+	return nil
+}
+
+func (l *memoryLog) truncate() error {
+	l.data = nil
+	return nil
+}
+
+func (l *memoryLog) Close() error {
+	return nil
+}
+
+// fileLog is the outboxLog of an Outbox opened by OpenOutbox.
+type fileLog struct {
+	*os.File
+}
+
+// append writes and syncs the lines, lines that could not be written or
+// synced are cut off again so that a later event is not appended to them.
+func (l fileLog) append(end int64, lines []byte) error {
+	_, err := l.WriteAt(lines, end)
+	if err == nil {
+		err = l.Sync()
+	}
+	if err != nil {
+		l.Truncate(end)
+		return err
+	}
+	return nil
+}
+
+func (l fileLog) truncate() error {
+	if err := l.Truncate(0); err != nil {
+		return err
+	}
+	return l.Sync()
+}
+
+// NewOutbox creates an empty Outbox holding its events in memory, they are
+// lost when the process stops.
+func NewOutbox() *Outbox {
+	return &Outbox{
+		log:     &memoryLog{},
+		cursors: make(map[string]int64),
+		saved:   make(map[string]int64),
+		ready:   make(map[string]chan struct{}),
+	}
+}
+
+// OpenOutbox opens the Outbox whose events are kept in the file at path,
+// creating it if it does not exist. The relays' positions are kept in the
+// file at path with ".cursors" appended. An event only partly written when
+// the process stopped is discarded, its change was never made.
+func OpenOutbox(path string) (*Outbox, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	end, err := lastLineEnd(file)
+	if err == nil {
+		err = file.Truncate(end)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	outbox := NewOutbox()
+	outbox.log = fileLog{file}
+	outbox.end = end
+	outbox.cursor_path = path + ".cursors"
+
+	data, err := os.ReadFile(outbox.cursor_path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		file.Close()
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &outbox.saved); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("reading %s: %w", outbox.cursor_path, err)
+		}
+	}
+	for name, cursor := range outbox.saved {
+		outbox.saved[name] = min(cursor, end)
+	}
+
+	return outbox, nil
+}
+
+// lastLineEnd returns the offset just after the last newline in the file,
+// or 0 if it has none.
+func lastLineEnd(file *os.File) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	chunk := make([]byte, 4096)
+	for end := info.Size(); end > 0; {
+		start := max(end-int64(len(chunk)), 0)
+		n, err := file.ReadAt(chunk[:end-start], start)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(chunk[:n], '\n'); i >= 0 {
+			return start + int64(i) + 1, nil
+		}
+		end = start
+	}
+	return 0, nil
+}
+
+// WithOutbox records an Event in the outbox for every change to the orders
+// or the catalog. No events are recorded by default.
+func WithOutbox(outbox *Outbox) Option {
+	return func(svc *orderService) {
+		svc.outbox = outbox
+	}
+}
+
+// record adds the events to the Service' outbox, if it has one. The caller
+// must hold svc.mu and only make the change once the events are recorded.
+func (svc orderService) record(events ...Event) error {
+	if svc.outbox == nil {
+		return nil
+	}
+	return svc.outbox.add(events...)
+}
+
+func (o *Outbox) add(events ...Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	var lines []byte
+	for _, event := range events {
+		line, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.log == nil {
+		return fmt.Errorf("%w: %w", ErrEventNotRecorded, ErrOutboxClosed)
+	}
+	if err := o.log.append(o.end, lines); err != nil {
+		return fmt.Errorf("%w: %v", ErrEventNotRecorded, err)
+	}
+	o.end += int64(len(lines))
+
+	for _, ready := range o.ready {
+		select {
+		case ready <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Pending returns the events that have not yet been published by every
+// relay, oldest first.
+func (o *Outbox) Pending() ([]Event, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.log == nil {
+		return nil, ErrOutboxClosed
+	}
+
+	cursor := o.end
+	for _, relay_cursor := range o.cursors {
+		cursor = min(cursor, relay_cursor)
+	}
+	if len(o.cursors) == 0 {
+		cursor = 0
+	}
+
+	var events []Event
+	reader := bufio.NewReader(io.NewSectionReader(o.log, cursor, o.end-cursor))
+	for {
+		event, _, err := readEvent(reader)
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+}
+
+// readEvent reads the next event from the log, returning its length in
+// bytes.
+func readEvent(reader *bufio.Reader) (Event, int, error) {
+	line, err := reader.ReadBytes('\n')
+	if err == io.EOF && len(line) > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return Event{}, 0, err
+	}
+
+	var event Event
+	if err := json.Unmarshal(line, &event); err != nil {
+		return Event{}, 0, fmt.Errorf("reading outbox: %w", err)
+	}
+	return event, len(line), nil
+}
+
+// register adds a relay reading the outbox, it starts from its saved
+// position or the oldest event kept.
+func (o *Outbox) register(name string) (chan struct{}, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if _, ok := o.cursors[name]; ok {
+		return nil, fmt.Errorf("outbox already has a relay named %q", name)
+	}
+	o.cursors[name] = o.saved[name]
+	ready := make(chan struct{}, 1)
+	o.ready[name] = ready
+
+	// Events recorded before the relay was created are published when it
+	// starts.
+	ready <- struct{}{}
+	return ready, nil
+}
+
+// next returns the oldest event the relay has not published, and the offset
+// of the event after it.
+func (o *Outbox) next(name string) (Event, int64, bool, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.log == nil {
+		return Event{}, 0, false, ErrOutboxClosed
+	}
+
+	cursor := o.cursors[name]
+	if cursor >= o.end {
+		return Event{}, cursor, false, nil
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(o.log, cursor, o.end-cursor))
+	event, n, err := readEvent(reader)
+	if err != nil {
+		return Event{}, 0, false, err
+	}
+	return event, cursor + int64(n), true, nil
+}
+
+// advance moves the relay past a published event.
+func (o *Outbox) advance(name string, cursor int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.cursors[name] = cursor
+}
+
+// checkpoint saves the relays' positions. Once every relay has published
+// every event the log is emptied, the positions are saved first so that if
+// the process stops in between the events are published again rather than
+// the events recorded after them being skipped.
+func (o *Outbox) checkpoint() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.log == nil {
+		return ErrOutboxClosed
+	}
+
+	published := o.end > 0
+	for _, cursor := range o.cursors {
+		published = published && cursor == o.end
+	}
+	if !published {
+		return o.saveCursors()
+	}
+
+	for name := range o.cursors {
+		o.cursors[name] = 0
+	}
+	if err := o.saveCursors(); err != nil {
+		return err
+	}
+	if err := o.log.truncate(); err != nil {
+		return fmt.Errorf("emptying outbox: %w", err)
+	}
+	o.end = 0
+	return nil
+}
+
+// saveCursors writes the relays' positions to the cursor file, the caller
+// holds o.mu. Like SaveOrdersFile a failed save never leaves a partially
+// written file behind.
+func (o *Outbox) saveCursors() error {
+	if o.cursor_path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(o.cursors)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(o.cursor_path), filepath.Base(o.cursor_path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), o.cursor_path)
+}
+
+// Close saves the relays' positions and closes the outbox's file. Events
+// can no longer be recorded or published, the events not yet published are
+// kept in the file.
+func (o *Outbox) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.log == nil {
+		return nil
+	}
+
+	err := o.saveCursors()
+	err = errors.Join(err, o.log.Close())
+	o.log = nil
+	return err
+}
+
+// RelayConfig configures a Relay, zero values use the defaults.
+type RelayConfig struct {
+	// Name identifies the relay's position in the outbox, each relay of an
+	// Outbox must have a different name. Defaults to "events".
+	Name string
+
+	// RetryBackoff is the wait before publishing again after a failure, it
+	// doubles after every consecutive failure up to MaxBackoff. Defaults to
+	// 1s and 1m.
+	RetryBackoff time.Duration
+	MaxBackoff   time.Duration
+
+	// Logger logs failures to publish, nothing is logged by default.
+	Logger *slog.Logger
+}
+
+// Relay publishes the events in an Outbox with an EventPublisher. Events are
+// published one at a time in the order they were recorded, an event that
+// fails to publish is retried, and holds back the events after it, until it
+// is published.
+//
+// An Outbox may have several relays, each publishing every event. An event is
+// dropped from the outbox once the relays created so far have all published
+// it, so every relay should be created before any of them is run.
+type Relay struct {
+	outbox    *Outbox
+	publisher EventPublisher
+	config    RelayConfig
+	ready     chan struct{}
+
+	// with_email is set when the publisher sends the customer's
+	// confirmation, every other publisher is relayed events without the
+	// customer's email address.
+	with_email bool
+
+	// publishing is held while events are published so that Run and Flush
+	// never publish the same event concurrently.
+	publishing sync.Mutex
+}
+
+// NewRelay creates a Relay publishing the events in outbox to publisher. It
+// fails if the outbox already has a relay with the same name.
+func NewRelay(outbox *Outbox, publisher EventPublisher, config RelayConfig) (*Relay, error) {
+	if config.Name == "" {
+		config.Name = "events"
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = time.Second
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = time.Minute
+	}
+	if config.Logger == nil {
+		config.Logger = discardLogger
+	}
+
+	ready, err := outbox.register(config.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	_, with_email := publisher.(confirmationPublisher)
+	return &Relay{
+		outbox:     outbox,
+		publisher:  publisher,
+		config:     config,
+		ready:      ready,
+		with_email: with_email,
+	}, nil
+}
+
+// confirmationPublisher is an EventPublisher that emails the customer, it is
+// the only kind of publisher relayed the Email of order.created events.
+type confirmationPublisher interface {
+	EventPublisher
+	sendsConfirmations()
+}
+
+// Run publishes events as they are recorded until ctx is done. Events still
+// pending when Run returns stay in the outbox.
+func (r *Relay) Run(ctx context.Context) {
+	backoff := r.config.RetryBackoff
+	for {
+		err := r.Flush(ctx)
+		if err != nil && ctx.Err() == nil {
+			r.config.Logger.Warn(
+				"publishing events failed",
+				"relay", r.config.Name,
+				"error", err,
+				"retry_in", backoff,
+			)
+
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+			backoff = min(backoff*2, r.config.MaxBackoff)
+			continue
+		}
+		backoff = r.config.RetryBackoff
+
+		select {
+		case <-r.ready:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Flush publishes every pending event, stopping at the first event that fails
+// to publish or when ctx is done. The relay's position is saved once the
+// events have been published.
+func (r *Relay) Flush(ctx context.Context) error {
+	r.publishing.Lock()
+	defer r.publishing.Unlock()
+
+	published, err := r.publish(ctx)
+	if published > 0 {
+		err = errors.Join(err, r.outbox.checkpoint())
+	}
+	return err
+}
+
+// publish publishes the pending events, returning how many were published.
+func (r *Relay) publish(ctx context.Context) (int, error) {
+	published := 0
+	for {
+		event, next, ok, err := r.outbox.next(r.config.Name)
+		if err != nil || !ok {
+			return published, err
+		}
+		if err := ctx.Err(); err != nil {
+			return published, err
+		}
+
+		if !r.with_email {
+			event.Email = ""
+		}
+		if err := r.publisher.Publish(ctx, event); err != nil {
+			return published, fmt.Errorf("publishing event %s: %w", event.ID, err)
+		}
+		r.outbox.advance(r.config.Name, next)
+		published++
+	}
+}
@@ -0,0 +1,272 @@
+package aetest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutboxRecordsEvents(t *testing.T) {
+	ctx := context.Background()
+	outbox := NewOutbox()
+	items, discounts, orders := NewStore()
+	svc := New(items, discounts, orders, WithOutbox(outbox))
+
+	summary, err := svc.SimpleSummary(ctx, goodOrderRequest)
+	require.NoError(t, err)
+	_, err = svc.CancelOrder(ctx, CancelOrderRequest{summary.OrderID})
+	require.NoError(t, err)
+	_, err = svc.SetItemPrice(ctx, SetItemPriceRequest{SKU: "FRUIT-001", Cost: 65})
+	require.NoError(t, err)
+	require.NoError(t, svc.RemoveItem(ctx, RemoveItemRequest{ItemName: "Oranges"}))
+	exported := summary
+	exported.OrderID = "c1a2b3c4-0000-4000-8000-000000000001"
+	_, err = svc.ImportOrders(ctx, []OrderSummary{exported})
+	require.NoError(t, err)
+
+	// Failed changes and changes that leave the price alone record nothing.
+	_, err = svc.CancelOrder(ctx, CancelOrderRequest{summary.OrderID})
+	require.ErrorIs(t, err, ErrOrderCancelled)
+	_, err = svc.SimpleSummary(ctx, OrderRequest{Cart: []Item{{ItemName: "Pears", Quantity: 1}}})
+	require.Error(t, err)
+	_, err = svc.SetItemPrice(ctx, SetItemPriceRequest{SKU: "FRUIT-001", Aliases: []string{"Apple"}, Cost: 65})
+	require.NoError(t, err)
+
+	_, err = svc.ImportOrders(ctx, []OrderSummary{exported})
+	require.ErrorIs(t, err, ErrOrderExists)
+	require.ErrorIs(t, svc.RemoveItem(ctx, RemoveItemRequest{ItemName: "Oranges"}), ErrItemDoesNotExist)
+
+	pending, err := outbox.Pending()
+	require.NoError(t, err)
+	require.Len(t, pending, 5)
+	require.Equal(t, EventOrderCreated, pending[0].Type)
+	require.Equal(t, OrderPlaced, pending[0].Order.Status)
+	require.Equal(t, EventOrderCancelled, pending[1].Type)
+	require.Equal(t, OrderCancelled, pending[1].Order.Status)
+	require.Equal(t, summary.OrderID, pending[1].Order.OrderID)
+	require.Equal(t, EventPriceChanged, pending[2].Type)
+	require.Equal(t, 65, pending[2].Item.Cost)
+	require.Equal(t, 60, *pending[2].PreviousCost)
+	require.Equal(t, EventItemRemoved, pending[3].Type)
+	require.Equal(t, "FRUIT-002", pending[3].Item.SKU)
+	require.Equal(t, 25, pending[3].Item.Cost)
+	require.Equal(t, EventOrderImported, pending[4].Type)
+	require.Equal(t, exported.OrderID, pending[4].Order.OrderID)
+
+	// Publishing empties the outbox, in the order events were recorded.
+	publisher := NewMemoryPublisher()
+	relay, err := NewRelay(outbox, publisher, RelayConfig{})
+	require.NoError(t, err)
+	require.NoError(t, relay.Flush(ctx))
+	require.Equal(t, pending, publisher.Events())
+	requirePending(t, outbox, 0)
+}
+
+// requirePending checks the number of events the outbox's relays have yet to
+// publish.
+func requirePending(t *testing.T, outbox *Outbox, expected int) {
+	t.Helper()
+	pending, err := outbox.Pending()
+	require.NoError(t, err)
+	require.Len(t, pending, expected)
+}
+
+func TestOutboxFailureAbandonsChange(t *testing.T) {
+	ctx := context.Background()
+	outbox := NewOutbox()
+	items, discounts, orders := NewStore()
+	svc := New(items, discounts, orders, WithOutbox(outbox))
+	summary, err := svc.SimpleSummary(ctx, goodOrderRequest)
+	require.NoError(t, err)
+
+	// Once events cannot be recorded no change is made.
+	require.NoError(t, outbox.Close())
+	_, err = svc.SimpleSummary(ctx, goodOrderRequest)
+	require.ErrorIs(t, err, ErrEventNotRecorded)
+	require.ErrorIs(t, err, ErrOutboxClosed)
+	response := postJSON(t, NewOrdersRouter(svc), "/submit-order", goodOrderRequest)
+	require.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+	_, err = svc.CancelOrder(ctx, CancelOrderRequest{summary.OrderID})
+	require.ErrorIs(t, err, ErrOutboxClosed)
+	_, err = svc.SetItemPrice(ctx, SetItemPriceRequest{SKU: "FRUIT-001", Cost: 65})
+	require.ErrorIs(t, err, ErrOutboxClosed)
+	require.ErrorIs(t, svc.RemoveItem(ctx, RemoveItemRequest{SKU: "FRUIT-001"}), ErrOutboxClosed)
+
+	all_orders, err := svc.GetAllOrders(ctx)
+	require.NoError(t, err)
+	require.Len(t, all_orders.Orders, 1)
+	require.Equal(t, OrderPlaced, all_orders.Orders[0].Status)
+	catalog, err := svc.GetCatalog(ctx)
+	require.NoError(t, err)
+	require.Len(t, catalog.Items, 2)
+	require.Equal(t, 60, catalog.Items[0].Cost)
+}
+
+// flakyPublisher fails to publish until it is fixed.
+type flakyPublisher struct {
+	*MemoryPublisher
+
+	mu       sync.Mutex
+	broken   bool
+	attempts int
+}
+
+func (p *flakyPublisher) Publish(ctx context.Context, event Event) error {
+	p.mu.Lock()
+	p.attempts++
+	broken := p.broken
+	p.mu.Unlock()
+
+	if broken {
+		return errors.New("publisher unavailable")
+	}
+	return p.MemoryPublisher.Publish(ctx, event)
+}
+
+func (p *flakyPublisher) fix() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.broken = false
+}
+
+func TestRelayRetriesUntilPublished(t *testing.T) {
+	outbox := NewOutbox()
+	items, discounts, orders := NewStore()
+	svc := New(items, discounts, orders, WithOutbox(outbox))
+
+	publisher := &flakyPublisher{MemoryPublisher: NewMemoryPublisher(), broken: true}
+	relay, err := NewRelay(outbox, publisher, RelayConfig{
+		RetryBackoff: time.Millisecond,
+		MaxBackoff:   5 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		relay.Run(ctx)
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	for i := 0; i < 3; i++ {
+		_, err := svc.SimpleSummary(context.Background(), goodOrderRequest)
+		require.NoError(t, err)
+	}
+
+	// Nothing is lost while the publisher is unavailable.
+	require.Eventually(t, func() bool {
+		publisher.mu.Lock()
+		defer publisher.mu.Unlock()
+		return publisher.attempts > 2
+	}, time.Second, time.Millisecond)
+	requirePending(t, outbox, 3)
+	require.Empty(t, publisher.Events())
+
+	publisher.fix()
+	require.Eventually(t, func() bool { return len(publisher.Events()) == 3 }, time.Second, time.Millisecond)
+	requirePending(t, outbox, 0)
+
+	// Events recorded later are published as they are recorded.
+	_, err = svc.SimpleSummary(context.Background(), goodOrderRequest)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return len(publisher.Events()) == 4 }, time.Second, time.Millisecond)
+}
+
+func TestOpenOutbox(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+	outbox, err := OpenOutbox(path)
+	require.NoError(t, err)
+
+	items, discounts, orders := NewStore()
+	svc := New(items, discounts, orders, WithOutbox(outbox))
+	for i := 0; i < 3; i++ {
+		_, err := svc.SimpleSummary(ctx, goodOrderRequest)
+		require.NoError(t, err)
+	}
+	recorded, err := outbox.Pending()
+	require.NoError(t, err)
+
+	// The process stops part way through writing an event and before
+	// anything is published, the events are published on the next start.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"id": "torn`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	outbox, err = OpenOutbox(path)
+	require.NoError(t, err)
+	publisher := NewMemoryPublisher()
+	relay, err := NewRelay(outbox, publisher, RelayConfig{})
+	require.NoError(t, err)
+	require.NoError(t, relay.Flush(ctx))
+	require.Equal(t, recorded, publisher.Events())
+
+	// Once published the events are not published again.
+	require.NoError(t, outbox.Close())
+	outbox, err = OpenOutbox(path)
+	require.NoError(t, err)
+	relay, err = NewRelay(outbox, publisher, RelayConfig{})
+	require.NoError(t, err)
+	require.NoError(t, relay.Flush(ctx))
+	require.Len(t, publisher.Events(), 3)
+	require.NoError(t, outbox.Close())
+}
+
+func TestOutboxRelays(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+	outbox, err := OpenOutbox(path)
+	require.NoError(t, err)
+
+	first, second := NewMemoryPublisher(), &flakyPublisher{MemoryPublisher: NewMemoryPublisher(), broken: true}
+	first_relay, err := NewRelay(outbox, first, RelayConfig{Name: "first"})
+	require.NoError(t, err)
+	second_relay, err := NewRelay(outbox, second, RelayConfig{Name: "second"})
+	require.NoError(t, err)
+	_, err = NewRelay(outbox, first, RelayConfig{Name: "first"})
+	require.Error(t, err)
+
+	items, discounts, orders := NewStore()
+	svc := New(items, discounts, orders, WithOutbox(outbox))
+	_, err = svc.SimpleSummary(ctx, goodOrderRequest)
+	require.NoError(t, err)
+
+	// Each relay publishes every event, the file is only emptied once both
+	// have.
+	require.NoError(t, first_relay.Flush(ctx))
+	require.Error(t, second_relay.Flush(ctx))
+	require.Len(t, first.Events(), 1)
+	requirePending(t, outbox, 1)
+
+	// The second relay resumes from its own position after a restart.
+	require.NoError(t, outbox.Close())
+	outbox, err = OpenOutbox(path)
+	require.NoError(t, err)
+	first_relay, err = NewRelay(outbox, first, RelayConfig{Name: "first"})
+	require.NoError(t, err)
+	second.fix()
+	second_relay, err = NewRelay(outbox, second, RelayConfig{Name: "second"})
+	require.NoError(t, err)
+	require.NoError(t, first_relay.Flush(ctx))
+	require.NoError(t, second_relay.Flush(ctx))
+	require.Len(t, first.Events(), 1)
+	require.Equal(t, first.Events(), second.Events())
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Zero(t, info.Size())
+	require.NoError(t, outbox.Close())
+}
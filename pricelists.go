@@ -0,0 +1,195 @@
+package aetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DefaultPriceList is the name of the catalog's own prices, those held in the
+// ItemStore. Orders that select no other price list are priced from it, and
+// every price list falls back to it.
+const DefaultPriceList = "default"
+
+// PriceList is a named set of item costs that replace the catalog's costs for
+// some customers, e.g. "wholesale" prices for trade customers or "pos" prices
+// for orders taken at the tills.
+//
+// An order is priced from the first price list for its customer group, or if
+// there is none the first price list for its channel. Items that are not in
+// the price list are priced from its Fallback list, and so on, ending with
+// the catalog. Price lists only change costs, an item must still be in the
+// catalog, and currently priced there, to be ordered.
+type PriceList struct {
+	Name string `json:"name"`
+
+	// Fallback is the price list consulted for items this list has no
+	// cost for, the catalog when empty.
+	Fallback string `json:"fallback,omitempty"`
+
+	// CustomerGroups and Channels select the price list for an order, they
+	// are matched ignoring case.
+	CustomerGroups []string `json:"customer_groups,omitempty"`
+	Channels       []string `json:"channels,omitempty"`
+
+	// Prices is the cost of each item by SKU.
+	Prices map[string]int `json:"prices"`
+}
+
+// WithPriceLists adds price lists to the Service. Price lists should be
+// checked with ValidatePriceLists first, a price list whose fallback chain
+// loops back on itself falls back to the catalog.
+func WithPriceLists(price_lists ...PriceList) Option {
+	return func(svc *orderService) {
+		for _, price_list := range price_lists {
+			// SKUs are stored in upper case, normalize the list's SKUs
+			// without changing the caller's map.
+			prices := make(map[string]int, len(price_list.Prices))
+			for sku, cost := range price_list.Prices {
+				prices[normalizeSKU(sku)] = cost
+			}
+			price_list.Prices = prices
+			svc.price_lists = append(svc.price_lists, price_list)
+		}
+	}
+}
+
+// selectPriceList returns the name of the price list an order is priced
+// from. The customer group takes precedence over the channel, orders matching
+// no price list are priced from the catalog.
+func (svc orderService) selectPriceList(req OrderRequest) string {
+	if req.CustomerGroup != "" {
+		for _, price_list := range svc.price_lists {
+			if containsFold(price_list.CustomerGroups, req.CustomerGroup) {
+				return price_list.Name
+			}
+		}
+	}
+	if req.Channel != "" {
+		for _, price_list := range svc.price_lists {
+			if containsFold(price_list.Channels, req.Channel) {
+				return price_list.Name
+			}
+		}
+	}
+	return DefaultPriceList
+}
+
+// listCost returns the cost of an item from the named price list, following
+// its fallback chain, and the name of the list the cost was found in. It
+// returns false when no list in the chain has a cost for the item, the item is
+// then priced from the catalog.
+func (svc orderService) listCost(name string, sku string) (int, string, bool) {
+	// Each list is visited at most once so that a chain looping back on
+	// itself ends.
+	visited := map[string]bool{}
+	for name != "" && name != DefaultPriceList && !visited[name] {
+		visited[name] = true
+		price_list, ok := svc.priceList(name)
+		if !ok {
+			break
+		}
+		if cost, ok := price_list.Prices[sku]; ok {
+			return cost, price_list.Name, true
+		}
+		name = price_list.Fallback
+	}
+	return 0, "", false
+}
+
+// priceList returns the first price list with the name.
+func (svc orderService) priceList(name string) (PriceList, bool) {
+	for _, price_list := range svc.price_lists {
+		if price_list.Name == name {
+			return price_list, true
+		}
+	}
+	return PriceList{}, false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidatePriceLists checks each price list and that the price lists make
+// sense together. Every fallback must name another price list without the
+// chain looping, and each name, customer group and channel may only be used
+// by one price list.
+func ValidatePriceLists(price_lists []PriceList) error {
+	names := make(map[string]PriceList, len(price_lists))
+	groups := map[string]string{}
+	channels := map[string]string{}
+	for i, price_list := range price_lists {
+		if err := price_list.Validate(); err != nil {
+			return fmt.Errorf("price list %d %q: %w", i, price_list.Name, err)
+		}
+		if _, ok := names[price_list.Name]; ok {
+			return fmt.Errorf("price list %d: duplicate name %q", i, price_list.Name)
+		}
+		names[price_list.Name] = price_list
+
+		for _, selectors := range []struct {
+			kind   string
+			values []string
+			owners map[string]string
+		}{
+			{"customer group", price_list.CustomerGroups, groups},
+			{"channel", price_list.Channels, channels},
+		} {
+			for _, value := range selectors.values {
+				key := strings.ToLower(value)
+				if owner, ok := selectors.owners[key]; ok {
+					return fmt.Errorf(
+						"price list %q: %s %q is already used by price list %q",
+						price_list.Name, selectors.kind, value, owner,
+					)
+				}
+				selectors.owners[key] = price_list.Name
+			}
+		}
+	}
+
+	for _, price_list := range price_lists {
+		chain := []string{price_list.Name}
+		seen := map[string]bool{price_list.Name: true}
+		for name := price_list.Fallback; name != "" && name != DefaultPriceList; name = names[name].Fallback {
+			if _, ok := names[name]; !ok {
+				return fmt.Errorf(
+					"price list %q: unknown fallback %q", price_list.Name, name,
+				)
+			}
+			chain = append(chain, name)
+			if seen[name] {
+				return fmt.Errorf(
+					"price list %q: fallback loops %s",
+					price_list.Name, strings.Join(chain, " -> "),
+				)
+			}
+			seen[name] = true
+		}
+	}
+	return nil
+}
+
+// LoadPriceListsFile reads the JSON array of price lists in the file at path.
+func LoadPriceListsFile(path string) ([]PriceList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var price_lists []PriceList
+	if err := json.Unmarshal(data, &price_lists); err != nil {
+		return nil, fmt.Errorf("reading price lists: %w", err)
+	}
+	if err := ValidatePriceLists(price_lists); err != nil {
+		return nil, err
+	}
+	return price_lists, nil
+}
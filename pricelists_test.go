@@ -0,0 +1,132 @@
+package aetest
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPriceLists(t *testing.T) {
+	ctx := context.Background()
+	items, discounts, orders := NewStore()
+	svc := New(items, discounts, orders, WithPriceLists(
+		PriceList{
+			Name:     "retail",
+			Channels: []string{"web"},
+			Prices:   map[string]int{"fruit-002": 30},
+		},
+		PriceList{
+			Name:           "wholesale",
+			Fallback:       "retail",
+			CustomerGroups: []string{"trade"},
+			Prices:         map[string]int{"FRUIT-001": 40},
+		},
+	))
+
+	quote := func(channel, customer_group string) OrderSummary {
+		request := goodOrderRequest
+		request.Channel = channel
+		request.CustomerGroup = customer_group
+		summary, err := svc.Quote(ctx, request)
+		require.NoError(t, err)
+		return summary
+	}
+	costs := func(summary OrderSummary) map[string][2]interface{} {
+		costs := map[string][2]interface{}{}
+		for _, item := range summary.Summary {
+			costs[item.SKU] = [2]interface{}{item.Cost, item.PriceList}
+		}
+		return costs
+	}
+
+	// Orders selecting no price list are priced from the catalog.
+	summary := quote("", "")
+	require.Equal(t, 110, summary.TotalCost)
+	require.Equal(t, map[string][2]interface{}{
+		"FRUIT-001": {60, DefaultPriceList},
+		"FRUIT-002": {25, DefaultPriceList},
+	}, costs(summary))
+	require.Equal(t, costs(summary), costs(quote("", "nobody")))
+
+	// Trade customers get wholesale apples, and oranges from the retail list
+	// wholesale falls back to. Discounts apply to the list's costs.
+	summary = quote("", "trade")
+	require.Equal(t, 100, summary.TotalCost)
+	require.Equal(t, map[string][2]interface{}{
+		"FRUIT-001": {40, "wholesale"},
+		"FRUIT-002": {30, "retail"},
+	}, costs(summary))
+
+	// Channels and groups match ignoring case, the customer group takes
+	// precedence over the channel.
+	summary = quote("WEB", "")
+	require.Equal(t, 120, summary.TotalCost)
+	require.Equal(t, map[string][2]interface{}{
+		"FRUIT-001": {60, DefaultPriceList},
+		"FRUIT-002": {30, "retail"},
+	}, costs(summary))
+	require.Equal(t, 100, quote("web", "Trade").TotalCost)
+
+	// Stored orders record the price list of each line.
+	request := goodOrderRequest
+	request.CustomerGroup = "trade"
+	placed, err := svc.SimpleSummary(ctx, request)
+	require.NoError(t, err)
+	stored, err := svc.GetSingleOrder(ctx, GetSingleOrderRequest{placed.OrderID})
+	require.NoError(t, err)
+	require.Equal(t, costs(quote("", "trade")), costs(stored))
+}
+
+func TestPriceListsNeedCatalogItems(t *testing.T) {
+	items, discounts, orders := NewStore()
+	svc := New(items, discounts, orders, WithPriceLists(PriceList{
+		Name:           "wholesale",
+		CustomerGroups: []string{"trade"},
+		Prices:         map[string]int{"FRUIT-003": 10},
+	}))
+
+	// A price list cannot add items to the catalog.
+	_, err := svc.Quote(context.Background(), OrderRequest{
+		Cart:          []Item{{SKU: "FRUIT-003", Quantity: 1}},
+		CustomerGroup: "trade",
+	})
+	require.ErrorIs(t, err, ErrItemDoesNotExist)
+}
+
+func TestLoadPriceListsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "price-lists.json")
+	write := func(content string) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+
+	write(`[
+		{"name": "retail", "channels": ["web", "pos"], "prices": {"FRUIT-002": 30}},
+		{"name": "wholesale", "fallback": "retail", "customer_groups": ["trade"], "prices": {"FRUIT-001": 40}}
+	]`)
+	price_lists, err := LoadPriceListsFile(path)
+	require.NoError(t, err)
+	require.Equal(t, []PriceList{
+		{Name: "retail", Channels: []string{"web", "pos"}, Prices: map[string]int{"FRUIT-002": 30}},
+		{Name: "wholesale", Fallback: "retail", CustomerGroups: []string{"trade"}, Prices: map[string]int{"FRUIT-001": 40}},
+	}, price_lists)
+
+	for name, content := range map[string]string{
+		"no name":            `[{"prices": {"FRUIT-001": 40}}]`,
+		"default name":       `[{"name": "default", "prices": {"FRUIT-001": 40}}]`,
+		"negative cost":      `[{"name": "a", "prices": {"FRUIT-001": -1}}]`,
+		"invalid sku":        `[{"name": "a", "prices": {"not a sku": 1}}]`,
+		"duplicate name":     `[{"name": "a"}, {"name": "a"}]`,
+		"unknown fallback":   `[{"name": "a", "fallback": "b"}]`,
+		"fallback to itself": `[{"name": "a", "fallback": "a"}]`,
+		"fallback loop":      `[{"name": "a", "fallback": "b"}, {"name": "b", "fallback": "c"}, {"name": "c", "fallback": "b"}]`,
+		"shared group":       `[{"name": "a", "customer_groups": ["trade"]}, {"name": "b", "customer_groups": ["Trade"]}]`,
+		"shared channel":     `[{"name": "a", "channels": ["pos"]}, {"name": "b", "channels": ["pos"]}]`,
+	} {
+		write(content)
+		_, err := LoadPriceListsFile(path)
+		require.Error(t, err, name)
+	}
+}
@@ -0,0 +1,259 @@
+package aetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Promotion is a discount on one or more items that only applies at certain
+// times, unlike the ItemDiscount of an item which always applies. A promotion
+// applies from Start until End, either of which may be left out, and when
+// Windows are given only within one of them, e.g. a weekday "happy hour"
+// from 17:00 to 19:00. Windows are in the promotion's TimeZone.
+//
+// The discount is PercentOff of the cost of the item's lines, rounded down,
+// or AmountOff the cost of each item. Promotions created in Go may set
+// Discount instead, to calculate any other discount. The discount given on an
+// order is at most MaxOrderDiscount, when it is set.
+//
+// How a promotion combines with the other offers on an item depends on the
+// Service's DiscountPolicy. Stackable promotions may be given together,
+// Priority orders the offers, higher first, and an Exclusive promotion is
+// never given with any other offer in the order.
+type Promotion struct {
+	ID   string   `json:"id"`
+	Name string   `json:"name,omitempty"`
+	SKUs []string `json:"skus"`
+
+	PercentOff       int              `json:"percent_off,omitempty"`
+	AmountOff        int              `json:"amount_off,omitempty"`
+	Discount         DiscountFunction `json:"-"`
+	MaxOrderDiscount int              `json:"max_order_discount,omitempty"`
+
+	Priority  int  `json:"priority,omitempty"`
+	Stackable bool `json:"stackable,omitempty"`
+	Exclusive bool `json:"exclusive,omitempty"`
+
+	Start    *time.Time        `json:"start,omitempty"`
+	End      *time.Time        `json:"end,omitempty"`
+	Windows  []PromotionWindow `json:"windows,omitempty"`
+	TimeZone TimeZone          `json:"time_zone,omitempty"`
+}
+
+// PromotionWindow is a recurring time of day at which a promotion applies,
+// from From until To on each of the Days, or every day when no Days are given.
+// A window whose To is not after its From ends the next day, e.g. from 22:00
+// to 02:00, the Days are those the window starts on.
+type PromotionWindow struct {
+	Days []Weekday `json:"days,omitempty"`
+	From TimeOfDay `json:"from"`
+	To   TimeOfDay `json:"to"`
+}
+
+// TimeOfDay is a wall clock time, in minutes after midnight. It is written
+// as "HH:MM" in JSON.
+type TimeOfDay int
+
+// ParseTimeOfDay parses a 24 hour "HH:MM" time.
+func ParseTimeOfDay(value string) (TimeOfDay, error) {
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", value)
+	}
+	return TimeOfDay(parsed.Hour()*60 + parsed.Minute()), nil
+}
+
+func (t TimeOfDay) String() string {
+	return fmt.Sprintf("%02d:%02d", int(t)/60, int(t)%60)
+}
+
+func (t TimeOfDay) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.String())
+}
+
+func (t *TimeOfDay) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	parsed, err := ParseTimeOfDay(value)
+	if err != nil {
+		return err
+	}
+	*t = parsed
+	return nil
+}
+
+// Weekday is a day of the week, written as its English name in JSON.
+// Unmarshalling also accepts three letter abbreviations and ignores case.
+type Weekday time.Weekday
+
+func (d Weekday) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strings.ToLower(time.Weekday(d).String()))
+}
+
+func (d *Weekday) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		name := strings.ToLower(day.String())
+		if strings.EqualFold(value, name) || strings.EqualFold(value, name[:3]) {
+			*d = Weekday(day)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid day of the week %q", value)
+}
+
+// TimeZone is the location in which promotion windows are evaluated, written
+// as its IANA name in JSON, e.g. "Europe/London". The zero TimeZone is UTC.
+type TimeZone struct {
+	*time.Location
+}
+
+func (z TimeZone) location() *time.Location {
+	if z.Location == nil {
+		return time.UTC
+	}
+	return z.Location
+}
+
+func (z TimeZone) MarshalJSON() ([]byte, error) {
+	return json.Marshal(z.location().String())
+}
+
+func (z *TimeZone) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return fmt.Errorf("invalid time zone %q: %w", name, err)
+	}
+	z.Location = location
+	return nil
+}
+
+// ActiveAt reports whether the promotion applies at the given time.
+func (p Promotion) ActiveAt(at time.Time) bool {
+	if p.Start != nil && at.Before(*p.Start) {
+		return false
+	}
+	if p.End != nil && !at.Before(*p.End) {
+		return false
+	}
+	if len(p.Windows) == 0 {
+		return true
+	}
+
+	local := at.In(p.TimeZone.location())
+	for _, window := range p.Windows {
+		if window.contains(local) {
+			return true
+		}
+	}
+	return false
+}
+
+// contains reports whether the local time is within the window.
+func (w PromotionWindow) contains(local time.Time) bool {
+	now := TimeOfDay(local.Hour()*60 + local.Minute())
+	day := local.Weekday()
+	if w.From < w.To {
+		return w.onDay(day) && now >= w.From && now < w.To
+	}
+
+	// The window ends the day after it starts, early times belong to the
+	// window that started the day before.
+	if now >= w.From {
+		return w.onDay(day)
+	}
+	return now < w.To && w.onDay((day+6)%7)
+}
+
+func (w PromotionWindow) onDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if time.Weekday(d) == day {
+			return true
+		}
+	}
+	return false
+}
+
+// appliesTo reports whether the promotion is for the item.
+func (p Promotion) appliesTo(sku string) bool {
+	for _, promoted := range p.SKUs {
+		if normalizeSKU(promoted) == sku {
+			return true
+		}
+	}
+	return false
+}
+
+// discount returns the promotion's discount on quantity items of the given
+// cost, the cost of the lines must not overflow. The discount is never more
+// than the cost of the lines.
+func (p Promotion) discount(cost int, quantity int) int {
+	line_cost := cost * quantity
+	switch {
+	case p.Discount != nil:
+		return min(p.Discount(cost, quantity), line_cost)
+	case p.PercentOff > 0:
+		// Split so that large line costs cannot overflow.
+		return line_cost/100*p.PercentOff + line_cost%100*p.PercentOff/100
+	default:
+		return min(p.AmountOff, cost) * quantity
+	}
+}
+
+// WithPromotions adds promotions to the Service. When an item has more than
+// one discount at the time of an order, its ItemDiscount and any active
+// promotions, those given are chosen by the Service's DiscountPolicy.
+// Promotions should be checked with ValidatePromotions first.
+func WithPromotions(promotions ...Promotion) Option {
+	return func(svc *orderService) {
+		svc.promotions = append(svc.promotions, promotions...)
+	}
+}
+
+// ValidatePromotions checks each promotion and that no two promotions have
+// the same ID.
+func ValidatePromotions(promotions []Promotion) error {
+	seen := make(map[string]bool, len(promotions))
+	for i, promotion := range promotions {
+		if err := promotion.Validate(); err != nil {
+			return fmt.Errorf("promotion %d %q: %w", i, promotion.ID, err)
+		}
+		if seen[promotion.ID] {
+			return fmt.Errorf("promotion %d: duplicate id %q", i, promotion.ID)
+		}
+		seen[promotion.ID] = true
+	}
+	return nil
+}
+
+// LoadPromotionsFile reads the JSON array of promotions in the file at path.
+func LoadPromotionsFile(path string) ([]Promotion, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var promotions []Promotion
+	if err := json.Unmarshal(data, &promotions); err != nil {
+		return nil, fmt.Errorf("reading promotions: %w", err)
+	}
+	if err := ValidatePromotions(promotions); err != nil {
+		return nil, err
+	}
+	return promotions, nil
+}
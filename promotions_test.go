@@ -0,0 +1,164 @@
+package aetest
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPromotionWindows(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	require.NoError(t, err)
+	start := time.Date(2022, time.March, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2022, time.April, 1, 0, 0, 0, 0, time.UTC)
+
+	// Weekday happy hour in London during March, and late nights at the
+	// weekend.
+	promotion := Promotion{
+		ID:         "happy-hour",
+		SKUs:       []string{"FRUIT-002"},
+		PercentOff: 50,
+		Start:      &start,
+		End:        &end,
+		TimeZone:   TimeZone{london},
+		Windows: []PromotionWindow{
+			{
+				Days: []Weekday{Weekday(time.Monday), Weekday(time.Tuesday), Weekday(time.Wednesday), Weekday(time.Thursday), Weekday(time.Friday)},
+				From: 17 * 60,
+				To:   19 * 60,
+			},
+			{
+				Days: []Weekday{Weekday(time.Saturday)},
+				From: 22 * 60,
+				To:   2 * 60,
+			},
+		},
+	}
+	require.NoError(t, promotion.Validate())
+
+	for _, test := range []struct {
+		name   string
+		at     time.Time
+		active bool
+	}{
+		{"weekday happy hour", time.Date(2022, time.March, 1, 17, 0, 0, 0, london), true},
+		{"end of happy hour", time.Date(2022, time.March, 1, 19, 0, 0, 0, london), false},
+		{"before happy hour", time.Date(2022, time.March, 1, 16, 59, 0, 0, london), false},
+		{"sunday afternoon", time.Date(2022, time.March, 6, 17, 30, 0, 0, london), false},
+		{"saturday night", time.Date(2022, time.March, 5, 23, 0, 0, 0, london), true},
+		{"after midnight on saturday", time.Date(2022, time.March, 6, 1, 59, 0, 0, london), true},
+		{"after midnight on friday", time.Date(2022, time.March, 5, 1, 0, 0, 0, london), false},
+		// The clocks go forward on the 27th, happy hour is 16:00 UTC.
+		{"summer time", time.Date(2022, time.March, 28, 16, 30, 0, 0, time.UTC), true},
+		{"summer time in UTC", time.Date(2022, time.March, 28, 18, 30, 0, 0, time.UTC), false},
+		{"before the start", time.Date(2022, time.February, 28, 17, 30, 0, 0, london), false},
+		{"after the end", time.Date(2022, time.April, 1, 17, 30, 0, 0, london), false},
+	} {
+		require.Equal(t, test.active, promotion.ActiveAt(test.at), test.name)
+	}
+}
+
+func TestPromotionsArePricedAtOrderTime(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	start := clock.Now().Add(time.Hour)
+	end := start.Add(time.Hour)
+	items, discounts, orders := NewStore()
+	svc := New(items, discounts, orders, WithClock(clock.Now), WithPromotions(
+		Promotion{ID: "oranges", SKUs: []string{"fruit-002"}, PercentOff: 50, Start: &start, End: &end},
+		Promotion{ID: "apples", SKUs: []string{"FRUIT-001"}, AmountOff: 10, Start: &start, End: &end},
+	))
+	cart := OrderRequest{Cart: []Item{
+		{ItemName: "Apples", Quantity: 2},
+		{ItemName: "Oranges", Quantity: 1},
+	}}
+
+	summary, err := svc.Quote(ctx, cart)
+	require.NoError(t, err)
+	require.Equal(t, 85, summary.TotalCost)
+
+	// Half price oranges, apples are still buy one get one free as that is
+	// the larger discount.
+	clock.Advance(time.Hour)
+	summary, err = svc.SimpleSummary(ctx, cart)
+	require.NoError(t, err)
+	require.Equal(t, 73, summary.TotalCost)
+	require.Equal(t, 60, summary.Summary[0].Discount)
+	require.Equal(t, 12, summary.Summary[1].Discount)
+
+	clock.Advance(time.Hour)
+	summary, err = svc.Quote(ctx, cart)
+	require.NoError(t, err)
+	require.Equal(t, 85, summary.TotalCost)
+}
+
+func TestPromotionDiscount(t *testing.T) {
+	require.Equal(t, 37, Promotion{PercentOff: 25}.discount(50, 3))
+	require.Equal(t, 30, Promotion{AmountOff: 10}.discount(50, 3))
+
+	// Discounts never exceed the cost of the lines.
+	require.Equal(t, 150, Promotion{AmountOff: 60}.discount(50, 3))
+	custom := Promotion{Discount: func(cost int, quantity int) int { return 1000 }}
+	require.Equal(t, 150, custom.discount(50, 3))
+}
+
+func TestLoadPromotionsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "promotions.json")
+	write := func(content string) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+
+	write(`[{
+		"id": "happy-hour",
+		"skus": ["FRUIT-002"],
+		"percent_off": 20,
+		"time_zone": "America/New_York",
+		"windows": [{"days": ["mon", "Friday"], "from": "17:00", "to": "19:30"}]
+	}]`)
+	promotions, err := LoadPromotionsFile(path)
+	require.NoError(t, err)
+	require.Len(t, promotions, 1)
+	require.Equal(t, "America/New_York", promotions[0].TimeZone.String())
+	require.Equal(t, []PromotionWindow{{
+		Days: []Weekday{Weekday(time.Monday), Weekday(time.Friday)},
+		From: 17 * 60,
+		To:   19*60 + 30,
+	}}, promotions[0].Windows)
+
+	for name, content := range map[string]string{
+		"unknown time zone": `[{"id": "a", "skus": ["FRUIT-002"], "percent_off": 20, "time_zone": "Mars/Olympus"}]`,
+		"invalid time":      `[{"id": "a", "skus": ["FRUIT-002"], "percent_off": 20, "windows": [{"from": "25:00", "to": "26:00"}]}]`,
+		"invalid day":       `[{"id": "a", "skus": ["FRUIT-002"], "percent_off": 20, "windows": [{"days": ["someday"], "from": "17:00", "to": "18:00"}]}]`,
+		"empty window":      `[{"id": "a", "skus": ["FRUIT-002"], "percent_off": 20, "windows": [{"from": "17:00", "to": "17:00"}]}]`,
+		"no discount":       `[{"id": "a", "skus": ["FRUIT-002"]}]`,
+		"two discounts":     `[{"id": "a", "skus": ["FRUIT-002"], "percent_off": 20, "amount_off": 5}]`,
+		"over 100 percent":  `[{"id": "a", "skus": ["FRUIT-002"], "percent_off": 120}]`,
+		"no items":          `[{"id": "a", "percent_off": 20}]`,
+		"ends before start": `[{"id": "a", "skus": ["FRUIT-002"], "percent_off": 20, "start": "2022-03-02T00:00:00Z", "end": "2022-03-01T00:00:00Z"}]`,
+		"exclusive stacks":  `[{"id": "a", "skus": ["FRUIT-002"], "percent_off": 20, "exclusive": true, "stackable": true}]`,
+		"negative cap":      `[{"id": "a", "skus": ["FRUIT-002"], "percent_off": 20, "max_order_discount": -1}]`,
+		"duplicate id":      `[{"id": "a", "skus": ["FRUIT-002"], "percent_off": 20}, {"id": "a", "skus": ["FRUIT-001"], "percent_off": 20}]`,
+	} {
+		write(content)
+		_, err := LoadPromotionsFile(path)
+		require.Error(t, err, name)
+	}
+}
+
+func TestValidatePromotions(t *testing.T) {
+	valid := Promotion{ID: "a", SKUs: []string{"FRUIT-002"}, PercentOff: 20}
+	require.NoError(t, ValidatePromotions([]Promotion{valid}))
+
+	// Discount counts as a kind of discount, given alongside another it is
+	// rejected like percent_off and amount_off together.
+	both := valid
+	both.Discount = func(cost int, quantity int) int { return 1 }
+	require.ErrorContains(t, ValidatePromotions([]Promotion{both}), "percent_off, amount_off and discount")
+
+	require.ErrorContains(t, ValidatePromotions([]Promotion{valid, valid}), `duplicate id "a"`)
+}
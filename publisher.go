@@ -0,0 +1,129 @@
+package aetest
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// EventPublisher publishes the events relayed from the Outbox. Publish must
+// only return nil once the event has been durably handed over, the relay
+// moves past the event when it does. An event whose Publish fails is
+// published again, so that every event is published at least once.
+type EventPublisher interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// MemoryPublisher keeps published events in memory, it is useful in tests and
+// for running the server without an event sink.
+type MemoryPublisher struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+// NewMemoryPublisher creates a MemoryPublisher with no events.
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{}
+}
+
+func (p *MemoryPublisher) Publish(ctx context.Context, event Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.events = append(p.events, event)
+	return nil
+}
+
+// Events returns the published events, oldest first.
+func (p *MemoryPublisher) Events() []Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]Event{}, p.events...)
+}
+
+// FilePublisher appends every event to a file as a JSON line. Each event is
+// synced to disk before Publish returns.
+type FilePublisher struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFilePublisher opens the file at path for appending, creating it if it
+// does not exist.
+func NewFilePublisher(path string) (*FilePublisher, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &FilePublisher{file: file}, nil
+}
+
+func (p *FilePublisher) Publish(ctx context.Context, event Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err := p.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return p.file.Sync()
+}
+
+// Close closes the file.
+func (p *FilePublisher) Close() error {
+	return p.file.Close()
+}
+
+// DefaultNATSTimeout is how long a NATSPublisher waits for JetStream to
+// acknowledge an event when the context passed to Publish has no deadline.
+const DefaultNATSTimeout = 5 * time.Second
+
+// NATSPublisher publishes every event to a NATS JetStream subject made of a
+// prefix and the event type, e.g. "aetest.events.order.created". Publish
+// returns once the stream capturing the subject has acknowledged storing the
+// event, so a stream capturing "<prefix>.>" must exist, events are held in
+// the outbox until it does. The event ID is sent in the `Nats-Msg-Id` header
+// so that the stream discards events published more than once.
+type NATSPublisher struct {
+	js     jetstream.JetStream
+	prefix string
+}
+
+// NewNATSPublisher creates a NATSPublisher publishing on the connection. The
+// connection is owned by the caller.
+func NewNATSPublisher(conn *nats.Conn, prefix string) (*NATSPublisher, error) {
+	js, err := jetstream.New(conn)
+	if err != nil {
+		return nil, err
+	}
+	return &NATSPublisher{js: js, prefix: prefix}, nil
+}
+
+func (p *NATSPublisher) Publish(ctx context.Context, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	msg := nats.NewMsg(p.prefix + "." + event.Type)
+	msg.Header.Set("Content-Type", "application/json")
+	msg.Data = data
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultNATSTimeout)
+		defer cancel()
+	}
+	_, err = p.js.PublishMsg(ctx, msg, jetstream.WithMsgID(event.ID))
+	return err
+}
@@ -0,0 +1,139 @@
+package aetest
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilePublisher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	publisher, err := NewFilePublisher(path)
+	require.NoError(t, err)
+
+	published := []Event{
+		newEvent(EventOrderCreated, time.Now().UTC()),
+		newEvent(EventOrderCancelled, time.Now().UTC()),
+	}
+	for _, event := range published {
+		require.NoError(t, publisher.Publish(context.Background(), event))
+	}
+	require.NoError(t, publisher.Close())
+
+	// Reopening the file appends to it.
+	publisher, err = NewFilePublisher(path)
+	require.NoError(t, err)
+	event := newEvent(EventPriceChanged, time.Now().UTC())
+	require.NoError(t, publisher.Publish(context.Background(), event))
+	require.NoError(t, publisher.Close())
+	published = append(published, event)
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var read []Event
+	decoder := json.NewDecoder(f)
+	for decoder.More() {
+		var event Event
+		require.NoError(t, decoder.Decode(&event))
+		read = append(read, event)
+	}
+	require.Equal(t, published, read)
+}
+
+// runJetStream runs a NATS server with JetStream enabled, storing streams in
+// a temporary directory.
+func runJetStream(t *testing.T) *server.Server {
+	opts := natstest.DefaultTestOptions
+	opts.Port = -1
+	opts.JetStream = true
+	opts.StoreDir = t.TempDir()
+	srv := natstest.RunServer(&opts)
+	t.Cleanup(srv.Shutdown)
+	return srv
+}
+
+func TestFilePublisherOmitsEmail(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	publisher, err := NewFilePublisher(path)
+	require.NoError(t, err)
+	defer publisher.Close()
+
+	// The customer's address is recorded for the confirmation, it is not
+	// relayed to the event file.
+	outbox := NewOutbox()
+	relay, err := NewRelay(outbox, publisher, RelayConfig{})
+	require.NoError(t, err)
+	items, discounts, orders := NewStore()
+	svc := New(items, discounts, orders, WithOutbox(outbox))
+	request := goodOrderRequest
+	request.Email = "customer@example.com"
+	_, err = svc.SimpleSummary(ctx, request)
+	require.NoError(t, err)
+	require.NoError(t, relay.Flush(ctx))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var event map[string]any
+	require.NoError(t, json.Unmarshal(data, &event))
+	require.Equal(t, EventOrderCreated, event["type"])
+	require.NotContains(t, event, "email")
+	require.NotContains(t, string(data), "customer@example.com")
+}
+
+func TestNATSPublisher(t *testing.T) {
+	ctx := context.Background()
+	srv := runJetStream(t)
+	conn, err := nats.Connect(srv.ClientURL())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	publisher, err := NewNATSPublisher(conn, "aetest.events")
+	require.NoError(t, err)
+	event := newEvent(EventOrderCreated, time.Now().UTC())
+	event.Order = &OrderSummary{OrderID: "order-1", TotalCost: 110, Status: OrderPlaced}
+
+	// Without a stream capturing the subjects nothing stores the event, it
+	// stays in the outbox.
+	require.Error(t, publisher.Publish(ctx, event))
+
+	js, err := jetstream.New(conn)
+	require.NoError(t, err)
+	stream, err := js.CreateStream(ctx, jetstream.StreamConfig{
+		Name:     "EVENTS",
+		Subjects: []string{"aetest.events.>"},
+	})
+	require.NoError(t, err)
+
+	// Publish returns once the stream has stored the event, publishing it
+	// again is discarded as a duplicate.
+	require.NoError(t, publisher.Publish(ctx, event))
+	require.NoError(t, publisher.Publish(ctx, event))
+	info, err := stream.Info(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), info.State.Msgs)
+
+	msg, err := stream.GetMsg(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, "aetest.events.order.created", msg.Subject)
+	require.Equal(t, event.ID, msg.Header.Get(nats.MsgIdHdr))
+	var received Event
+	require.NoError(t, json.Unmarshal(msg.Data, &received))
+	require.Equal(t, event, received)
+
+	// Events cannot be published once the connection is closed, they stay
+	// in the outbox.
+	conn.Close()
+	require.Error(t, publisher.Publish(ctx, newEvent(EventOrderCreated, time.Now().UTC())))
+}
@@ -0,0 +1,122 @@
+package aetest
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// APIKeyHeader is the HTTP header identifying the calling client. Requests
+// without an API key are rate limited by their IP address instead. The key is
+// not authenticated here, it is expected to be checked by the gateway in front
+// of the server.
+const APIKeyHeader = "X-API-Key"
+
+// RateLimiter is a token bucket rate limiter with a bucket per client. Each
+// bucket holds up to burst tokens and is refilled at the rate given in
+// requests per second, every request takes a token.
+type RateLimiter struct {
+	mu      sync.Mutex
+	rate    rate.Limit
+	burst   int
+	clients map[string]*clientLimiter
+	now     Clock
+
+	// last_sweep is when idle clients were last removed from clients.
+	last_sweep time.Time
+}
+
+// clientLimiter is the bucket of a single client.
+type clientLimiter struct {
+	limiter   *rate.Limiter
+	last_seen time.Time
+}
+
+// NewRateLimiter creates a RateLimiter allowing each client per_second
+// requests per second, with bursts of up to burst requests.
+func NewRateLimiter(per_second float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		rate:    rate.Limit(per_second),
+		burst:   burst,
+		clients: make(map[string]*clientLimiter),
+		now:     time.Now,
+	}
+}
+
+// WithRateLimit limits the rate of requests to every route registered after
+// it. Requests over the limit are rejected with `429 Too Many Requests` and a
+// `Retry-After` header giving the number of seconds until the client's next
+// request is allowed.
+func WithRateLimit(l *RateLimiter) RouterOption {
+	return func(router *gin.Engine) {
+		router.Use(func(c *gin.Context) {
+			client := "ip:" + c.ClientIP()
+			if api_key := c.GetHeader(APIKeyHeader); api_key != "" {
+				client = "key:" + api_key
+			}
+
+			if wait := l.reserve(client); wait > 0 {
+				retry_after := int(math.Ceil(wait.Seconds()))
+				c.Header("Retry-After", strconv.Itoa(retry_after))
+				c.AbortWithStatusJSON(http.StatusTooManyRequests, GenericErrResponse{
+					Err: "rate limit exceeded",
+				})
+				return
+			}
+
+			c.Next()
+		})
+	}
+}
+
+// reserve takes a token from the client's bucket. If the bucket is empty no
+// token is taken and the time until one is available is returned, otherwise
+// zero is returned.
+func (l *RateLimiter) reserve(client string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	bucket, ok := l.clients[client]
+	if !ok {
+		bucket = &clientLimiter{limiter: rate.NewLimiter(l.rate, l.burst)}
+		l.clients[client] = bucket
+	}
+	bucket.last_seen = now
+
+	reservation := bucket.limiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		// The burst is zero, no request will ever be allowed.
+		return time.Duration(math.MaxInt64)
+	}
+	if wait := reservation.DelayFrom(now); wait > 0 {
+		reservation.CancelAt(now)
+		return wait
+	}
+	return 0
+}
+
+// sweep removes clients that have been idle long enough for their bucket to
+// refill completely, a new bucket is equivalent. Sweeps run at most once a
+// minute so that the cost is spread across many requests. Buckets that are
+// never refilled are never removed.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.last_sweep) < time.Minute || l.rate <= 0 {
+		return
+	}
+	l.last_sweep = now
+
+	refill := time.Duration(float64(l.burst) / float64(l.rate) * float64(time.Second))
+	for client, bucket := range l.clients {
+		if now.Sub(bucket.last_seen) > refill {
+			delete(l.clients, client)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package aetest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimit(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewRateLimiter(0.5, 2)
+	limiter.now = clock.Now
+
+	items, discounts, orders := NewStore()
+	router := NewOrdersRouter(New(items, discounts, orders), WithRateLimit(limiter))
+
+	get := func(api_key string) *http.Response {
+		request := httptest.NewRequest("GET", "/get-catalog", nil)
+		if api_key != "" {
+			request.Header.Set(APIKeyHeader, api_key)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, request)
+		return rec.Result()
+	}
+
+	// The burst is allowed, the next request must wait for a token.
+	require.Equal(t, http.StatusOK, get("").StatusCode)
+	require.Equal(t, http.StatusOK, get("").StatusCode)
+	response := get("")
+	require.Equal(t, http.StatusTooManyRequests, response.StatusCode)
+	require.Equal(t, "2", response.Header.Get("Retry-After"))
+
+	// Clients with an API key have their own bucket.
+	require.Equal(t, http.StatusOK, get("key-1").StatusCode)
+
+	// Rejected requests take no token, one is available after two seconds.
+	clock.Advance(2 * time.Second)
+	require.Equal(t, http.StatusOK, get("").StatusCode)
+	require.Equal(t, http.StatusTooManyRequests, get("").StatusCode)
+}
+
+func TestRateLimiterSweepsIdleClients(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewRateLimiter(1, 5)
+	limiter.now = clock.Now
+
+	require.Zero(t, limiter.reserve("a"))
+	require.Zero(t, limiter.reserve("b"))
+	require.Len(t, limiter.clients, 2)
+
+	// Both buckets have refilled a minute later, so both are removed.
+	clock.Advance(time.Minute)
+	require.Zero(t, limiter.reserve("b"))
+	require.Len(t, limiter.clients, 1)
+}
+
+func TestMaxCartLines(t *testing.T) {
+	items, discounts, orders := NewStore()
+	router := NewOrdersRouter(New(items, discounts, orders, WithMaxCartLines(2)))
+
+	cart := []Item{{ItemName: "Apples", Quantity: 1}, {ItemName: "Oranges", Quantity: 1}}
+	response := postJSON(t, router, "/submit-order", OrderRequest{Cart: cart})
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	cart = append(cart, Item{ItemName: "Apples", Quantity: 1})
+	response = postJSON(t, router, "/submit-order", OrderRequest{Cart: cart})
+	require.Equal(t, http.StatusBadRequest, response.StatusCode)
+	require.Len(t, orders, 1)
+}
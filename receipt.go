@@ -0,0 +1,362 @@
+package aetest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jung-kurt/gofpdf"
+	"github.com/munnerz/goautoneg"
+)
+
+// The media types a receipt can be rendered as.
+const (
+	ReceiptText = "text/plain"
+	ReceiptHTML = "text/html"
+	ReceiptPDF  = "application/pdf"
+)
+
+// receiptTimeLayout is how the order's timestamps are printed on a receipt,
+// always in UTC.
+const receiptTimeLayout = "2 Jan 2006 15:04 MST"
+
+// DefaultReceiptTemplate is the HTML template used for receipts when
+// ReceiptConfig.HTMLTemplate is not set. It is executed with a Receipt.
+const DefaultReceiptTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.StoreName}} receipt {{.Order.OrderID}}</title>
+<style>
+body { font-family: sans-serif; max-width: 40em; margin: 2em auto; }
+table { width: 100%; border-collapse: collapse; }
+th, td { padding: 0.25em 0.5em; border-bottom: 1px solid #ddd; }
+th, td.number { text-align: right; }
+th:first-child { text-align: left; }
+.cancelled { color: #b00; font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>{{.StoreName}}</h1>
+<p>Order {{.Order.OrderID}}<br>Placed {{time .Order.CreatedAt}}</p>
+{{if .Cancelled}}<p class="cancelled">Cancelled {{time .Order.UpdatedAt}}</p>{{end}}
+<table>
+<tr><th>Item</th><th>Quantity</th><th>Price</th><th>Discount</th><th>Total</th></tr>
+{{range .Lines}}<tr>
+<td>{{.ItemName}}{{if .SKU}} <small>{{.SKU}}</small>{{end}}</td>
+<td class="number">{{.Quantity}}</td>
+<td class="number">{{.Cost}}</td>
+<td class="number">{{if .Discount}}-{{.Discount}}{{end}}</td>
+<td class="number">{{.Total}}</td>
+</tr>
+{{end}}<tr><td colspan="4">Subtotal</td><td class="number">{{.Subtotal}}</td></tr>
+{{if .Discount}}<tr><td colspan="4">Discounts</td><td class="number">-{{.Discount}}</td></tr>
+{{end}}<tr><th colspan="4">Total</th><th>{{.Total}}</th></tr>
+</table>
+{{if .Footer}}<p>{{.Footer}}</p>{{end}}
+</body>
+</html>
+`
+
+// ReceiptConfig configures a ReceiptRenderer, zero values use the defaults.
+type ReceiptConfig struct {
+	// StoreName heads every receipt, defaulting to "AEtest".
+	StoreName string
+
+	// Footer is printed at the bottom of every receipt, e.g. a returns
+	// policy or contact details. There is no footer by default.
+	Footer string
+
+	// HTMLTemplate renders HTML receipts, defaulting to
+	// DefaultReceiptTemplate. It is executed with a Receipt, see
+	// ParseReceiptTemplate.
+	HTMLTemplate *template.Template
+}
+
+// Receipt is an order laid out for printing. Amounts are in the same units as
+// item costs.
+type Receipt struct {
+	StoreName string
+	Footer    string
+	Order     OrderSummary
+	Cancelled bool
+	Lines     []ReceiptLine
+
+	// Subtotal is the cost of every line before discounts, Discount the total
+	// taken off by offers and Total the cost of the order.
+	Subtotal int
+	Discount int
+	Total    int
+}
+
+// ReceiptLine is an item of a Receipt. Cost is the price of a single item and
+// Total the cost of the line after its Discount.
+type ReceiptLine struct {
+	ItemName string
+	SKU      string
+	Quantity int
+	Cost     int
+	Discount int
+	Total    int
+}
+
+// ReceiptRenderer renders stored orders as receipts in plain text, HTML or
+// PDF.
+type ReceiptRenderer struct {
+	config ReceiptConfig
+}
+
+// NewReceiptRenderer creates a ReceiptRenderer.
+func NewReceiptRenderer(config ReceiptConfig) *ReceiptRenderer {
+	if config.StoreName == "" {
+		config.StoreName = "AEtest"
+	}
+	if config.HTMLTemplate == nil {
+		config.HTMLTemplate = template.Must(newReceiptTemplate("receipt").Parse(DefaultReceiptTemplate))
+	}
+	return &ReceiptRenderer{config: config}
+}
+
+// ParseReceiptTemplate parses the HTML receipt template in the file at path,
+// to brand receipts. Besides the fields of the Receipt it is executed with,
+// the template can call `time` to format a timestamp the way the other
+// receipts do.
+func ParseReceiptTemplate(path string) (*template.Template, error) {
+	// The template is named after the file, as ParseFiles names the
+	// templates it parses.
+	return newReceiptTemplate(filepath.Base(path)).ParseFiles(path)
+}
+
+func newReceiptTemplate(name string) *template.Template {
+	return template.New(name).Funcs(template.FuncMap{
+		"time": formatReceiptTime,
+	})
+}
+
+func formatReceiptTime(t time.Time) string {
+	return t.UTC().Format(receiptTimeLayout)
+}
+
+// Receipt lays out the order for printing.
+func (r *ReceiptRenderer) Receipt(order OrderSummary) Receipt {
+	receipt := Receipt{
+		StoreName: r.config.StoreName,
+		Footer:    r.config.Footer,
+		Order:     order,
+		Cancelled: order.Status == OrderCancelled,
+		Total:     order.TotalCost,
+	}
+	for _, item := range order.Summary {
+		subtotal := item.Cost * item.Quantity
+		receipt.Lines = append(receipt.Lines, ReceiptLine{
+			ItemName: item.ItemName,
+			SKU:      item.SKU,
+			Quantity: item.Quantity,
+			Cost:     item.Cost,
+			Discount: item.Discount,
+			Total:    subtotal - item.Discount,
+		})
+		receipt.Subtotal += subtotal
+		receipt.Discount += item.Discount
+	}
+	return receipt
+}
+
+// Render writes the order's receipt to w as the given media type, one of
+// ReceiptText, ReceiptHTML or ReceiptPDF.
+func (r *ReceiptRenderer) Render(w io.Writer, media_type string, order OrderSummary) error {
+	switch media_type {
+	case ReceiptText:
+		return r.Text(w, order)
+	case ReceiptHTML:
+		return r.HTML(w, order)
+	case ReceiptPDF:
+		return r.PDF(w, order)
+	}
+	return fmt.Errorf("unsupported receipt type %q", media_type)
+}
+
+// Text writes the order's receipt to w as plain text.
+func (r *ReceiptRenderer) Text(w io.Writer, order OrderSummary) error {
+	receipt := r.Receipt(order)
+
+	fmt.Fprintf(w, "%s\n\n", receipt.StoreName)
+	fmt.Fprintf(w, "Order %s\n", order.OrderID)
+	fmt.Fprintf(w, "Placed %s\n", formatReceiptTime(order.CreatedAt))
+	if receipt.Cancelled {
+		fmt.Fprintf(w, "CANCELLED %s\n", formatReceiptTime(order.UpdatedAt))
+	}
+	fmt.Fprintln(w)
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "ITEM\tQUANTITY\tPRICE\tDISCOUNT\tTOTAL")
+	for _, line := range receipt.Lines {
+		fmt.Fprintf(
+			table, "%s\t%d\t%d\t%s\t%d\n",
+			line.ItemName, line.Quantity, line.Cost,
+			formatReceiptDiscount(line.Discount), line.Total,
+		)
+	}
+	fmt.Fprintf(table, "\t\t\t\t\nSUBTOTAL\t\t\t\t%d\n", receipt.Subtotal)
+	if receipt.Discount != 0 {
+		fmt.Fprintf(table, "DISCOUNTS\t\t\t\t-%d\n", receipt.Discount)
+	}
+	fmt.Fprintf(table, "TOTAL\t\t\t\t%d\n", receipt.Total)
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	if receipt.Footer != "" {
+		fmt.Fprintf(w, "\n%s\n", receipt.Footer)
+	}
+	return nil
+}
+
+// formatReceiptDiscount leaves the discount column blank for lines without
+// one.
+func formatReceiptDiscount(discount int) string {
+	if discount == 0 {
+		return ""
+	}
+	return fmt.Sprintf("-%d", discount)
+}
+
+// HTML writes the order's receipt to w as HTML, using the configured template.
+func (r *ReceiptRenderer) HTML(w io.Writer, order OrderSummary) error {
+	return r.config.HTMLTemplate.Execute(w, r.Receipt(order))
+}
+
+// PDF writes the order's receipt to w as an A4 PDF document, long orders
+// continue onto further pages.
+func (r *ReceiptRenderer) PDF(w io.Writer, order OrderSummary) error {
+	receipt := r.Receipt(order)
+
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetTitle(fmt.Sprintf("%s receipt %s", receipt.StoreName, order.OrderID), true)
+	pdf.SetCreationDate(order.CreatedAt)
+	pdf.AddPage()
+
+	// The core fonts only cover Latin-1, item names are translated so that
+	// accented characters print correctly.
+	translate := pdf.UnicodeTranslatorFromDescriptor("")
+
+	pdf.SetFont("Helvetica", "B", 18)
+	pdf.CellFormat(0, 10, translate(receipt.StoreName), "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.CellFormat(0, 6, "Order "+order.OrderID, "", 1, "L", false, 0, "")
+	pdf.CellFormat(0, 6, "Placed "+formatReceiptTime(order.CreatedAt), "", 1, "L", false, 0, "")
+	if receipt.Cancelled {
+		pdf.SetTextColor(176, 0, 0)
+		pdf.CellFormat(0, 6, "CANCELLED "+formatReceiptTime(order.UpdatedAt), "", 1, "L", false, 0, "")
+		pdf.SetTextColor(0, 0, 0)
+	}
+	pdf.Ln(6)
+
+	widths := []float64{80, 25, 25, 25, 25}
+	row := func(cells []string, border string) {
+		for i, cell := range cells {
+			align := "R"
+			if i == 0 {
+				align = "L"
+			}
+			pdf.CellFormat(widths[i], 7, translate(cell), border, 0, align, false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+
+	pdf.SetFont("Helvetica", "B", 10)
+	row([]string{"Item", "Quantity", "Price", "Discount", "Total"}, "B")
+	pdf.SetFont("Helvetica", "", 10)
+	for _, line := range receipt.Lines {
+		row([]string{
+			line.ItemName,
+			fmt.Sprint(line.Quantity),
+			fmt.Sprint(line.Cost),
+			formatReceiptDiscount(line.Discount),
+			fmt.Sprint(line.Total),
+		}, "")
+	}
+	pdf.Ln(2)
+	row([]string{"Subtotal", "", "", "", fmt.Sprint(receipt.Subtotal)}, "T")
+	if receipt.Discount != 0 {
+		row([]string{"Discounts", "", "", "", formatReceiptDiscount(receipt.Discount)}, "")
+	}
+	pdf.SetFont("Helvetica", "B", 10)
+	row([]string{"Total", "", "", "", fmt.Sprint(receipt.Total)}, "")
+
+	if receipt.Footer != "" {
+		pdf.Ln(8)
+		pdf.SetFont("Helvetica", "", 9)
+		pdf.MultiCell(0, 5, translate(receipt.Footer), "", "L", false)
+	}
+
+	return pdf.Output(w)
+}
+
+// receiptTypes are the media types offered to callers, the first is used when
+// the caller accepts anything.
+var receiptTypes = []string{ReceiptText, ReceiptHTML, ReceiptPDF}
+
+// WithReceipts serves the receipt of a stored order at
+// `/orders/{order_id}/receipt`. The format is negotiated from the `Accept`
+// header, plain text unless HTML or PDF is preferred, and callers accepting
+// none of them are answered with `406 Not Acceptable`.
+func WithReceipts(svc Service, renderer *ReceiptRenderer) RouterOption {
+	return func(router *gin.Engine) {
+		router.GET("/orders/:order_id/receipt", func(c *gin.Context) {
+			media_type := ReceiptText
+			if accept := c.GetHeader("Accept"); accept != "" {
+				media_type = goautoneg.Negotiate(accept, receiptTypes)
+			}
+			if media_type == "" {
+				c.JSON(http.StatusNotAcceptable, GenericErrResponse{
+					Err: "receipts are available as text/plain, text/html or application/pdf",
+				})
+				return
+			}
+
+			request := GetSingleOrderRequest{c.Param("order_id")}
+			c.Set(orderIDKey, request.OrderID)
+			order, err := svc.GetSingleOrder(c.Request.Context(), request)
+			if err != nil {
+				// Malformed order id, respond with 400
+				if errors.Is(err, ErrInvalidRequest) {
+					c.JSON(http.StatusBadRequest, GenericErrResponse{
+						Err: err.Error(),
+					})
+					return
+				}
+
+				// Order not found, respond with 404
+				c.JSON(errorStatus(err, http.StatusNotFound), GenericErrResponse{
+					Err: err.Error(),
+				})
+				return
+			}
+
+			// Receipts are rendered in full before responding so that a
+			// failure can still be reported with an error status.
+			var body bytes.Buffer
+			if err := renderer.Render(&body, media_type, order); err != nil {
+				c.JSON(http.StatusInternalServerError, GenericErrResponse{
+					Err: err.Error(),
+				})
+				return
+			}
+
+			content_type := media_type
+			if media_type != ReceiptPDF {
+				content_type += "; charset=utf-8"
+			}
+			c.Header("Vary", "Accept")
+			c.Data(http.StatusOK, content_type, body.Bytes())
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package aetest
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReceipt(t *testing.T) {
+	summary, err := service.Quote(context.Background(), goodOrderRequest)
+	require.NoError(t, err)
+
+	renderer := NewReceiptRenderer(ReceiptConfig{})
+	receipt := renderer.Receipt(summary)
+	require.Equal(t, "AEtest", receipt.StoreName)
+	require.Len(t, receipt.Lines, len(summary.Summary))
+
+	// The lines add up to the total of the order.
+	total := 0
+	for _, line := range receipt.Lines {
+		total += line.Total
+	}
+	require.Equal(t, summary.TotalCost, total)
+	require.Equal(t, summary.TotalCost, receipt.Subtotal-receipt.Discount)
+	require.NotZero(t, receipt.Discount)
+}
+
+func TestReceiptTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipt.html")
+	require.NoError(t, os.WriteFile(
+		path,
+		[]byte(`<h1>{{.StoreName}}</h1>{{range .Lines}}<p>{{.ItemName}}</p>{{end}}<p>{{time .Order.CreatedAt}}</p>`),
+		0o644,
+	))
+	template, err := ParseReceiptTemplate(path)
+	require.NoError(t, err)
+
+	summary, err := service.Quote(context.Background(), goodOrderRequest)
+	require.NoError(t, err)
+
+	renderer := NewReceiptRenderer(ReceiptConfig{StoreName: "Fruit & Veg", HTMLTemplate: template})
+	var html bytes.Buffer
+	require.NoError(t, renderer.HTML(&html, summary))
+	require.Contains(t, html.String(), "<h1>Fruit &amp; Veg</h1><p>Apples</p><p>Oranges</p>")
+}
+
+func TestReceiptHTTP(t *testing.T) {
+	ctx := context.Background()
+	renderer := NewReceiptRenderer(ReceiptConfig{Footer: "Thank you for shopping with us"})
+	router := NewOrdersRouter(service, WithReceipts(service, renderer))
+
+	placed, err := service.SimpleSummary(ctx, goodOrderRequest)
+	require.NoError(t, err)
+
+	getReceipt := func(order_id string, accept string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("GET", "/orders/"+order_id+"/receipt", nil)
+		if accept != "" {
+			request.Header.Set("Accept", accept)
+		}
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		return response
+	}
+
+	// Plain text is the default.
+	for _, accept := range []string{"", "*/*"} {
+		response := getReceipt(placed.OrderID, accept)
+		require.Equal(t, http.StatusOK, response.Code)
+		require.Equal(t, "text/plain; charset=utf-8", response.Header().Get("Content-Type"))
+		require.Contains(t, response.Body.String(), "Order "+placed.OrderID)
+		require.Contains(t, response.Body.String(), "Apples")
+		require.Contains(t, response.Body.String(), "Thank you for shopping with us")
+		require.NotContains(t, response.Body.String(), "CANCELLED")
+	}
+
+	// Browsers prefer HTML.
+	response := getReceipt(placed.OrderID, "text/html,application/xhtml+xml,*/*;q=0.8")
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, "text/html; charset=utf-8", response.Header().Get("Content-Type"))
+	require.Contains(t, response.Body.String(), "<td>Oranges <small>FRUIT-002</small></td>")
+
+	response = getReceipt(placed.OrderID, "application/pdf")
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, "application/pdf", response.Header().Get("Content-Type"))
+	require.True(t, strings.HasPrefix(response.Body.String(), "%PDF-"))
+
+	response = getReceipt(placed.OrderID, "application/json")
+	require.Equal(t, http.StatusNotAcceptable, response.Code)
+
+	// Cancelled orders still have a receipt, marked as cancelled.
+	_, err = service.CancelOrder(ctx, CancelOrderRequest{placed.OrderID})
+	require.NoError(t, err)
+	response = getReceipt(placed.OrderID, "text/plain")
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Contains(t, response.Body.String(), "CANCELLED")
+
+	response = getReceipt(uuid.NewV4().String(), "")
+	require.Equal(t, http.StatusNotFound, response.Code)
+	response = getReceipt("not-an-id", "")
+	require.Equal(t, http.StatusBadRequest, response.Code)
+}
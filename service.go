@@ -1,10 +1,20 @@
 package aetest
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"log/slog"
+	"math/bits"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/johncgriffin/overflow"
 	uuid "github.com/satori/go.uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -22,149 +32,1006 @@ var (
 	// ErrIntegerOverflow is returned when the user enters an integer value
 	// that the hardware cannot process without overlow.
 	ErrOrderNotFound = errors.New("order not found")
+
+	// ErrOrderExists is returned when an imported order has the same
+	// order_id as an order that is already stored.
+	ErrOrderExists = errors.New("order already exists")
+
+	// ErrOrderCancelled is returned when cancelling an order that has
+	// already been cancelled.
+	ErrOrderCancelled = errors.New("order already cancelled")
+
+	// ErrRequestCancelled is returned when the context passed to the Service
+	// is cancelled, or its deadline passes, before the operation completes.
+	// The context's error is wrapped alongside it so the cause can be checked
+	// with `errors.Is(err, context.DeadlineExceeded)`. No changes are stored
+	// when this is returned.
+	ErrRequestCancelled = errors.New("request cancelled before completion")
 )
 
 // Service is an interface that encapsulates all the functionalities of the
-// orders service.
+// orders service. Every method accepts a context, if the context is cancelled
+// or its deadline passes the method returns an error wrapping
+// ErrRequestCancelled.
 type Service interface {
 	// SimpleSummary creates an OrderSummary from a submitted order request. If
 	// the order is invalid this returns an empty OrderSummary and a relevant
 	// error message to the caller.
-	SimpleSummary(req OrderRequest) (OrderSummary, error)
+	SimpleSummary(ctx context.Context, req OrderRequest) (OrderSummary, error)
 
 	// GetSingleOrder returns an OrderSummary using the order_id from a user
 	// supplied GetSingleOrderRequest. If the order_id is invalid this returns
 	// an empty OrderSummary and a relevant error message to the caller.
-	GetSingleOrder(req GetSingleOrderRequest) (OrderSummary, error)
+	GetSingleOrder(
+		ctx context.Context,
+		req GetSingleOrderRequest,
+	) (OrderSummary, error)
+
+	// GetAllOrders returns all orders that have been processed, oldest first.
+	// If no orders exists this return an empty AllOrders to the caller.
+	GetAllOrders(ctx context.Context) (AllOrders, error)
+
+	// GetOrdersInRange returns all orders created within the time range of
+	// the supplied OrdersInRangeRequest, oldest first. If the range is invalid
+	// this returns an empty AllOrders and a relevant error to the caller.
+	GetOrdersInRange(
+		ctx context.Context,
+		req OrdersInRangeRequest,
+	) (AllOrders, error)
+
+	// CancelOrder cancels a stored order, returning the cancelled
+	// OrderSummary. The order is kept in the store with its status set to
+	// OrderCancelled. If the order_id is invalid, the order does not exist or
+	// it has already been cancelled this returns an empty OrderSummary and a
+	// relevant error to the caller.
+	CancelOrder(ctx context.Context, req CancelOrderRequest) (OrderSummary, error)
+
+	// Quote prices an order request exactly as SimpleSummary does but does
+	// not store the order. The returned OrderSummary has an empty OrderID and
+	// is timestamped with the time it was priced.
+	Quote(ctx context.Context, req OrderRequest) (OrderSummary, error)
+
+	// ExplainPrice prices an order request as Quote does, at the requested
+	// time, and returns the priced order along with every step taken to
+	// price it: the cost of each line, the overflow checks, the subtotal,
+	// each discount considered and whether it was given, and the total.
+	ExplainPrice(
+		ctx context.Context,
+		req ExplainPriceRequest,
+	) (PriceExplanation, error)
 
-	// GetAllOrders returns all orders that have been processed. If no orders
-	// exists this return an empty AllOrders to the caller.
-	GetAllOrders() AllOrders
+	// GetCatalog returns every item that can currently be ordered along with
+	// its cost, sorted by item name.
+	GetCatalog(ctx context.Context) (Catalog, error)
+
+	// SetItemPrice adds an item to the catalog or updates the cost of an
+	// existing item, immediately or from a time in the future. The returned
+	// CatalogItem has the cost that was set.
+	SetItemPrice(
+		ctx context.Context,
+		req SetItemPriceRequest,
+	) (CatalogItem, error)
+
+	// GetPriceHistory returns every cost an item has had, and the costs it is
+	// scheduled to have. If the item does not exist this returns an empty
+	// PriceHistory and ErrItemDoesNotExist.
+	GetPriceHistory(
+		ctx context.Context,
+		req PriceHistoryRequest,
+	) (PriceHistory, error)
+
+	// RemoveItem removes an item from the catalog. Orders that have already
+	// been stored are unaffected.
+	RemoveItem(ctx context.Context, req RemoveItemRequest) error
+
+	// Stats returns the number of stored orders and the number of items in
+	// the catalog.
+	Stats(ctx context.Context) (StoreStats, error)
+
+	// ImportOrders validates and stores previously exported orders. Either
+	// all orders are imported or, if any order is invalid or already exists,
+	// none are. Imported orders are not re-priced against the catalog, orders
+	// without timestamps are timestamped with the time of import.
+	ImportOrders(
+		ctx context.Context,
+		orders []OrderSummary,
+	) (ImportOrdersResponse, error)
 }
 
 // orderService is a private struct that is used to satisfy the interface
 // requirements of the Service. The methods of this structure is used to call
 // the Service' methods. This struct holds an ItemStore that is used to provide
-// a lookup of the cost of the users items.
+// a lookup of the cost of the users items. The stores are guarded by mu as
+// the catalog can be modified while orders are being processed.
 type orderService struct {
+	mu          *sync.RWMutex
 	item_store  ItemStore
 	discount    ItemDiscount
 	order_store OrderStore
+	now         Clock
+	logger      *slog.Logger
+	tracer      trace.Tracer
+
+	// max_cart_lines is the largest number of lines accepted in an order's
+	// cart, zero means no limit.
+	max_cart_lines int
+
+	// duplicate_lines is how carts with several lines for an item are
+	// handled.
+	duplicate_lines DuplicateLines
+
+	// discount_policy is how the offers given on an item are chosen when
+	// several apply.
+	discount_policy DiscountPolicy
+
+	// promotions are the time limited discounts, they are not changed once
+	// the Service is created.
+	promotions []Promotion
+
+	// price_lists are the costs for particular customer groups and
+	// channels, they are not changed once the Service is created.
+	price_lists []PriceList
+
+	// outbox records an Event for every change to the stores, nil when no
+	// events are recorded. Events are added while mu is held, before the
+	// change is made, so that no change is made without its event.
+	outbox *Outbox
 }
 
 // InjectCost adds the cost the user supplied Cart. This makes use of the
-// orderService' internal ItemStore map to lookup the items cost. If an Item
-// does not exist in the internal ItemStore this returns an empty
-// `ItemsWithCost` and `false`.
-func (svc orderService) InjectCost(cart []Item) ([]ItemWithCost, bool) {
+// orderService' internal ItemStore map to lookup the items cost at the time
+// of the order. Items in the named price list, or a list it falls back to,
+// cost what the list says instead. If any Item does not exist in the internal
+// ItemStore, or has no price yet at that time, this returns an empty
+// `ItemsWithCost` and an *UnknownItemsError listing every such Item with
+// suggestions of the items that may have been meant.
+func (svc orderService) InjectCost(
+	cart []Item,
+	at time.Time,
+	price_list string,
+) ([]ItemWithCost, error) {
+	svc.mu.RLock()
+	defer svc.mu.RUnlock()
+
 	injectedItems := []ItemWithCost{}
+	var unknown []UnknownItem
 
-	for _, item := range cart {
-		cost, ok := svc.item_store[item.ItemName]
-		if !ok {
-			// item does not exist
-			return []ItemWithCost{}, false
+	for i, item := range cart {
+		sku, stored, ok := svc.item_store.Find(item.SKU, item.ItemName)
+		cost, priced := stored.CostAt(at)
+		if !ok || !priced {
+			// item does not exist, carry on so that every unknown item is
+			// reported at once.
+			unknown = append(unknown, UnknownItem{
+				Line:        i,
+				ItemName:    item.ItemName,
+				SKU:         item.SKU,
+				Suggestions: svc.item_store.Suggest(item.SKU, item.ItemName),
+			})
+			continue
+		}
+		priced_from := DefaultPriceList
+		if list_cost, list_name, ok := svc.listCost(price_list, sku); ok {
+			cost, priced_from = list_cost, list_name
 		}
-		with_cost := ItemWithCost{item.ItemName, item.Quantity, cost}
+		// Lines are recorded with the catalog name of the item, however it
+		// was referred to in the cart.
+		with_cost := ItemWithCost{stored.Name, item.Quantity, cost, 0, sku, priced_from}
 		injectedItems = append(injectedItems, with_cost)
 	}
 
-	return injectedItems, true
+	if len(unknown) > 0 {
+		return []ItemWithCost{}, &UnknownItemsError{unknown}
+	}
+	return injectedItems, nil
 }
 
-// New returns a new Service to the caller.
+// New returns a new Service to the caller. The behaviour of the Service can be
+// changed with any number of Options.
 func New(
 	item_store ItemStore,
 	discount ItemDiscount,
 	order_store OrderStore,
+	opts ...Option,
 ) Service {
-	return orderService{item_store, discount, order_store}
+	svc := orderService{
+		mu:          &sync.RWMutex{},
+		item_store:  item_store,
+		discount:    discount,
+		order_store: order_store,
+		now:         systemClock,
+		logger:      discardLogger,
+		tracer:      defaultTracer(),
+
+		max_cart_lines:  DefaultMaxCartLines,
+		duplicate_lines: MergeDuplicateLines,
+		discount_policy: DiscountBestForCustomer,
+	}
+
+	for _, opt := range opts {
+		opt(&svc)
+	}
+
+	return svc
 }
 
 func (svc orderService) SimpleSummary(
+	ctx context.Context,
+	req OrderRequest,
+) (_ OrderSummary, err error) {
+	ctx, span := svc.tracer.Start(ctx, "orders.SimpleSummary")
+	defer func() { endSpan(span, err) }()
+
+	complete_order, err := svc.price(ctx, req, svc.now(), nil)
+	if err != nil {
+		svc.logger.WarnContext(ctx, "order rejected", "error", err)
+		return OrderSummary{}, err
+	}
+
+	// Generate a unique order_id and use this to identify the OrderSummary.
+	// Store the completed order in the internal OrderStore.
+	complete_order.OrderID = uuid.NewV4().String()
+	complete_order.Status = OrderPlaced
+	span.SetAttributes(attribute.String("order.id", complete_order.OrderID))
+
+	// This is the last point at which the order can be abandoned, once stored
+	// the order is complete even if the caller has since gone away.
+	if err := contextError(ctx); err != nil {
+		svc.logger.WarnContext(ctx, "order abandoned", "error", err)
+		return OrderSummary{}, err
+	}
+
+	created := orderEvent(EventOrderCreated, complete_order)
+	created.Email = req.Email
+	_, store_span := svc.tracer.Start(ctx, "orders.store")
+	svc.mu.Lock()
+	err = svc.record(created)
+	if err == nil {
+		svc.order_store[complete_order.OrderID] = complete_order
+	}
+	svc.mu.Unlock()
+	endSpan(store_span, err)
+	if err != nil {
+		svc.logger.ErrorContext(ctx, "order not stored", "error", err)
+		return OrderSummary{}, err
+	}
+
+	svc.logger.InfoContext(
+		ctx, "order submitted",
+		"order_id", complete_order.OrderID,
+		"lines", len(complete_order.Summary),
+		"total_cost", complete_order.TotalCost,
+	)
+
+	return complete_order, nil
+}
+
+func (svc orderService) Quote(
+	ctx context.Context,
 	req OrderRequest,
+) (_ OrderSummary, err error) {
+	ctx, span := svc.tracer.Start(ctx, "orders.Quote")
+	defer func() { endSpan(span, err) }()
+
+	return svc.price(ctx, req, svc.now(), nil)
+}
+
+func (svc orderService) ExplainPrice(
+	ctx context.Context,
+	req ExplainPriceRequest,
+) (_ PriceExplanation, err error) {
+	ctx, span := svc.tracer.Start(ctx, "orders.ExplainPrice")
+	defer func() { endSpan(span, err) }()
+
+	priced_at := svc.now()
+	if req.At != nil {
+		priced_at = req.At.UTC()
+	}
+
+	steps := &pricingSteps{}
+	summary, err := svc.price(ctx, req.OrderRequest, priced_at, steps)
+	if err != nil {
+		return PriceExplanation{}, err
+	}
+	return PriceExplanation{Order: summary, Steps: steps.steps}, nil
+}
+
+// price validates the order request and calculates the total cost of the
+// cart at the given time with any discounts applied. This is the pricing path
+// shared by SimpleSummary, Quote and ExplainPrice, the resulting OrderSummary
+// is not stored and has no OrderID. Each phase of pricing is recorded as a
+// separate span, and each step in steps when the price is being explained.
+func (svc orderService) price(
+	ctx context.Context,
+	req OrderRequest,
+	priced_at time.Time,
+	steps *pricingSteps,
 ) (OrderSummary, error) {
+	if err := contextError(ctx); err != nil {
+		return OrderSummary{}, err
+	}
+
+	// Reject oversized carts before validating or pricing any of their lines.
+	if svc.max_cart_lines > 0 && len(req.Cart) > svc.max_cart_lines {
+		return OrderSummary{}, fmt.Errorf(
+			"%w: cart has %d lines, at most %d are allowed",
+			ErrInvalidRequest, len(req.Cart), svc.max_cart_lines,
+		)
+	}
+
 	// Validate the user input using custom validation schema.
-	if err := req.Validate(); err != nil {
+	_, span := svc.tracer.Start(ctx, "orders.validate")
+	err := req.Validate()
+	endSpan(span, err)
+	if err != nil {
 		return OrderSummary{}, ErrInvalidRequest
 	}
 
-	// Inject associated costs of the items to the cart using a price lookup.
-	cart_with_costs, ok := svc.InjectCost(req.Cart)
-	if !ok {
-		return OrderSummary{}, ErrItemDoesNotExist
+	// Inject associated costs of the items to the cart using a price lookup,
+	// from the price list for the customer's group or channel. The order is
+	// timestamped when it is priced, items cost what they cost at that time.
+	price_list := svc.selectPriceList(req)
+	if selected, ok := svc.priceList(price_list); ok {
+		steps.priceList(req, &selected)
+	} else {
+		steps.priceList(req, nil)
+	}
+	_, span = svc.tracer.Start(
+		ctx, "orders.inject_cost",
+		trace.WithAttributes(attribute.String("order.price_list", price_list)),
+	)
+	cart_with_costs, err := svc.InjectCost(req.Cart, priced_at, price_list)
+	endSpan(span, err)
+	if err != nil {
+		return OrderSummary{}, err
+	}
+	steps.lookups(cart_with_costs, price_list, priced_at)
+
+	// Duplicates are found once the items are resolved, as different names
+	// may refer to the same item.
+	if svc.duplicate_lines == RejectDuplicateLines {
+		if item_name, ok := duplicateItem(cart_with_costs); ok {
+			return OrderSummary{}, fmt.Errorf(
+				"%w: item %q is on more than one line",
+				ErrInvalidRequest, item_name,
+			)
+		}
 	}
 
+	_, span = svc.tracer.Start(
+		ctx, "orders.discount",
+		trace.WithAttributes(attribute.Int("order.lines", len(cart_with_costs))),
+	)
+	running_total, err := svc.applyDiscounts(ctx, cart_with_costs, priced_at, steps)
+	if err == nil {
+		span.SetAttributes(attribute.Int("order.total_cost", running_total))
+	}
+	endSpan(span, err)
+	if err != nil {
+		return OrderSummary{}, err
+	}
+
+	// The same time is used for creation and last update as the order has
+	// not yet been modified. The discount policy is recorded so that the
+	// discounts can be explained later, even if the policy has changed.
+	return OrderSummary{
+		Summary:        cart_with_costs,
+		TotalCost:      running_total,
+		CreatedAt:      priced_at,
+		UpdatedAt:      priced_at,
+		DiscountPolicy: svc.discount_policy,
+	}, nil
+}
+
+// applyDiscounts calculates the total cost of the cart, applying the discount
+// of each item and recording it on the item's lines. Lines for the same item
+// are priced together so that the discount applies to the total quantity of
+// the item ordered, however many lines it was split across. Promotions apply
+// if they are active at the time the order is priced, the offers given on
+// each item are chosen by the Service's DiscountPolicy. The context is checked
+// before each item so that a large cart can be abandoned part way. Each
+// overflow check and discount is recorded in steps.
+func (svc orderService) applyDiscounts(
+	ctx context.Context,
+	cart_with_costs []ItemWithCost,
+	priced_at time.Time,
+	steps *pricingSteps,
+) (int, error) {
 	var running_total int = 0
 
+	// Group the line indexes by SKU, keeping the items in the order they
+	// first appear in the cart.
+	item_lines := map[string][]int{}
+	var skus []string
+	for i, item := range cart_with_costs {
+		if _, ok := item_lines[item.SKU]; !ok {
+			skus = append(skus, item.SKU)
+		}
+		item_lines[item.SKU] = append(item_lines[item.SKU], i)
+	}
+
 	// Iterate through the items in the cart adding the calculated amount to
 	// the running total. Integer overflows need to be handled appropriately,
-	// this is detected initially on the multiplication of the item Cost x
-	// Quantity and finally during the sum of the result and running total.
-	for _, item := range cart_with_costs {
-		intermediate_result, ok := overflow.Mul(item.Cost, item.Quantity)
+	// this is detected initially on the sum of the item's quantities, then on
+	// the multiplication of the item Cost x Quantity and finally during the
+	// sum of the result and running total.
+	items := make([]pricedItem, 0, len(skus))
+	for _, sku := range skus {
+		if err := contextError(ctx); err != nil {
+			return 0, err
+		}
+
+		lines := item_lines[sku]
+		cost := cart_with_costs[lines[0]].Cost
+		quantity := 0
+		for _, i := range lines {
+			var ok bool
+			quantity, ok = overflow.Add(quantity, cart_with_costs[i].Quantity)
+			if !ok {
+				return 0, ErrIntegerOverflow
+			}
+		}
+
+		item := pricedItem{sku, lines, cost, quantity}
+		steps.quantityChecked(item)
+
+		intermediate_result, ok := overflow.Mul(cost, quantity)
 		if !ok {
-			return OrderSummary{}, ErrIntegerOverflow
+			return 0, ErrIntegerOverflow
 		}
+		steps.lineCostChecked(item, intermediate_result)
 
 		result, ok := overflow.Add(intermediate_result, running_total)
 		if !ok {
-			return OrderSummary{}, ErrIntegerOverflow
+			return 0, ErrIntegerOverflow
 		}
+		steps.subtotalChecked(item, running_total, intermediate_result, result)
 
-		// Using the ItemDiscount lookup whether a discount exists for that
-		// item. If a discount is not found by the above logic this does not
-		// mean that the item does not exist in the store, it is fine to skip
-		// the discount step. If the discount exists apply the discount to the
-		// result.
-		calculate_discount, ok := svc.discount[item.ItemName]
-		if ok {
-			// discount found, apply the discount
-			discount := calculate_discount(item.Cost, item.Quantity)
-			result -= discount
+		running_total = result
+		items = append(items, item)
+	}
+	steps.subtotal(running_total)
+
+	// The discounts are worked out once every item is priced, as exclusive
+	// promotions and discount caps depend on the whole order.
+	steps.discountPolicy(svc.discount_policy)
+	plan := svc.planOrderDiscounts(items, priced_at, steps)
+	steps.discounts(svc.discount_policy, items, plan, svc.promotions, priced_at)
+	for i, item := range items {
+		allocateDiscount(cart_with_costs, item.lines, plan.discounts[i], item.quantity)
+	}
+
+	total := running_total - plan.total
+	steps.total(running_total, plan.total, total)
+	return total, nil
+}
+
+// duplicateItem returns the name of the first item that is on more than one
+// line of the cart, if any.
+func duplicateItem(cart_with_costs []ItemWithCost) (string, bool) {
+	seen := make(map[string]bool, len(cart_with_costs))
+	for _, item := range cart_with_costs {
+		if seen[item.SKU] {
+			return item.ItemName, true
 		}
+		seen[item.SKU] = true
+	}
+	return "", false
+}
 
-		running_total = result
+// allocateDiscount splits the non-negative discount of an item across the
+// lines it was ordered on, in proportion to the quantity of each line.
+// Rounding down leaves a remainder of less than one unit per line, this is
+// given a unit at a time to the earliest lines that can take it without
+// costing less than nothing.
+func allocateDiscount(
+	cart_with_costs []ItemWithCost,
+	lines []int,
+	discount int,
+	quantity int,
+) {
+	if len(lines) == 1 || discount <= 0 {
+		cart_with_costs[lines[0]].Discount = discount
+		return
 	}
 
-	// Generate a unique order_id and use this to create an OrderSummary. Store
-	// the completed order in the internal OrderStore.
-	order_id := uuid.NewV4().String()
-	complete_order := OrderSummary{order_id, cart_with_costs, running_total}
-	svc.order_store[order_id] = complete_order
+	remainder := discount
+	for _, i := range lines {
+		// The product of the discount and the line's quantity can overflow
+		// an int, so it is calculated in 128 bits. The quotient is at most
+		// the discount so it always fits.
+		hi, lo := bits.Mul64(uint64(discount), uint64(cart_with_costs[i].Quantity))
+		share, _ := bits.Div64(hi, lo, uint64(quantity))
+		cart_with_costs[i].Discount = int(share)
+		remainder -= int(share)
+	}
 
-	return complete_order, nil
+	for _, i := range lines {
+		if remainder == 0 {
+			break
+		}
+		line := cart_with_costs[i]
+		if line.Discount < line.Cost*line.Quantity {
+			cart_with_costs[i].Discount++
+			remainder--
+		}
+	}
 }
 
 func (svc orderService) GetSingleOrder(
+	ctx context.Context,
 	req GetSingleOrderRequest,
-) (OrderSummary, error) {
+) (_ OrderSummary, err error) {
+	_, span := svc.tracer.Start(
+		ctx, "orders.GetSingleOrder",
+		trace.WithAttributes(attribute.String("order.id", req.OrderID)),
+	)
+	defer func() { endSpan(span, err) }()
+
+	if err := contextError(ctx); err != nil {
+		return OrderSummary{}, err
+	}
+
 	// Validate the input.
 	if err := req.Validate(); err != nil {
 		return OrderSummary{}, ErrInvalidRequest
 	}
 
 	// Check if order_id exists in order store.
+	svc.mu.RLock()
+	order, ok := svc.order_store[req.OrderID]
+	svc.mu.RUnlock()
+	if !ok {
+		return OrderSummary{}, ErrOrderNotFound
+	}
+
+	return order, nil
+}
+
+func (svc orderService) CancelOrder(
+	ctx context.Context,
+	req CancelOrderRequest,
+) (_ OrderSummary, err error) {
+	ctx, span := svc.tracer.Start(
+		ctx, "orders.CancelOrder",
+		trace.WithAttributes(attribute.String("order.id", req.OrderID)),
+	)
+	defer func() { endSpan(span, err) }()
+
+	if err := contextError(ctx); err != nil {
+		return OrderSummary{}, err
+	}
+
+	// Validate the input.
+	if err := req.Validate(); err != nil {
+		return OrderSummary{}, ErrInvalidRequest
+	}
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
 	order, ok := svc.order_store[req.OrderID]
 	if !ok {
 		return OrderSummary{}, ErrOrderNotFound
 	}
+	if order.Status == OrderCancelled {
+		return OrderSummary{}, ErrOrderCancelled
+	}
+
+	order.Status = OrderCancelled
+	order.UpdatedAt = svc.now()
+	if err := svc.record(orderEvent(EventOrderCancelled, order)); err != nil {
+		return OrderSummary{}, err
+	}
+	svc.order_store[order.OrderID] = order
+
+	svc.logger.InfoContext(ctx, "order cancelled", "order_id", order.OrderID)
 
 	return order, nil
 }
 
-func (svc orderService) GetAllOrders() AllOrders {
+func (svc orderService) GetAllOrders(
+	ctx context.Context,
+) (_ AllOrders, err error) {
+	_, span := svc.tracer.Start(ctx, "orders.GetAllOrders")
+	defer func() { endSpan(span, err) }()
+
+	return svc.filterOrders(ctx, func(OrderSummary) bool { return true })
+}
+
+func (svc orderService) GetOrdersInRange(
+	ctx context.Context,
+	req OrdersInRangeRequest,
+) (_ AllOrders, err error) {
+	_, span := svc.tracer.Start(ctx, "orders.GetOrdersInRange")
+	defer func() { endSpan(span, err) }()
+
+	// Validate the input.
+	if err := req.Validate(); err != nil {
+		return AllOrders{}, ErrInvalidRequest
+	}
+
+	// The range includes orders created at From but excludes those created
+	// at To.
+	return svc.filterOrders(ctx, func(order OrderSummary) bool {
+		return !order.CreatedAt.Before(req.From) && order.CreatedAt.Before(req.To)
+	})
+}
+
+// filterOrders returns all orders in the OrderStore for which keep returns
+// true, oldest first.
+func (svc orderService) filterOrders(
+	ctx context.Context,
+	keep func(OrderSummary) bool,
+) (AllOrders, error) {
+	if err := contextError(ctx); err != nil {
+		return AllOrders{}, err
+	}
+
 	var all_orders []OrderSummary
 
-	// Iterate through the internal OrderStore and append all orders to a slice
-	// of order summaries.
+	// Iterate through the internal OrderStore and append the kept orders to
+	// a slice of order summaries.
+	svc.mu.RLock()
 	for _, order := range svc.order_store {
-		all_orders = append(all_orders, order)
+		if keep(order) {
+			all_orders = append(all_orders, order)
+		}
 	}
+	svc.mu.RUnlock()
 
 	if len(all_orders) == 0 {
-		return AllOrders{}
+		return AllOrders{}, nil
+	}
+
+	// Map iteration order is random, sort by creation time so the result is
+	// stable between calls. Orders created at the same time are sorted by
+	// their order_id.
+	sort.Slice(all_orders, func(i, j int) bool {
+		a, b := all_orders[i], all_orders[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.OrderID < b.OrderID
+	})
+
+	return AllOrders{all_orders}, nil
+}
+
+func (svc orderService) GetCatalog(
+	ctx context.Context,
+) (_ Catalog, err error) {
+	_, span := svc.tracer.Start(ctx, "orders.GetCatalog")
+	defer func() { endSpan(span, err) }()
+
+	if err := contextError(ctx); err != nil {
+		return Catalog{}, err
+	}
+
+	svc.mu.RLock()
+	defer svc.mu.RUnlock()
+
+	// Items are listed with their current cost, items whose first price is
+	// still scheduled cannot be ordered yet.
+	now := svc.now()
+	items := make([]CatalogItem, 0, len(svc.item_store))
+	for sku, item := range svc.item_store {
+		if cost, ok := item.CostAt(now); ok {
+			items = append(items, item.toCatalogItem(sku, cost))
+		}
+	}
+
+	// Map iteration order is random, sort so the catalog is stable between
+	// calls.
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].ItemName != items[j].ItemName {
+			return items[i].ItemName < items[j].ItemName
+		}
+		return items[i].SKU < items[j].SKU
+	})
+
+	return Catalog{items}, nil
+}
+
+func (svc orderService) SetItemPrice(
+	ctx context.Context,
+	req SetItemPriceRequest,
+) (_ CatalogItem, err error) {
+	ctx, span := svc.tracer.Start(ctx, "orders.SetItemPrice")
+	defer func() { endSpan(span, err) }()
+
+	if err := contextError(ctx); err != nil {
+		return CatalogItem{}, err
+	}
+
+	if err := req.Validate(); err != nil {
+		return CatalogItem{}, ErrInvalidRequest
+	}
+
+	// Costs apply immediately unless scheduled, past prices cannot be
+	// changed as orders may have been priced with them.
+	now := svc.now()
+	effective_from := now
+	if req.EffectiveFrom != nil {
+		if req.EffectiveFrom.Before(now) {
+			return CatalogItem{}, fmt.Errorf(
+				"%w: effective_from is in the past", ErrInvalidRequest,
+			)
+		}
+		effective_from = req.EffectiveFrom.UTC()
 	}
 
-	return AllOrders{all_orders}
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	// Find the item to change, if there is none a new item is added.
+	sku, item, ok := svc.item_store.Find(req.SKU, req.ItemName)
+	if !ok {
+		if req.ItemName == "" {
+			return CatalogItem{}, fmt.Errorf(
+				"%w: item_name is required to add an item", ErrInvalidRequest,
+			)
+		}
+		if sku == "" {
+			sku = svc.item_store.newSKU(req.ItemName)
+		}
+	}
+
+	// An item found by name keeps its name, so that changing the cost of
+	// "apples" does not rename "Apples".
+	if req.ItemName != "" && (!ok || req.SKU != "") {
+		item.Name = strings.TrimSpace(req.ItemName)
+	}
+	if req.Aliases != nil {
+		item.Aliases = append([]string{}, req.Aliases...)
+	}
+	previous_cost, had_cost := item.CostAt(effective_from)
+	item.Prices = item.withPrice(ItemPrice{req.Cost, effective_from})
+
+	// Every name must refer to a single item.
+	for _, name := range append([]string{item.Name}, item.Aliases...) {
+		if owner, ok := svc.item_store.nameOwner(name, sku); ok {
+			return CatalogItem{}, fmt.Errorf(
+				"%w: name %q is already used by %s", ErrInvalidRequest, name, owner,
+			)
+		}
+	}
+
+	// Renaming an item or changing its aliases is not a price change.
+	catalog_item := item.toCatalogItem(sku, req.Cost)
+	if !had_cost || previous_cost != req.Cost {
+		event := newEvent(EventPriceChanged, now)
+		event.Item = &catalog_item
+		event.EffectiveFrom = &effective_from
+		if had_cost {
+			event.PreviousCost = &previous_cost
+		}
+		if err := svc.record(event); err != nil {
+			return CatalogItem{}, err
+		}
+	}
+
+	svc.item_store[sku] = item
+
+	svc.logger.InfoContext(
+		ctx, "item price set",
+		"sku", sku, "item_name", item.Name, "cost", req.Cost,
+		"effective_from", effective_from,
+	)
+
+	return catalog_item, nil
+}
+
+func (svc orderService) GetPriceHistory(
+	ctx context.Context,
+	req PriceHistoryRequest,
+) (_ PriceHistory, err error) {
+	_, span := svc.tracer.Start(ctx, "orders.GetPriceHistory")
+	defer func() { endSpan(span, err) }()
+
+	if err := contextError(ctx); err != nil {
+		return PriceHistory{}, err
+	}
+
+	if err := req.Validate(); err != nil {
+		return PriceHistory{}, ErrInvalidRequest
+	}
+
+	svc.mu.RLock()
+	defer svc.mu.RUnlock()
+
+	sku, item, ok := svc.item_store.Find(req.SKU, req.ItemName)
+	if !ok {
+		return PriceHistory{}, ErrItemDoesNotExist
+	}
+
+	now := svc.now()
+	history := PriceHistory{
+		SKU:      sku,
+		ItemName: item.Name,
+		Prices:   make([]PriceVersion, 0, len(item.Prices)),
+	}
+	for _, price := range item.Prices {
+		version := PriceVersion{Cost: price.Cost}
+		if !price.EffectiveFrom.IsZero() {
+			effective_from := price.EffectiveFrom
+			version.EffectiveFrom = &effective_from
+			version.Scheduled = effective_from.After(now)
+		}
+		history.Prices = append(history.Prices, version)
+	}
+
+	return history, nil
+}
+
+func (svc orderService) RemoveItem(
+	ctx context.Context,
+	req RemoveItemRequest,
+) (err error) {
+	ctx, span := svc.tracer.Start(ctx, "orders.RemoveItem")
+	defer func() { endSpan(span, err) }()
+
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	if err := req.Validate(); err != nil {
+		return ErrInvalidRequest
+	}
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	sku, item, ok := svc.item_store.Find(req.SKU, req.ItemName)
+	if !ok {
+		return ErrItemDoesNotExist
+	}
+
+	now := svc.now()
+	cost, _ := item.CostAt(now)
+	catalog_item := item.toCatalogItem(sku, cost)
+	event := newEvent(EventItemRemoved, now)
+	event.Item = &catalog_item
+	if err := svc.record(event); err != nil {
+		return err
+	}
+	delete(svc.item_store, sku)
+
+	svc.logger.InfoContext(
+		ctx, "item removed", "sku", sku, "item_name", item.Name,
+	)
+
+	return nil
+}
+
+func (svc orderService) ImportOrders(
+	ctx context.Context,
+	orders []OrderSummary,
+) (_ ImportOrdersResponse, err error) {
+	ctx, span := svc.tracer.Start(
+		ctx, "orders.ImportOrders",
+		trace.WithAttributes(attribute.Int("import.orders", len(orders))),
+	)
+	defer func() { endSpan(span, err) }()
+
+	// Validate every order up front so that a bad order part way through the
+	// import does not leave the store partially loaded.
+	seen := make(map[string]bool, len(orders))
+	for _, order := range orders {
+		if err := order.Validate(); err != nil {
+			return ImportOrdersResponse{}, fmt.Errorf(
+				"%w: order %q: %v", ErrInvalidRequest, order.OrderID, err,
+			)
+		}
+		if err := checkOrderTotal(order); err != nil {
+			return ImportOrdersResponse{}, fmt.Errorf(
+				"%w: order %q: %v", ErrInvalidRequest, order.OrderID, err,
+			)
+		}
+		if seen[order.OrderID] {
+			return ImportOrdersResponse{}, fmt.Errorf(
+				"%w: order %q appears more than once", ErrInvalidRequest, order.OrderID,
+			)
+		}
+		seen[order.OrderID] = true
+	}
+
+	if err := contextError(ctx); err != nil {
+		return ImportOrdersResponse{}, err
+	}
+
+	imported_at := svc.now()
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	for _, order := range orders {
+		if _, ok := svc.order_store[order.OrderID]; ok {
+			return ImportOrdersResponse{}, fmt.Errorf(
+				"%w: %s", ErrOrderExists, order.OrderID,
+			)
+		}
+	}
+	imported := make([]OrderSummary, len(orders))
+	events := make([]Event, len(orders))
+	for i, order := range orders {
+		// Orders exported before timestamps were recorded have none, these
+		// are treated as created at the time of import.
+		if order.CreatedAt.IsZero() {
+			order.CreatedAt = imported_at
+		}
+		if order.UpdatedAt.IsZero() {
+			order.UpdatedAt = order.CreatedAt
+		}
+		if order.Status == "" {
+			order.Status = OrderPlaced
+		}
+		imported[i] = order
+
+		// The event occurred at the import, the order was last updated
+		// before it.
+		events[i] = newEvent(EventOrderImported, imported_at)
+		events[i].Order = &imported[i]
+	}
+
+	// The events are recorded together, either every order is imported or
+	// none are.
+	if err := svc.record(events...); err != nil {
+		return ImportOrdersResponse{}, err
+	}
+	for _, order := range imported {
+		svc.order_store[order.OrderID] = order
+	}
+
+	svc.logger.InfoContext(ctx, "orders imported", "imported", len(orders))
+
+	return ImportOrdersResponse{Imported: len(orders)}, nil
+}
+
+// checkOrderTotal verifies that the total cost of an order is equal to the sum
+// of its lines after discounts.
+func checkOrderTotal(order OrderSummary) error {
+	var running_total int = 0
+
+	for _, item := range order.Summary {
+		line_total, ok := overflow.Mul(item.Cost, item.Quantity)
+		if !ok {
+			return ErrIntegerOverflow
+		}
+		running_total, ok = overflow.Add(running_total, line_total-item.Discount)
+		if !ok {
+			return ErrIntegerOverflow
+		}
+	}
+
+	if running_total != order.TotalCost {
+		return fmt.Errorf(
+			"total_cost %d does not match the sum of its lines %d",
+			order.TotalCost, running_total,
+		)
+	}
+	return nil
+}
+
+func (svc orderService) Stats(ctx context.Context) (StoreStats, error) {
+	if err := contextError(ctx); err != nil {
+		return StoreStats{}, err
+	}
+
+	svc.mu.RLock()
+	defer svc.mu.RUnlock()
+
+	return StoreStats{
+		Orders: len(svc.order_store),
+		Items:  len(svc.item_store),
+	}, nil
+}
+
+// contextError returns an error wrapping both ErrRequestCancelled and the
+// context's error if the context is done, otherwise nil.
+func contextError(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%w: %w", ErrRequestCancelled, err)
+	}
+	return nil
 }
@@ -92,11 +92,11 @@ func TestMalformedOrderRequestHTTP(t *testing.T) {
 		name     string
 		testCase OrderRequest
 	}{
-		{"empty cart", OrderRequest{badRequestEmptyCart}},
-		{"empty item name", OrderRequest{badRequestEmptyItemName}},
-		{"item not found", OrderRequest{badRequestItemNotFound}},
-		{"negative quantity requested", OrderRequest{badRequestNegativeQuantitiy}},
-		{"cannot process price", OrderRequest{badRequestCannotProcessPrice}},
+		{"empty cart", OrderRequest{Cart: badRequestEmptyCart}},
+		{"empty item name", OrderRequest{Cart: badRequestEmptyItemName}},
+		{"item not found", OrderRequest{Cart: badRequestItemNotFound}},
+		{"negative quantity requested", OrderRequest{Cart: badRequestNegativeQuantitiy}},
+		{"cannot process price", OrderRequest{Cart: badRequestCannotProcessPrice}},
 	}
 
 	// Iterate through testcases and perform the request
@@ -254,3 +254,99 @@ func TestEmptyGetAllOrdersRequest(t *testing.T) {
 	// Verify that the store is indeed empty.
 	require.Empty(t, all_orders.Orders, "store should be empty")
 }
+
+func TestLineDiscountsInSummary(t *testing.T) {
+	summary, err := service.Quote(context.Background(), goodOrderRequest)
+	require.NoError(t, err)
+
+	// 2 x Apples has one apple free, 3 x Oranges has one orange free.
+	require.Equal(t, 60, summary.Summary[0].Discount, "incorrect apples discount")
+	require.Equal(t, 25, summary.Summary[1].Discount, "incorrect oranges discount")
+}
+
+func TestDuplicateLinesMerged(t *testing.T) {
+	// Two lines of one apple are buy one get one free, just like a single
+	// line of two apples.
+	summary, err := service.Quote(context.Background(), OrderRequest{
+		Cart: []Item{
+			{ItemName: "Apples", Quantity: 1},
+			{ItemName: "Oranges", Quantity: 1},
+			{ItemName: "Apples", Quantity: 1},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 85, summary.TotalCost)
+
+	// The lines are kept as submitted, each with its share of the discount.
+	require.Equal(t, []ItemWithCost{
+		{"Apples", 1, 60, 30, "FRUIT-001", DefaultPriceList},
+		{"Oranges", 1, 25, 0, "FRUIT-002", DefaultPriceList},
+		{"Apples", 1, 60, 30, "FRUIT-001", DefaultPriceList},
+	}, summary.Summary)
+
+	// Rounding remainders are never lost.
+	summary, err = service.Quote(context.Background(), OrderRequest{
+		Cart: []Item{
+			{ItemName: "Oranges", Quantity: 1},
+			{ItemName: "Oranges", Quantity: 1},
+			{ItemName: "Oranges", Quantity: 1},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 50, summary.TotalCost)
+	discount := 0
+	for _, item := range summary.Summary {
+		discount += item.Discount
+	}
+	require.Equal(t, 25, discount)
+}
+
+func TestDuplicateLinesRejected(t *testing.T) {
+	items, discounts, orders := NewStore()
+	svc := New(items, discounts, orders, WithDuplicateLines(RejectDuplicateLines))
+
+	_, err := svc.SimpleSummary(context.Background(), OrderRequest{
+		Cart: []Item{
+			{ItemName: "Apples", Quantity: 1},
+			{SKU: "FRUIT-001", Quantity: 1},
+		},
+	})
+	require.ErrorIs(t, err, ErrInvalidRequest)
+	require.Contains(t, err.Error(), `"Apples"`)
+	require.Empty(t, orders)
+
+	_, err = svc.SimpleSummary(context.Background(), goodOrderRequest)
+	require.NoError(t, err)
+}
+
+func TestCancelOrderRequest(t *testing.T) {
+	summary, err := service.SimpleSummary(context.Background(), goodOrderRequest)
+	require.NoError(t, err)
+	require.Equal(t, OrderPlaced, summary.Status)
+
+	cancel := func(order_id string) *httptest.ResponseRecorder {
+		JSON, err := json.Marshal(CancelOrderRequest{order_id})
+		require.NoError(t, err)
+		request := httptest.NewRequest("POST", "/cancel-order", bytes.NewReader(JSON))
+		request.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, request)
+		return rec
+	}
+
+	rec := cancel(summary.OrderID)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var cancelled OrderSummary
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&cancelled))
+	require.Equal(t, OrderCancelled, cancelled.Status)
+	require.Equal(t, summary.TotalCost, cancelled.TotalCost)
+
+	// The cancelled order is kept in the store.
+	stored, err := service.GetSingleOrder(context.Background(), GetSingleOrderRequest{summary.OrderID})
+	require.NoError(t, err)
+	require.Equal(t, cancelled, stored)
+
+	require.Equal(t, http.StatusConflict, cancel(summary.OrderID).Code)
+	require.Equal(t, http.StatusNotFound, cancel(uuid.NewV4().String()).Code)
+	require.Equal(t, http.StatusBadRequest, cancel("this is not an id").Code)
+}
@@ -1,13 +1,75 @@
 package aetest
 
+import (
+	"sort"
+	"time"
+)
+
 // OrderStore is a `map[string]OrderSummary` that stores as key the order_id of
 // type UUID version 4 string and the value of OrderSummary. This is used to
 // lookup orders based on a supplied order_id.
 type OrderStore map[string]OrderSummary
 
-// ItemStore is a `map[string]int` that stores as a key the item name with a
-// value of the cost of the item.
-type ItemStore map[string]int
+// ItemStore is a `map[string]StoreItem` that stores as a key the item's SKU
+// with a value of the item's names and cost. SKUs are stored in upper case.
+type ItemStore map[string]StoreItem
+
+// StoreItem is an item that can be ordered. Orders may refer to the item by
+// its SKU, its Name or any of its Aliases, names are matched ignoring case and
+// extra whitespace.
+//
+// Prices are every cost the item has had or is scheduled to have, ordered by
+// the time they take effect. Old prices are kept so that the cost of past
+// orders can be explained.
+type StoreItem struct {
+	Name    string
+	Aliases []string
+	Prices  []ItemPrice
+}
+
+// ItemPrice is a cost of an item, applying from EffectiveFrom until the
+// next price takes effect. A price with a zero EffectiveFrom has always
+// applied.
+type ItemPrice struct {
+	Cost          int
+	EffectiveFrom time.Time
+}
+
+// CostAt returns the cost of the item at the given time, false if the item
+// has no price yet.
+func (item StoreItem) CostAt(at time.Time) (int, bool) {
+	// The first price taking effect after the time, the price before it is
+	// the one that applies.
+	i := sort.Search(len(item.Prices), func(i int) bool {
+		return item.Prices[i].EffectiveFrom.After(at)
+	})
+	if i == 0 {
+		return 0, false
+	}
+	return item.Prices[i-1].Cost, true
+}
+
+// withPrice returns the item's prices with the price added. A price taking
+// effect at the same time as an existing one replaces it. The item's own
+// prices are left untouched as they may be shared with the stored item.
+func (item StoreItem) withPrice(price ItemPrice) []ItemPrice {
+	prices := make([]ItemPrice, 0, len(item.Prices)+1)
+	added := false
+	for _, existing := range item.Prices {
+		switch {
+		case existing.EffectiveFrom.Equal(price.EffectiveFrom):
+			continue
+		case !added && existing.EffectiveFrom.After(price.EffectiveFrom):
+			prices = append(prices, price)
+			added = true
+		}
+		prices = append(prices, existing)
+	}
+	if !added {
+		prices = append(prices, price)
+	}
+	return prices
+}
 
 // Discount is a function that takes as input the item cost and the quantity
 // that is submitted for order and returns the discount to subtract from the
@@ -15,16 +77,16 @@ type ItemStore map[string]int
 type DiscountFunction func(cost int, quantity int) int
 
 // ItemDiscount is a `map[string]DiscountFunction that stores as key the item
-// name with a value of a function that calculates the discount of the item.
+// SKU with a value of a function that calculates the discount of the item.
 // This is used to lookup the item and apply a relevant discount to it.
 type ItemDiscount map[string]DiscountFunction
 
 // NewStore creates an `ItemStore` and populates the key and values of the
-// store with required item names and costs respectively.
+// store with required item SKUs, names and costs respectively.
 func NewStore() (ItemStore, ItemDiscount, OrderStore) {
 	item_store := make(ItemStore)
-	item_store["Apples"] = 60
-	item_store["Oranges"] = 25
+	item_store["FRUIT-001"] = StoreItem{"Apples", []string{"Apple"}, []ItemPrice{{Cost: 60}}}
+	item_store["FRUIT-002"] = StoreItem{"Oranges", []string{"Orange"}, []ItemPrice{{Cost: 25}}}
 
 	// Apples are buy one get one free
 	var applesDiscount DiscountFunction = func(cost int, quantity int) int {
@@ -56,8 +118,8 @@ func NewStore() (ItemStore, ItemDiscount, OrderStore) {
 
 	// Add discount to discount lookup
 	discount := make(ItemDiscount)
-	discount["Apples"] = applesDiscount
-	discount["Oranges"] = orangesDiscount
+	discount["FRUIT-001"] = applesDiscount
+	discount["FRUIT-002"] = orangesDiscount
 
 	// Create an empty OrderStore for storing future successful orders.
 	order_store := make(OrderStore)
@@ -0,0 +1,86 @@
+package aetest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a Clock that returns a fixed time which is moved forward
+// manually by the tests.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{time.Date(2022, time.March, 1, 9, 0, 0, 0, time.UTC)}
+}
+
+func TestOrdersAreTimestamped(t *testing.T) {
+	clock := newFakeClock()
+	items, discounts, _ := NewStore()
+	svc := New(items, discounts, make(OrderStore), WithClock(clock.Now))
+
+	summary, err := svc.SimpleSummary(context.Background(), goodOrderRequest)
+	require.NoError(t, err)
+	require.Equal(t, clock.Now(), summary.CreatedAt)
+	require.Equal(t, clock.Now(), summary.UpdatedAt)
+
+	stored, err := svc.GetSingleOrder(
+		context.Background(),
+		GetSingleOrderRequest{summary.OrderID},
+	)
+	require.NoError(t, err)
+	require.Equal(t, clock.Now(), stored.CreatedAt)
+}
+
+func TestGetOrdersInRange(t *testing.T) {
+	clock := newFakeClock()
+	items, discounts, _ := NewStore()
+	router := NewOrdersRouter(
+		New(items, discounts, make(OrderStore), WithClock(clock.Now)),
+	)
+
+	// Place one order every hour from 09:00 to 13:00.
+	var placed []OrderSummary
+	for i := 0; i < 5; i++ {
+		response := postJSON(t, router, "/submit-order", goodOrderRequest)
+		var summary OrderSummary
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&summary))
+		placed = append(placed, summary)
+		clock.Advance(time.Hour)
+	}
+
+	// 10:00 up to but excluding 12:00 matches the orders at 10:00 and 11:00.
+	start := placed[0].CreatedAt
+	response := postJSON(t, router, "/get-orders-in-range", OrdersInRangeRequest{
+		From: start.Add(time.Hour),
+		To:   start.Add(3 * time.Hour),
+	})
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	var in_range AllOrders
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&in_range))
+	require.Len(t, in_range.Orders, 2)
+	require.Equal(t, placed[1].OrderID, in_range.Orders[0].OrderID)
+	require.Equal(t, placed[2].OrderID, in_range.Orders[1].OrderID)
+
+	// A range that ends before it starts is rejected.
+	response = postJSON(t, router, "/get-orders-in-range", OrdersInRangeRequest{
+		From: start.Add(time.Hour),
+		To:   start,
+	})
+	require.Equal(t, http.StatusBadRequest, response.StatusCode)
+}
@@ -0,0 +1,157 @@
+package aetest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation name of all spans created by this
+// package.
+const tracerName = "aetest"
+
+// TracingConfig configures the exporter of the TracerProvider created by
+// NewTracerProvider.
+type TracingConfig struct {
+	// Exporter is one of "none", "stdout" or "otlp".
+	Exporter string
+
+	// ServiceName is recorded as the `service.name` of every span.
+	ServiceName string
+
+	// OTLPEndpoint is the host:port of the OTLP gRPC collector, used when
+	// Exporter is "otlp".
+	OTLPEndpoint string
+
+	// OTLPInsecure disables TLS to the OTLP collector.
+	OTLPInsecure bool
+
+	// Writer receives the spans when Exporter is "stdout", defaulting to
+	// os.Stdout.
+	Writer io.Writer
+}
+
+// NewTracerProvider creates a TracerProvider that exports spans as configured.
+// When the exporter is "none" spans are created but never exported. The
+// caller must call Shutdown on the provider to flush any buffered spans.
+func NewTracerProvider(
+	ctx context.Context,
+	config TracingConfig,
+) (*sdktrace.TracerProvider, error) {
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewSchemaless(attribute.String("service.name", config.ServiceName)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	opts := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+
+	switch config.Exporter {
+	case "", "none":
+	case "stdout":
+		writer := config.Writer
+		if writer == nil {
+			writer = os.Stdout
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(writer))
+		if err != nil {
+			return nil, err
+		}
+		// Spans are written as soon as they end so that local runs show
+		// them immediately.
+		opts = append(opts, sdktrace.WithSyncer(exporter))
+	case "otlp":
+		client_opts := []otlptracegrpc.Option{
+			otlptracegrpc.WithEndpoint(config.OTLPEndpoint),
+		}
+		if config.OTLPInsecure {
+			client_opts = append(client_opts, otlptracegrpc.WithInsecure())
+		}
+		exporter, err := otlptracegrpc.New(ctx, client_opts...)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q, expected none, stdout or otlp", config.Exporter)
+	}
+
+	return sdktrace.NewTracerProvider(opts...), nil
+}
+
+// WithTracerProvider sets the TracerProvider used to create the Service'
+// spans. By default the global TracerProvider is used.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(svc *orderService) {
+		svc.tracer = tp.Tracer(tracerName)
+	}
+}
+
+// WithTracing creates a server span for every request, continuing any trace
+// propagated by the caller in the W3C `traceparent` header. The span is
+// carried by the request's context into the Service so that the Service'
+// spans are its children.
+func WithTracing(tp trace.TracerProvider) RouterOption {
+	tracer := tp.Tracer(tracerName)
+	propagator := propagation.TraceContext{}
+
+	return func(router *gin.Engine) {
+		router.Use(func(c *gin.Context) {
+			ctx := propagator.Extract(
+				c.Request.Context(),
+				propagation.HeaderCarrier(c.Request.Header),
+			)
+
+			route := c.FullPath()
+			if route == "" {
+				route = "unmatched"
+			}
+			ctx, span := tracer.Start(
+				ctx, c.Request.Method+" "+route,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					attribute.String("http.request.method", c.Request.Method),
+					attribute.String("http.route", route),
+				),
+			)
+			defer span.End()
+
+			c.Request = c.Request.WithContext(ctx)
+			c.Next()
+
+			status := c.Writer.Status()
+			span.SetAttributes(attribute.Int("http.response.status_code", status))
+			if status >= 500 {
+				span.SetStatus(codes.Error, fmt.Sprintf("status %d", status))
+			}
+		})
+	}
+}
+
+// defaultTracer is the Service' tracer when no TracerProvider is supplied, it
+// delegates to whichever global TracerProvider is set.
+func defaultTracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// endSpan records err, if any, on the span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,92 @@
+package aetest
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// newRecordingTracerProvider returns a TracerProvider that records every
+// ended span in the returned SpanRecorder.
+func newRecordingTracerProvider() (*sdktrace.TracerProvider, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	return sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)), recorder
+}
+
+func TestSubmitOrderSpans(t *testing.T) {
+	tp, recorder := newRecordingTracerProvider()
+	items, discounts, _ := NewStore()
+	svc := New(items, discounts, make(OrderStore), WithTracerProvider(tp))
+	router := NewOrdersRouter(svc, WithTracing(tp))
+
+	postJSON(t, router, "/submit-order", goodOrderRequest)
+
+	spans := recorder.Ended()
+	names := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range spans {
+		names[span.Name()] = span
+	}
+
+	// Each phase of the submission is a child of the service span, which is
+	// a child of the request span.
+	request := names["POST /submit-order"]
+	require.NotNil(t, request, "missing request span")
+	service := names["orders.SimpleSummary"]
+	require.NotNil(t, service, "missing service span")
+	require.Equal(t, request.SpanContext().SpanID(), service.Parent().SpanID())
+
+	for _, phase := range []string{
+		"orders.validate", "orders.inject_cost", "orders.discount", "orders.store",
+	} {
+		span := names[phase]
+		require.NotNilf(t, span, "missing %s span", phase)
+		require.Equal(t, service.SpanContext().SpanID(), span.Parent().SpanID())
+		require.Equal(t, request.SpanContext().TraceID(), span.SpanContext().TraceID())
+	}
+}
+
+func TestFailedPhaseIsRecorded(t *testing.T) {
+	tp, recorder := newRecordingTracerProvider()
+	items, discounts, _ := NewStore()
+	svc := New(items, discounts, make(OrderStore), WithTracerProvider(tp))
+
+	_, err := svc.SimpleSummary(context.Background(), OrderRequest{
+		Cart: []Item{{ItemName: "Magazine", Quantity: 1}},
+	})
+	require.ErrorIs(t, err, ErrItemDoesNotExist)
+
+	var failed []string
+	for _, span := range recorder.Ended() {
+		if span.Status().Code == codes.Error {
+			failed = append(failed, span.Name())
+		}
+	}
+	require.ElementsMatch(t, []string{"orders.inject_cost", "orders.SimpleSummary"}, failed)
+}
+
+func TestStdoutTracerProvider(t *testing.T) {
+	var buf bytes.Buffer
+	tp, err := NewTracerProvider(context.Background(), TracingConfig{
+		Exporter:    "stdout",
+		ServiceName: "aetest-test",
+		Writer:      &buf,
+	})
+	require.NoError(t, err)
+
+	items, discounts, _ := NewStore()
+	svc := New(items, discounts, make(OrderStore), WithTracerProvider(tp))
+	_, err = svc.Quote(context.Background(), goodOrderRequest)
+	require.NoError(t, err)
+	require.NoError(t, tp.Shutdown(context.Background()))
+
+	require.Contains(t, buf.String(), `"Name":"orders.Quote"`)
+	require.Contains(t, buf.String(), "aetest-test")
+
+	_, err = NewTracerProvider(context.Background(), TracingConfig{Exporter: "zipkin"})
+	require.Error(t, err)
+}
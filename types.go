@@ -1,8 +1,27 @@
 package aetest
 
-// OrderRequest are required values for an order submission.
+import "time"
+
+// OrderRequest are required values for an order submission. Email is
+// optional, when set a confirmation is sent to it once the order is placed.
+// It is not stored with the order, the order.created event holds it in the
+// outbox until the event is relayed and only the EmailNotifier is sent it.
+//
+// CustomerGroup and Channel are optional, they select the PriceList the order
+// is priced from, e.g. "trade" customers or orders taken at the "pos" tills.
 type OrderRequest struct {
-	Cart []Item `json:"cart"`
+	Cart          []Item `json:"cart"`
+	Email         string `json:"email,omitempty"`
+	Channel       string `json:"channel,omitempty"`
+	CustomerGroup string `json:"customer_group,omitempty"`
+}
+
+// OrdersInRangeRequest are required values for retrieving all orders created
+// within a time range. Orders created at From are included, orders created at
+// To are not. Times are serialized in RFC 3339 format.
+type OrdersInRangeRequest struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
 }
 
 // GetSingleOrderRequest are required values for retrieving a single stored
@@ -16,24 +35,150 @@ type GetSingleOrderRequest struct {
 // overflows for `uint`.
 
 // Items are details regaring the name and quantity of items submitted for an
-// order.
+// order. An item is referred to either by its SKU or by its name, names match
+// any of the item's names or aliases ignoring case and extra whitespace. When
+// both are given the SKU is used.
 type Item struct {
-	ItemName string `json:"item_name"`
+	ItemName string `json:"item_name,omitempty"`
 	Quantity int    `json:"quantity"`
+	SKU      string `json:"sku,omitempty"`
 }
 
-// ItemsWithCost are `Items` with the items respective cost included.
+// ItemsWithCost are `Items` with the items respective cost included. Discount
+// is the total amount taken off this line by any offer on the item. ItemName
+// is the item's name in the catalog, whichever name or SKU was ordered.
+// PriceList is the name of the price list the cost was taken from,
+// DefaultPriceList for the catalog's own price.
 type ItemWithCost struct {
-	ItemName string `json:"item_name"`
-	Quantity int    `json:"quantity"`
-	Cost     int    `json:"cost"`
+	ItemName  string `json:"item_name"`
+	Quantity  int    `json:"quantity"`
+	Cost      int    `json:"cost"`
+	Discount  int    `json:"discount"`
+	SKU       string `json:"sku,omitempty"`
+	PriceList string `json:"price_list,omitempty"`
 }
 
-// Summary is the response to the call to the orders API.
+// Summary is the response to the call to the orders API. CreatedAt is the
+// time the order was priced and UpdatedAt the time it was last modified.
+// Status is OrderPlaced or OrderCancelled, quotes have no status.
+// DiscountPolicy is the policy the discounts of the order were chosen by.
 type OrderSummary struct {
-	OrderID   string         `json:"order_id"`
-	Summary   []ItemWithCost `json:"summary"`
-	TotalCost int            `json:"total_cost"`
+	OrderID        string         `json:"order_id"`
+	Summary        []ItemWithCost `json:"summary"`
+	TotalCost      int            `json:"total_cost"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	Status         string         `json:"status,omitempty"`
+	DiscountPolicy DiscountPolicy `json:"discount_policy,omitempty"`
+}
+
+// The statuses of a stored order. Orders are placed when submitted and may
+// later be cancelled, a cancelled order cannot be placed again.
+const (
+	OrderPlaced    = "placed"
+	OrderCancelled = "cancelled"
+)
+
+// CancelOrderRequest are required values for cancelling a stored order. The
+// OrderID must be of type uuid.
+type CancelOrderRequest struct {
+	OrderID string `json:"order_id"`
+}
+
+// SetItemPriceRequest are required values for adding an item to the catalog
+// or changing an existing item.
+//
+// When SKU is set the item with that SKU is changed, or added if there is
+// none, a non-empty ItemName renames the item. Otherwise the item named
+// ItemName is changed, or added with a SKU derived from its name if there is
+// none. Aliases, when not null, replace the item's aliases.
+//
+// The cost applies from EffectiveFrom, which must not be in the past, or
+// immediately when it is not set. Names and aliases always change
+// immediately. A cost scheduled for the same time as an earlier scheduled
+// change replaces it.
+type SetItemPriceRequest struct {
+	SKU           string     `json:"sku,omitempty"`
+	ItemName      string     `json:"item_name,omitempty"`
+	Aliases       []string   `json:"aliases,omitempty"`
+	Cost          int        `json:"cost"`
+	EffectiveFrom *time.Time `json:"effective_from,omitempty"`
+}
+
+// RemoveItemRequest are required values for removing an item from the
+// catalog, the item is referred to by either its SKU or its name.
+type RemoveItemRequest struct {
+	SKU      string `json:"sku,omitempty"`
+	ItemName string `json:"item_name,omitempty"`
+}
+
+// CatalogItem is an item that can be ordered along with its cost.
+type CatalogItem struct {
+	SKU      string   `json:"sku"`
+	ItemName string   `json:"item_name"`
+	Aliases  []string `json:"aliases,omitempty"`
+	Cost     int      `json:"cost"`
+}
+
+// Catalog is the response to the call to get all items that can be ordered.
+type Catalog struct {
+	Items []CatalogItem `json:"items"`
+}
+
+// PriceHistoryRequest are required values for retrieving the prices of an
+// item, the item is referred to by either its SKU or its name.
+type PriceHistoryRequest struct {
+	SKU      string `json:"sku,omitempty"`
+	ItemName string `json:"item_name,omitempty"`
+}
+
+// PriceHistory is the response to the call to get the prices of an item.
+// Prices are ordered by the time they take effect, those taking effect in the
+// future are Scheduled.
+type PriceHistory struct {
+	SKU      string         `json:"sku"`
+	ItemName string         `json:"item_name"`
+	Prices   []PriceVersion `json:"prices"`
+}
+
+// PriceVersion is a cost of an item and the time from which it applies, until
+// the next version. Prices without an EffectiveFrom have always applied.
+type PriceVersion struct {
+	Cost          int        `json:"cost"`
+	EffectiveFrom *time.Time `json:"effective_from,omitempty"`
+	Scheduled     bool       `json:"scheduled,omitempty"`
+}
+
+// ExplainPriceRequest are the values for explaining how an order is priced.
+// The order is priced as it would have been at At, or now when At is not
+// set, so that the cost of a past order can be explained with the prices and
+// promotions of the time.
+type ExplainPriceRequest struct {
+	OrderRequest
+	At *time.Time `json:"at,omitempty"`
+}
+
+// PriceExplanation is the response to the call to explain how an order is
+// priced. Order is the order as it is priced, Steps every step taken to price
+// it in the order they were taken.
+type PriceExplanation struct {
+	Order OrderSummary  `json:"order"`
+	Steps []PricingStep `json:"steps"`
+}
+
+// PricingStep is a step taken to price an order, Kind is one of the Step
+// constants. Lines are the indexes of the cart lines the step is about, SKU
+// the item and Offer the promotion ID or ItemDiscountOffer of a discount.
+// Amount is the cost, discount or total worked out by the step and Applied,
+// for discounts, whether the discount was given. Detail describes the step.
+type PricingStep struct {
+	Kind    string `json:"kind"`
+	Lines   []int  `json:"lines,omitempty"`
+	SKU     string `json:"sku,omitempty"`
+	Offer   string `json:"offer,omitempty"`
+	Amount  int    `json:"amount"`
+	Applied *bool  `json:"applied,omitempty"`
+	Detail  string `json:"detail"`
 }
 
 // AllOrders is the response to the call to get all stored orders.
@@ -43,9 +188,118 @@ type AllOrders struct {
 	Orders []OrderSummary `json:"orders,omitempty"`
 }
 
+// ImportOrdersResponse is the response to the call to import orders.
+type ImportOrdersResponse struct {
+	Imported int `json:"imported"`
+}
+
+// StoreStats are the sizes of the Service' internal stores.
+type StoreStats struct {
+	Orders int `json:"orders"`
+	Items  int `json:"items"`
+}
+
+// HealthStatus is the response to the `/healthz` and `/readyz` probes. Status
+// is "ok" or "unavailable", Checks gives the result of each readiness check.
+type HealthStatus struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// VersionInfo is the response to the call to `/version`.
+type VersionInfo struct {
+	Version        string `json:"version"`
+	Commit         string `json:"commit"`
+	CatalogVersion string `json:"catalog_version"`
+}
+
+// Event is a domain event recorded in the Outbox when an order or the catalog
+// changes. Type is one of the Event constants, Order is set for order events
+// and Item for price changes and removed items. PreviousCost is the item's
+// cost before the change, it is not set for items added to the catalog.
+type Event struct {
+	ID           string        `json:"id"`
+	Type         string        `json:"type"`
+	OccurredAt   time.Time     `json:"occurred_at"`
+	Order        *OrderSummary `json:"order,omitempty"`
+	Item         *CatalogItem  `json:"item,omitempty"`
+	PreviousCost *int          `json:"previous_cost,omitempty"`
+
+	// EffectiveFrom is when the new cost of a price change applies, later
+	// than OccurredAt for scheduled changes.
+	EffectiveFrom *time.Time `json:"effective_from,omitempty"`
+
+	// Email is the address the customer gave with a created order, for the
+	// confirmation. A Relay removes it from the events it relays to every
+	// publisher except the EmailNotifier.
+	Email string `json:"email,omitempty"`
+}
+
+// SubscribeWebhookRequest are required values for subscribing a URL to order
+// events. When Secret is empty one is generated.
+type SubscribeWebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret,omitempty"`
+}
+
+// WebhookSubscription is a URL subscribed to order events. The Secret is only
+// returned when the subscription is created.
+type WebhookSubscription struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookSubscriptions is the response to the call to list the webhook
+// subscriptions.
+type WebhookSubscriptions struct {
+	Subscriptions []WebhookSubscription `json:"subscriptions"`
+}
+
+// RemoveWebhookRequest are required values for removing a webhook
+// subscription.
+type RemoveWebhookRequest struct {
+	ID string `json:"id"`
+}
+
+// WebhookDelivery is a failed delivery of an event to a subscriber, kept so
+// that it can be replayed.
+type WebhookDelivery struct {
+	ID             string    `json:"id"`
+	SubscriptionID string    `json:"subscription_id"`
+	URL            string    `json:"url"`
+	Event          Event     `json:"event"`
+	Attempts       int       `json:"attempts"`
+	LastError      string    `json:"last_error"`
+	FailedAt       time.Time `json:"failed_at"`
+}
+
+// FailedDeliveries is the response to the call to list the dead-lettered
+// webhook deliveries.
+type FailedDeliveries struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
+
+// ReplayDeliveriesRequest are the values for replaying failed webhook
+// deliveries. When DeliveryIDs is empty every failed delivery is replayed.
+type ReplayDeliveriesRequest struct {
+	DeliveryIDs []string `json:"delivery_ids,omitempty"`
+}
+
+// ReplayDeliveriesResponse is the response to the call to replay failed
+// webhook deliveries.
+type ReplayDeliveriesResponse struct {
+	Replayed int `json:"replayed"`
+}
+
 // GenericErrResponse is a generic error result return to the caller after an
 // error is raised from an endpoint. The appropriate error reason should be
-// returned to the caller.
+// returned to the caller. When an order refers to items that do not exist
+// these are listed in UnknownItems.
 type GenericErrResponse struct {
-	Err string `json:"error,omitempty"`
+	Err          string        `json:"error,omitempty"`
+	UnknownItems []UnknownItem `json:"unknown_items,omitempty"`
 }
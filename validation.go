@@ -1,17 +1,39 @@
 package aetest
 
 import (
+	"errors"
+	"fmt"
 	"math"
+	"regexp"
+	"time"
 
 	validation "github.com/go-ozzo/ozzo-validation"
 	"github.com/go-ozzo/ozzo-validation/is"
 )
 
+// skuPattern is the format of a SKU, letters, digits, hyphens and
+// underscores starting with a letter or digit.
+var skuPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+
+// requiredWithout is a rule that requires the value when other is empty, it
+// is used where an item may be referred to by either its SKU or its name.
+func requiredWithout(other string) validation.Rule {
+	return validation.By(func(value interface{}) error {
+		if other != "" {
+			return nil
+		}
+		return validation.Required.Validate(value)
+	})
+}
+
 // Validate the order request from user input.
 func (req OrderRequest) Validate() error {
 	return validation.ValidateStruct(
 		&req,
 		validation.Field(&req.Cart),
+		validation.Field(&req.Email, is.Email),
+		validation.Field(&req.Channel, validation.Length(0, 64)),
+		validation.Field(&req.CustomerGroup, validation.Length(0, 64)),
 	)
 }
 
@@ -19,11 +41,15 @@ func (req OrderRequest) Validate() error {
 func (req Item) Validate() error {
 	return validation.ValidateStruct(
 		&req,
-		// ItemName is a required field `NotNil` means it cannot be the nil
-		// value for the type i.e. the empty string "".
+		// ItemName is a required field unless the item is referred to by its
+		// SKU.
 		validation.Field(
 			&req.ItemName,
-			validation.NotNil,
+			requiredWithout(req.SKU),
+		),
+		validation.Field(
+			&req.SKU,
+			validation.Match(skuPattern),
 		),
 		// ItemName is a required field. Quantity cannot be 0.
 		validation.Field(
@@ -46,3 +72,291 @@ func (req GetSingleOrderRequest) Validate() error {
 		),
 	)
 }
+
+// Validate the request to cancel an order from user input.
+func (req CancelOrderRequest) Validate() error {
+	return validation.ValidateStruct(
+		&req,
+		validation.Field(
+			&req.OrderID,
+			validation.Required,
+			is.UUIDv4,
+		),
+	)
+}
+
+// Validate the request to get orders within a time range from user input.
+func (req OrdersInRangeRequest) Validate() error {
+	return validation.ValidateStruct(
+		&req,
+		validation.Field(
+			&req.From,
+			validation.Required,
+		),
+		// The end of the range must come after the start of the range.
+		validation.Field(
+			&req.To,
+			validation.Required,
+			validation.Min(req.From).Exclusive(),
+		),
+	)
+}
+
+// Validate the request to set the price of a catalog item from user input.
+func (req SetItemPriceRequest) Validate() error {
+	return validation.ValidateStruct(
+		&req,
+		validation.Field(
+			&req.SKU,
+			validation.Match(skuPattern),
+		),
+		// A new item added without a SKU must have a name, its SKU is
+		// derived from the name.
+		validation.Field(
+			&req.ItemName,
+			requiredWithout(req.SKU),
+		),
+		validation.Field(
+			&req.Aliases,
+			validation.Each(validation.Required),
+		),
+		// Cost cannot be negative, a cost of 0 is allowed for free items.
+		validation.Field(
+			&req.Cost,
+			validation.Min(0),
+		),
+	)
+}
+
+// Validate the request to remove a catalog item from user input.
+func (req RemoveItemRequest) Validate() error {
+	return validation.ValidateStruct(
+		&req,
+		validation.Field(
+			&req.SKU,
+			validation.Match(skuPattern),
+		),
+		validation.Field(
+			&req.ItemName,
+			requiredWithout(req.SKU),
+		),
+	)
+}
+
+// Validate the request to get the price history of a catalog item from user
+// input.
+func (req PriceHistoryRequest) Validate() error {
+	return validation.ValidateStruct(
+		&req,
+		validation.Field(
+			&req.SKU,
+			validation.Match(skuPattern),
+		),
+		validation.Field(
+			&req.ItemName,
+			requiredWithout(req.SKU),
+		),
+	)
+}
+
+// Validate a promotion loaded from a file.
+func (p Promotion) Validate() error {
+	// A promotion ending before it starts would never apply.
+	var end_rules []validation.Rule
+	if p.Start != nil {
+		end_rules = append(end_rules, validation.Min(*p.Start).Exclusive())
+	}
+
+	return validation.ValidateStruct(
+		&p,
+		validation.Field(
+			&p.ID,
+			validation.Required,
+		),
+		validation.Field(
+			&p.SKUs,
+			validation.Required,
+			validation.Each(validation.Match(skuPattern)),
+		),
+		// Exactly one kind of discount must be given.
+		validation.Field(
+			&p.PercentOff,
+			validation.Min(0),
+			validation.Max(100),
+			validation.By(func(interface{}) error {
+				kinds := 0
+				for _, given := range []bool{p.PercentOff != 0, p.AmountOff != 0, p.Discount != nil} {
+					if given {
+						kinds++
+					}
+				}
+				if kinds != 1 {
+					return errors.New("exactly one of percent_off, amount_off and discount is required")
+				}
+				return nil
+			}),
+		),
+		validation.Field(
+			&p.AmountOff,
+			validation.Min(0),
+		),
+		validation.Field(
+			&p.MaxOrderDiscount,
+			validation.Min(0),
+		),
+		// Exclusive promotions are never given with other offers, so they
+		// cannot stack.
+		validation.Field(
+			&p.Stackable,
+			validation.By(func(interface{}) error {
+				if p.Exclusive && p.Stackable {
+					return errors.New("an exclusive promotion cannot be stackable")
+				}
+				return nil
+			}),
+		),
+		validation.Field(
+			&p.End,
+			end_rules...,
+		),
+		validation.Field(&p.Windows),
+	)
+}
+
+// Validate a recurring promotion window.
+func (w PromotionWindow) Validate() error {
+	return validation.ValidateStruct(
+		&w,
+		validation.Field(
+			&w.Days,
+			validation.Each(validation.Min(Weekday(time.Sunday)), validation.Max(Weekday(time.Saturday))),
+		),
+		// A window from a time to the same time would be ambiguous, it is
+		// either empty or the whole day.
+		validation.Field(
+			&w.To,
+			validation.NotIn(w.From),
+		),
+	)
+}
+
+// Validate a price list loaded from a file. Fallbacks are checked against the
+// other price lists by ValidatePriceLists.
+func (price_list PriceList) Validate() error {
+	return validation.ValidateStruct(
+		&price_list,
+		// The catalog's prices are the default price list, no other list
+		// may take its name.
+		validation.Field(
+			&price_list.Name,
+			validation.Required,
+			validation.NotIn(DefaultPriceList),
+		),
+		validation.Field(
+			&price_list.CustomerGroups,
+			validation.Each(validation.Required),
+		),
+		validation.Field(
+			&price_list.Channels,
+			validation.Each(validation.Required),
+		),
+		// Costs cannot be negative, and every SKU must be valid.
+		validation.Field(
+			&price_list.Prices,
+			validation.Each(validation.Min(0)),
+			validation.By(func(interface{}) error {
+				for sku := range price_list.Prices {
+					if !skuPattern.MatchString(sku) {
+						return fmt.Errorf("invalid sku %q", sku)
+					}
+				}
+				return nil
+			}),
+		),
+	)
+}
+
+// Validate an imported order. The order total is checked against its lines
+// separately as this requires overflow handling.
+func (order OrderSummary) Validate() error {
+	return validation.ValidateStruct(
+		&order,
+		validation.Field(
+			&order.OrderID,
+			validation.Required,
+			is.UUIDv4,
+		),
+		validation.Field(
+			&order.Summary,
+			validation.Required,
+		),
+		validation.Field(
+			&order.TotalCost,
+			validation.Min(0),
+		),
+		// Orders exported before statuses were recorded have none, these
+		// are imported as placed.
+		validation.Field(
+			&order.Status,
+			validation.In(OrderPlaced, OrderCancelled),
+		),
+		validation.Field(
+			&order.DiscountPolicy,
+			validation.In(DiscountBestForCustomer, DiscountFirstMatch, DiscountAllStackable),
+		),
+	)
+}
+
+// Validate a priced line of an imported order.
+func (item ItemWithCost) Validate() error {
+	return validation.ValidateStruct(
+		&item,
+		validation.Field(
+			&item.ItemName,
+			validation.Required,
+		),
+		validation.Field(
+			&item.Quantity,
+			validation.Required,
+			validation.Min(1),
+		),
+		validation.Field(
+			&item.Cost,
+			validation.Min(0),
+		),
+		validation.Field(
+			&item.Discount,
+			validation.Min(0),
+		),
+	)
+}
+
+// Validate the request to subscribe to webhooks from user input.
+func (req SubscribeWebhookRequest) Validate() error {
+	return validation.ValidateStruct(
+		&req,
+		// Only http and https URLs can receive deliveries.
+		validation.Field(
+			&req.URL,
+			validation.Required,
+			is.URL,
+			validation.Match(regexp.MustCompile(`^https?://`)),
+		),
+		validation.Field(
+			&req.Events,
+			validation.Required,
+			validation.Each(validation.In(eventTypes...)),
+		),
+	)
+}
+
+// Validate the request to remove a webhook subscription from user input.
+func (req RemoveWebhookRequest) Validate() error {
+	return validation.ValidateStruct(
+		&req,
+		validation.Field(
+			&req.ID,
+			validation.Required,
+		),
+	)
+}
@@ -0,0 +1,717 @@
+package aetest
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	uuid "github.com/satori/go.uuid"
+)
+
+// eventTypes are the event types that can be subscribed to.
+var eventTypes = []interface{}{
+	EventOrderCreated, EventOrderCancelled, EventOrderImported,
+	EventPriceChanged, EventItemRemoved,
+}
+
+// Headers sent with every webhook delivery. The signature is the hex encoded
+// HMAC-SHA256 of the timestamp, a full stop and the request body, keyed by the
+// subscription's secret and prefixed with "sha256=".
+const (
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+var (
+	// ErrWebhookNotFound is returned when a webhook subscription does not
+	// exist.
+	ErrWebhookNotFound = errors.New("webhook subscription not found")
+
+	// ErrWebhookHostNotAllowed is returned when a subscription's URL is not
+	// on one of the allowed hosts.
+	ErrWebhookHostNotAllowed = errors.New("webhook host not allowed")
+
+	// ErrWebhooksClosed is returned when deliveries are replayed after the
+	// Webhooks have been closed.
+	ErrWebhooksClosed = errors.New("webhooks closed")
+
+	// ErrWebhookQueueFull is returned when an event's deliveries cannot be
+	// queued because the queue is full, none of them are queued.
+	ErrWebhookQueueFull = errors.New("webhook queue full")
+)
+
+// errPrivateAddress is returned when a delivery would connect to an address
+// that is not on the public internet.
+var errPrivateAddress = errors.New("webhook address is not public")
+
+// WebhookConfig configures the delivery of webhooks, zero values use the
+// defaults.
+type WebhookConfig struct {
+	// MaxAttempts is the number of times a delivery is attempted before it
+	// is dead-lettered, defaulting to 5.
+	MaxAttempts int
+
+	// InitialBackoff is the wait before the first retry, it doubles after
+	// every failed attempt up to MaxBackoff. Defaults to 1s and 1m.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// AllowedHosts are the hosts subscriptions may be made to, a host
+	// starting with "*." also allows its subdomains. No subscriptions are
+	// accepted when it is empty, so that anyone reaching the API cannot
+	// have the server send requests wherever they like.
+	AllowedHosts []string
+
+	// Client sends the deliveries, defaulting to a client with a 10 second
+	// timeout that refuses to connect to loopback, private and link-local
+	// addresses, whatever the host's name resolves to. AllowPrivateNetworks
+	// lifts that restriction, for development and tests.
+	Client               *http.Client
+	AllowPrivateNetworks bool
+
+	// MaxDeadLetters is the number of failed deliveries kept, the oldest is
+	// dropped to make room for another. Defaults to 1000.
+	MaxDeadLetters int
+
+	// Workers is the number of deliveries made at once, defaulting to 4.
+	// QueueSize is the number of deliveries waiting for a worker before
+	// further events are refused, defaulting to 1000.
+	Workers   int
+	QueueSize int
+
+	// Logger logs failed deliveries, nothing is logged by default.
+	Logger *slog.Logger
+}
+
+// Webhooks delivers the events relayed from the Outbox to subscribed URLs, it
+// is an EventPublisher. Deliveries are queued and made by a fixed number of
+// workers, so that a slow subscriber does not hold back the relay until the
+// queue is full. An event whose deliveries do not fit in the queue is refused
+// and stays in the outbox. Failed attempts are retried with exponential
+// backoff and deliveries that fail every attempt are kept in a dead-letter
+// list from which they can be replayed.
+type Webhooks struct {
+	config WebhookConfig
+
+	mu            sync.Mutex
+	subscriptions map[string]WebhookSubscription
+	secrets       map[string]string
+	dead_letters  map[string]WebhookDelivery
+
+	// queue holds the deliveries waiting for a worker, it is only sent to
+	// under mu while closed is unset.
+	queue chan WebhookDelivery
+
+	// stop is closed by Close, pending retries are abandoned. closed is set
+	// at the same time under mu, and the queue closed so that the workers in
+	// wg return once it is drained.
+	stop   chan struct{}
+	closed bool
+	wg     sync.WaitGroup
+
+	// abandon is closed when Close gives up waiting, queued deliveries are
+	// dead-lettered without being attempted.
+	abandon      chan struct{}
+	abandon_once sync.Once
+}
+
+// NewWebhooks creates a Webhooks with no subscriptions.
+func NewWebhooks(config WebhookConfig) *Webhooks {
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 5
+	}
+	if config.InitialBackoff <= 0 {
+		config.InitialBackoff = time.Second
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = time.Minute
+	}
+	if config.MaxDeadLetters <= 0 {
+		config.MaxDeadLetters = 1000
+	}
+	if config.Workers <= 0 {
+		config.Workers = 4
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 1000
+	}
+	if config.Client == nil {
+		config.Client = newWebhookClient(config.AllowPrivateNetworks)
+	}
+	if config.Logger == nil {
+		config.Logger = discardLogger
+	}
+	config.Logger = withRequestID(config.Logger)
+
+	w := &Webhooks{
+		config:        config,
+		subscriptions: make(map[string]WebhookSubscription),
+		secrets:       make(map[string]string),
+		dead_letters:  make(map[string]WebhookDelivery),
+		queue:         make(chan WebhookDelivery, config.QueueSize),
+		stop:          make(chan struct{}),
+		abandon:       make(chan struct{}),
+	}
+	for i := 0; i < config.Workers; i++ {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			w.work()
+		}()
+	}
+	return w
+}
+
+// Subscribe adds a webhook subscription. The returned subscription includes
+// its secret, which is never returned again.
+func (w *Webhooks) Subscribe(req SubscribeWebhookRequest) (WebhookSubscription, error) {
+	if err := req.Validate(); err != nil {
+		return WebhookSubscription{}, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+	if err := w.checkHost(req.URL); err != nil {
+		return WebhookSubscription{}, err
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return WebhookSubscription{}, err
+		}
+		secret = hex.EncodeToString(key)
+	}
+
+	subscription := WebhookSubscription{
+		ID:        uuid.NewV4().String(),
+		URL:       req.URL,
+		Events:    append([]string{}, req.Events...),
+		CreatedAt: time.Now().UTC(),
+	}
+
+	w.mu.Lock()
+	w.subscriptions[subscription.ID] = subscription
+	w.secrets[subscription.ID] = secret
+	w.mu.Unlock()
+
+	subscription.Secret = secret
+	return subscription, nil
+}
+
+// checkHost returns ErrWebhookHostNotAllowed unless the URL's host is one of
+// the allowed hosts.
+func (w *Webhooks) checkHost(raw_url string) error {
+	parsed, err := url.Parse(raw_url)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+	for _, allowed := range w.config.AllowedHosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed {
+			return nil
+		}
+		if suffix, ok := strings.CutPrefix(allowed, "*"); ok && strings.HasSuffix(host, suffix) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q", ErrWebhookHostNotAllowed, host)
+}
+
+// newWebhookClient returns the default client deliveries are sent with. The
+// address is checked when each connection is dialed, after the name has been
+// resolved, so that neither a name resolving to a private address nor a
+// redirect to one reaches the internal network.
+func newWebhookClient(allow_private bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !allow_private {
+		dialer.Control = func(network string, address string, _ syscall.RawConn) error {
+			addr, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !publicAddress(addr.Addr()) {
+				return fmt.Errorf("%w: %s", errPrivateAddress, addr.Addr())
+			}
+			return nil
+		}
+	}
+
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			// A proxy from the environment would be dialed instead of the
+			// subscriber, deliveries are always made directly.
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, which is not routable on
+// the public internet either.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicAddress reports whether the address is on the public internet,
+// rather than loopback, private, link-local (such as the cloud metadata
+// service at 169.254.169.254), multicast or unspecified.
+func publicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() &&
+		!addr.IsPrivate() &&
+		!sharedAddressSpace.Contains(addr)
+}
+
+// Unsubscribe removes a webhook subscription, its pending and failed
+// deliveries are dropped.
+func (w *Webhooks) Unsubscribe(req RemoveWebhookRequest) error {
+	if err := req.Validate(); err != nil {
+		return ErrInvalidRequest
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.subscriptions[req.ID]; !ok {
+		return ErrWebhookNotFound
+	}
+	delete(w.subscriptions, req.ID)
+	delete(w.secrets, req.ID)
+	for id, delivery := range w.dead_letters {
+		if delivery.SubscriptionID == req.ID {
+			delete(w.dead_letters, id)
+		}
+	}
+
+	return nil
+}
+
+// Subscriptions returns every webhook subscription, oldest first, without
+// their secrets.
+func (w *Webhooks) Subscriptions() WebhookSubscriptions {
+	w.mu.Lock()
+	subscriptions := make([]WebhookSubscription, 0, len(w.subscriptions))
+	for _, subscription := range w.subscriptions {
+		subscriptions = append(subscriptions, subscription)
+	}
+	w.mu.Unlock()
+
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].CreatedAt.Before(subscriptions[j].CreatedAt)
+	})
+	return WebhookSubscriptions{subscriptions}
+}
+
+// FailedDeliveries returns the dead-lettered deliveries, oldest first.
+func (w *Webhooks) FailedDeliveries() FailedDeliveries {
+	w.mu.Lock()
+	deliveries := make([]WebhookDelivery, 0, len(w.dead_letters))
+	for _, delivery := range w.dead_letters {
+		deliveries = append(deliveries, delivery)
+	}
+	w.mu.Unlock()
+
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].FailedAt.Before(deliveries[j].FailedAt)
+	})
+	return FailedDeliveries{deliveries}
+}
+
+// Replay removes the requested deliveries from the dead-letter list and
+// delivers them again, with a fresh set of attempts. Every failed delivery
+// is replayed when no IDs are given. Nothing is replayed, and
+// ErrWebhookQueueFull returned, when the deliveries do not fit in the queue.
+func (w *Webhooks) Replay(req ReplayDeliveriesRequest) (ReplayDeliveriesResponse, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ReplayDeliveriesResponse{}, ErrWebhooksClosed
+	}
+
+	ids := req.DeliveryIDs
+	if len(ids) == 0 {
+		for id := range w.dead_letters {
+			ids = append(ids, id)
+		}
+	}
+
+	// Every requested delivery must exist, so that a typo does not replay
+	// only some of them.
+	for _, id := range ids {
+		if _, ok := w.dead_letters[id]; !ok {
+			return ReplayDeliveriesResponse{}, fmt.Errorf(
+				"%w: delivery %q is not a failed delivery", ErrInvalidRequest, id,
+			)
+		}
+	}
+
+	if !w.hasRoom(len(ids)) {
+		return ReplayDeliveriesResponse{}, ErrWebhookQueueFull
+	}
+	for _, id := range ids {
+		delivery := w.dead_letters[id]
+		delete(w.dead_letters, id)
+		w.deliver(delivery)
+	}
+
+	return ReplayDeliveriesResponse{Replayed: len(ids)}, nil
+}
+
+// Publish delivers the event to every subscription to its type. It returns
+// once the deliveries have been queued, they are made in the background.
+// Events are refused with ErrWebhookQueueFull while their deliveries do not
+// fit in the queue, and with ErrWebhooksClosed once closed, so that they stay
+// in the outbox.
+func (w *Webhooks) Publish(ctx context.Context, event Event) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrWebhooksClosed
+	}
+
+	var subscriptions []WebhookSubscription
+	for _, subscription := range w.subscriptions {
+		if subscribedTo(subscription, event.Type) {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	if !w.hasRoom(len(subscriptions)) {
+		return ErrWebhookQueueFull
+	}
+
+	for _, subscription := range subscriptions {
+		w.deliver(WebhookDelivery{
+			ID:             uuid.NewV4().String(),
+			SubscriptionID: subscription.ID,
+			URL:            subscription.URL,
+			Event:          event,
+		})
+	}
+	return nil
+}
+
+// Close abandons pending retries and waits, until ctx is done, for deliveries
+// in progress and those queued to be attempted. Deliveries still queued when
+// ctx is done are abandoned too, abandoned deliveries are dead-lettered.
+func (w *Webhooks) Close(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.stop)
+		close(w.queue)
+	}
+	w.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		w.abandon_once.Do(func() { close(w.abandon) })
+		return ctx.Err()
+	}
+}
+
+func subscribedTo(subscription WebhookSubscription, event_type string) bool {
+	for _, subscribed := range subscription.Events {
+		if subscribed == event_type {
+			return true
+		}
+	}
+	return false
+}
+
+// hasRoom reports whether n more deliveries fit in the queue, the caller
+// holds w.mu.
+func (w *Webhooks) hasRoom(n int) bool {
+	return len(w.queue)+n <= cap(w.queue)
+}
+
+// deliver queues a delivery, the caller holds w.mu and has checked that the
+// Webhooks are not closed and that the queue has room.
+func (w *Webhooks) deliver(delivery WebhookDelivery) {
+	delivery.Attempts = 0
+	delivery.LastError = ""
+	w.queue <- delivery
+}
+
+// work makes queued deliveries until the queue is closed.
+func (w *Webhooks) work() {
+	for delivery := range w.queue {
+		select {
+		case <-w.abandon:
+			w.deadLetter(delivery, errors.New("shut down before sending"))
+			continue
+		default:
+		}
+		w.attempt(delivery)
+	}
+}
+
+// attempt makes every attempt at a delivery, waiting between attempts, and
+// dead-letters the delivery if none succeed.
+func (w *Webhooks) attempt(delivery WebhookDelivery) {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		w.deadLetter(delivery, err)
+		return
+	}
+
+	backoff := w.config.InitialBackoff
+	for {
+		delivery.Attempts++
+		err = w.send(delivery, body)
+		if err == nil {
+			return
+		}
+		if delivery.Attempts >= w.config.MaxAttempts {
+			break
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-w.stop:
+			timer.Stop()
+			w.deadLetter(delivery, fmt.Errorf("shut down before retrying: %w", err))
+			return
+		}
+		backoff = min(backoff*2, w.config.MaxBackoff)
+	}
+
+	w.deadLetter(delivery, err)
+}
+
+// send makes a single attempt at a delivery, any response other than 2xx is
+// a failure.
+func (w *Webhooks) send(delivery WebhookDelivery, body []byte) error {
+	w.mu.Lock()
+	secret, ok := w.secrets[delivery.SubscriptionID]
+	w.mu.Unlock()
+	if !ok {
+		// Unsubscribed since the event was published.
+		return nil
+	}
+
+	request, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(WebhookEventHeader, delivery.Event.Type)
+	request.Header.Set(WebhookDeliveryHeader, delivery.ID)
+	request.Header.Set(WebhookTimestampHeader, timestamp)
+	request.Header.Set(WebhookSignatureHeader, SignWebhook(secret, timestamp, body))
+
+	response, err := w.config.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("subscriber responded with %s", response.Status)
+	}
+	return nil
+}
+
+func (w *Webhooks) deadLetter(delivery WebhookDelivery, err error) {
+	delivery.LastError = err.Error()
+	delivery.FailedAt = time.Now().UTC()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// Deliveries to a subscription removed in the meantime are dropped.
+	if _, ok := w.subscriptions[delivery.SubscriptionID]; !ok {
+		return
+	}
+	if len(w.dead_letters) >= w.config.MaxDeadLetters {
+		w.dropOldestDeadLetter()
+	}
+	w.dead_letters[delivery.ID] = delivery
+
+	w.config.Logger.Warn(
+		"webhook delivery failed",
+		"delivery_id", delivery.ID,
+		"subscription_id", delivery.SubscriptionID,
+		"event_type", delivery.Event.Type,
+		"attempts", delivery.Attempts,
+		"error", err,
+	)
+}
+
+// dropOldestDeadLetter makes room for another failed delivery, the caller
+// holds w.mu.
+func (w *Webhooks) dropOldestDeadLetter() {
+	var oldest WebhookDelivery
+	for _, delivery := range w.dead_letters {
+		if oldest.ID == "" || delivery.FailedAt.Before(oldest.FailedAt) {
+			oldest = delivery
+		}
+	}
+	delete(w.dead_letters, oldest.ID)
+
+	w.config.Logger.Warn(
+		"failed webhook delivery dropped",
+		"delivery_id", oldest.ID,
+		"subscription_id", oldest.SubscriptionID,
+		"max_dead_letters", w.config.MaxDeadLetters,
+	)
+}
+
+// SignWebhook returns the signature of a webhook delivery's body, as sent in
+// the `X-Webhook-Signature` header.
+func SignWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook reports whether the signature of a webhook delivery is valid
+// for the secret. Receivers should also reject old timestamps to prevent
+// deliveries being replayed by a third party.
+func VerifyWebhook(secret string, timestamp string, body []byte, signature string) bool {
+	expected := SignWebhook(secret, timestamp, body)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// WithWebhooks serves the webhook admin endpoints.
+func WithWebhooks(w *Webhooks) RouterOption {
+	return func(router *gin.Engine) {
+		router.POST("/subscribe-webhook", func(c *gin.Context) {
+			var request SubscribeWebhookRequest
+
+			if err := c.ShouldBindJSON(&request); err != nil {
+				c.JSON(http.StatusBadRequest, GenericErrResponse{
+					Err: err.Error(),
+				})
+				return
+			}
+
+			response, err := w.Subscribe(request)
+			if err != nil {
+				// The URL is valid but not on an allowed host, respond
+				// with 403
+				if errors.Is(err, ErrWebhookHostNotAllowed) {
+					c.JSON(http.StatusForbidden, GenericErrResponse{
+						Err: err.Error(),
+					})
+					return
+				}
+
+				c.JSON(http.StatusBadRequest, GenericErrResponse{
+					Err: err.Error(),
+				})
+				return
+			}
+
+			c.JSON(http.StatusOK, response)
+		})
+
+		router.GET("/get-webhooks", func(c *gin.Context) {
+			c.JSON(http.StatusOK, w.Subscriptions())
+		})
+
+		router.POST("/remove-webhook", func(c *gin.Context) {
+			var request RemoveWebhookRequest
+
+			if err := c.ShouldBindJSON(&request); err != nil {
+				c.JSON(http.StatusBadRequest, GenericErrResponse{
+					Err: err.Error(),
+				})
+				return
+			}
+
+			if err := w.Unsubscribe(request); err != nil {
+				// Malformed request, respond with 400
+				if ok := errors.Is(err, ErrInvalidRequest); ok {
+					c.JSON(http.StatusBadRequest, GenericErrResponse{
+						Err: err.Error(),
+					})
+					return
+				}
+
+				// Subscription not found, respond with 404
+				c.JSON(http.StatusNotFound, GenericErrResponse{
+					Err: err.Error(),
+				})
+				return
+			}
+
+			c.Status(http.StatusNoContent)
+		})
+
+		router.GET("/get-failed-deliveries", func(c *gin.Context) {
+			c.JSON(http.StatusOK, w.FailedDeliveries())
+		})
+
+		router.POST("/replay-deliveries", func(c *gin.Context) {
+			var request ReplayDeliveriesRequest
+
+			// An empty body replays every failed delivery.
+			if c.Request.ContentLength != 0 {
+				if err := c.ShouldBindJSON(&request); err != nil {
+					c.JSON(http.StatusBadRequest, GenericErrResponse{
+						Err: err.Error(),
+					})
+					return
+				}
+			}
+
+			response, err := w.Replay(request)
+			if err != nil {
+				// Shutting down or too busy, respond with 503
+				if errors.Is(err, ErrWebhooksClosed) || errors.Is(err, ErrWebhookQueueFull) {
+					c.JSON(http.StatusServiceUnavailable, GenericErrResponse{
+						Err: err.Error(),
+					})
+					return
+				}
+
+				c.JSON(http.StatusBadRequest, GenericErrResponse{
+					Err: err.Error(),
+				})
+				return
+			}
+
+			// The deliveries are made in the background.
+			c.JSON(http.StatusAccepted, response)
+		})
+	}
+}
@@ -0,0 +1,395 @@
+package aetest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// webhookReceiver is an httptest server that records the webhook deliveries
+// it receives, failing the first fail_first of them.
+type webhookReceiver struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	fail_first int
+	calls      int
+	received   []receivedWebhook
+}
+
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+func newWebhookReceiver(t *testing.T, fail_first int) *webhookReceiver {
+	receiver := &webhookReceiver{fail_first: fail_first}
+	receiver.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		receiver.mu.Lock()
+		defer receiver.mu.Unlock()
+		receiver.calls++
+		if receiver.calls <= receiver.fail_first {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		receiver.received = append(receiver.received, receivedWebhook{r.Header.Clone(), body})
+	}))
+	t.Cleanup(receiver.Close)
+	return receiver
+}
+
+func (receiver *webhookReceiver) deliveries() []receivedWebhook {
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	return append([]receivedWebhook{}, receiver.received...)
+}
+
+func (receiver *webhookReceiver) setFailFirst(n int) {
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	receiver.calls = 0
+	receiver.fail_first = n
+}
+
+func newTestWebhooks(t *testing.T) *Webhooks {
+	webhooks := NewWebhooks(WebhookConfig{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     5 * time.Millisecond,
+
+		// The receivers are httptest servers on the loopback address.
+		AllowedHosts:         []string{"127.0.0.1", "*.example.com"},
+		AllowPrivateNetworks: true,
+	})
+	t.Cleanup(func() { webhooks.Close(context.Background()) })
+	return webhooks
+}
+
+func TestWebhookSignedDelivery(t *testing.T) {
+	receiver := newWebhookReceiver(t, 0)
+	webhooks := newTestWebhooks(t)
+
+	subscription, err := webhooks.Subscribe(SubscribeWebhookRequest{
+		URL:    receiver.URL,
+		Events: []string{EventOrderCreated},
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, subscription.Secret, "a secret is generated")
+	require.Empty(t, webhooks.Subscriptions().Subscriptions[0].Secret, "secrets are never listed")
+
+	// Webhooks are delivered from the outbox by their own relay.
+	outbox := NewOutbox()
+	relay, err := NewRelay(outbox, webhooks, RelayConfig{Name: "webhooks"})
+	require.NoError(t, err)
+	items, discounts, orders := NewStore()
+	svc := New(items, discounts, orders, WithOutbox(outbox))
+	request := goodOrderRequest
+	request.Email = "customer@example.com"
+	summary, err := svc.SimpleSummary(context.Background(), request)
+	require.NoError(t, err)
+	require.NoError(t, relay.Flush(context.Background()))
+
+	require.Eventually(t, func() bool { return len(receiver.deliveries()) == 1 }, time.Second, time.Millisecond)
+	delivery := receiver.deliveries()[0]
+	require.Equal(t, EventOrderCreated, delivery.header.Get(WebhookEventHeader))
+	require.True(t, VerifyWebhook(
+		subscription.Secret,
+		delivery.header.Get(WebhookTimestampHeader),
+		delivery.body,
+		delivery.header.Get(WebhookSignatureHeader),
+	))
+	require.False(t, VerifyWebhook(
+		"wrong secret",
+		delivery.header.Get(WebhookTimestampHeader),
+		delivery.body,
+		delivery.header.Get(WebhookSignatureHeader),
+	))
+
+	var event Event
+	require.NoError(t, json.Unmarshal(delivery.body, &event))
+	require.Equal(t, EventOrderCreated, event.Type)
+	require.Equal(t, summary.OrderID, event.Order.OrderID)
+	require.NotContains(t, string(delivery.body), "customer@example.com")
+
+	// Quotes are not orders, nothing is sent. Events not subscribed to are
+	// not sent either.
+	_, err = svc.Quote(context.Background(), goodOrderRequest)
+	require.NoError(t, err)
+	_, err = svc.SetItemPrice(context.Background(), SetItemPriceRequest{SKU: "FRUIT-001", Cost: 65})
+	require.NoError(t, err)
+	require.NoError(t, relay.Flush(context.Background()))
+	require.NoError(t, webhooks.Close(context.Background()))
+	require.Len(t, receiver.deliveries(), 1)
+
+	// Once closed, events stay in the outbox.
+	_, err = svc.CancelOrder(context.Background(), CancelOrderRequest{summary.OrderID})
+	require.NoError(t, err)
+	require.ErrorIs(t, relay.Flush(context.Background()), ErrWebhooksClosed)
+	requirePending(t, outbox, 1)
+}
+
+func TestWebhookRetries(t *testing.T) {
+	// The first two attempts fail, the third succeeds.
+	receiver := newWebhookReceiver(t, 2)
+	webhooks := newTestWebhooks(t)
+	_, err := webhooks.Subscribe(SubscribeWebhookRequest{
+		URL:    receiver.URL,
+		Events: []string{EventOrderCreated},
+		Secret: "secret",
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, webhooks.Publish(context.Background(), Event{ID: "event-1", Type: EventOrderCreated}))
+	require.Eventually(t, func() bool { return len(receiver.deliveries()) == 1 }, time.Second, time.Millisecond)
+	require.NoError(t, webhooks.Close(context.Background()))
+	require.Empty(t, webhooks.FailedDeliveries().Deliveries)
+}
+
+func TestWebhookDeadLetterAndReplay(t *testing.T) {
+	// Every attempt fails.
+	receiver := newWebhookReceiver(t, 3)
+	webhooks := newTestWebhooks(t)
+	_, err := webhooks.Subscribe(SubscribeWebhookRequest{
+		URL:    receiver.URL,
+		Events: []string{EventOrderCreated},
+	})
+	require.NoError(t, err)
+	router := NewOrdersRouter(service, WithWebhooks(webhooks))
+
+	require.NoError(t, webhooks.Publish(context.Background(), Event{ID: "event-1", Type: EventOrderCreated}))
+	require.Eventually(t, func() bool {
+		return len(webhooks.FailedDeliveries().Deliveries) == 1
+	}, time.Second, time.Millisecond)
+
+	var failed FailedDeliveries
+	require.Equal(t, http.StatusOK, getJSON(t, router, "/get-failed-deliveries", &failed))
+	require.Len(t, failed.Deliveries, 1)
+	require.Equal(t, 3, failed.Deliveries[0].Attempts)
+	require.Contains(t, failed.Deliveries[0].LastError, "503")
+	require.Equal(t, "event-1", failed.Deliveries[0].Event.ID)
+
+	// Unknown deliveries cannot be replayed.
+	replay := func(req ReplayDeliveriesRequest) *httptest.ResponseRecorder {
+		body, err := json.Marshal(req)
+		require.NoError(t, err)
+		request := httptest.NewRequest("POST", "/replay-deliveries", bytes.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, request)
+		return rec
+	}
+	rec := replay(ReplayDeliveriesRequest{DeliveryIDs: []string{"unknown"}})
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// The receiver has recovered, the replayed delivery succeeds.
+	receiver.setFailFirst(0)
+	rec = replay(ReplayDeliveriesRequest{DeliveryIDs: []string{failed.Deliveries[0].ID}})
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	require.JSONEq(t, `{"replayed":1}`, rec.Body.String())
+
+	require.NoError(t, webhooks.Close(context.Background()))
+	require.Len(t, receiver.deliveries(), 1)
+	require.Empty(t, webhooks.FailedDeliveries().Deliveries)
+}
+
+func TestSubscribeWebhookValidation(t *testing.T) {
+	webhooks := newTestWebhooks(t)
+
+	testCases := []struct {
+		name string
+		req  SubscribeWebhookRequest
+	}{
+		{"no url", SubscribeWebhookRequest{Events: []string{EventOrderCreated}}},
+		{"not http", SubscribeWebhookRequest{URL: "ftp://example.com", Events: []string{EventOrderCreated}}},
+		{"no events", SubscribeWebhookRequest{URL: "https://hooks.example.com"}},
+		{"unknown event", SubscribeWebhookRequest{URL: "https://hooks.example.com", Events: []string{"order.eaten"}}},
+	}
+
+	for _, tc := range testCases {
+		_, err := webhooks.Subscribe(tc.req)
+		require.ErrorIsf(t, err, ErrInvalidRequest, "case: %v", tc.name)
+	}
+	require.Empty(t, webhooks.Subscriptions().Subscriptions)
+
+	require.ErrorIs(t, webhooks.Unsubscribe(RemoveWebhookRequest{ID: "unknown"}), ErrWebhookNotFound)
+}
+
+func TestWebhookAllowedHosts(t *testing.T) {
+	webhooks := newTestWebhooks(t)
+	router := NewOrdersRouter(service, WithWebhooks(webhooks))
+
+	_, err := webhooks.Subscribe(SubscribeWebhookRequest{
+		URL:    "https://Hooks.Example.com/orders",
+		Events: []string{EventOrderCreated},
+	})
+	require.NoError(t, err)
+
+	// Neither the parent of a wildcard nor other hosts are allowed.
+	for _, url := range []string{
+		"https://example.com",
+		"http://169.254.169.254/latest/meta-data",
+		"http://localhost:3000",
+	} {
+		response := postJSON(t, router, "/subscribe-webhook", SubscribeWebhookRequest{
+			URL:    url,
+			Events: []string{EventOrderCreated},
+		})
+		require.Equal(t, http.StatusForbidden, response.StatusCode, url)
+	}
+	require.Len(t, webhooks.Subscriptions().Subscriptions, 1)
+
+	// No hosts are allowed by default.
+	_, err = NewWebhooks(WebhookConfig{}).Subscribe(SubscribeWebhookRequest{
+		URL:    "https://hooks.example.com",
+		Events: []string{EventOrderCreated},
+	})
+	require.ErrorIs(t, err, ErrWebhookHostNotAllowed)
+}
+
+func TestWebhookPrivateAddresses(t *testing.T) {
+	// The loopback host is allowed, but the default client refuses to
+	// connect to it.
+	receiver := newWebhookReceiver(t, 0)
+	webhooks := NewWebhooks(WebhookConfig{
+		MaxAttempts:  1,
+		AllowedHosts: []string{"127.0.0.1"},
+	})
+	defer webhooks.Close(context.Background())
+	_, err := webhooks.Subscribe(SubscribeWebhookRequest{
+		URL:    receiver.URL,
+		Events: []string{EventOrderCreated},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, webhooks.Publish(context.Background(), Event{ID: "event-1", Type: EventOrderCreated}))
+	require.Eventually(t, func() bool {
+		return len(webhooks.FailedDeliveries().Deliveries) == 1
+	}, time.Second, time.Millisecond)
+	require.Contains(t, webhooks.FailedDeliveries().Deliveries[0].LastError, "not public")
+	require.Empty(t, receiver.deliveries())
+
+	for _, addr := range []string{"127.0.0.1", "10.0.0.1", "192.168.1.1", "169.254.169.254", "100.64.0.1", "::1", "fe80::1", "::ffff:127.0.0.1", "0.0.0.0"} {
+		require.False(t, publicAddress(netip.MustParseAddr(addr)), addr)
+	}
+	for _, addr := range []string{"93.184.216.34", "2606:2800:220:1::1"} {
+		require.True(t, publicAddress(netip.MustParseAddr(addr)), addr)
+	}
+}
+
+func TestWebhookClosed(t *testing.T) {
+	receiver := newWebhookReceiver(t, 0)
+	webhooks := newTestWebhooks(t)
+	_, err := webhooks.Subscribe(SubscribeWebhookRequest{
+		URL:    receiver.URL,
+		Events: []string{EventOrderCreated},
+	})
+	require.NoError(t, err)
+
+	// Publishing while closing never races with the wait for deliveries,
+	// events published once closed are refused.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			webhooks.Publish(context.Background(), Event{ID: "event", Type: EventOrderCreated})
+		}()
+	}
+	require.NoError(t, webhooks.Close(context.Background()))
+	wg.Wait()
+	delivered := len(receiver.deliveries())
+
+	err = webhooks.Publish(context.Background(), Event{ID: "late", Type: EventOrderCreated})
+	require.ErrorIs(t, err, ErrWebhooksClosed)
+	_, err = webhooks.Replay(ReplayDeliveriesRequest{})
+	require.ErrorIs(t, err, ErrWebhooksClosed)
+	require.Len(t, receiver.deliveries(), delivered)
+}
+
+func TestWebhookQueueFull(t *testing.T) {
+	ctx := context.Background()
+
+	// The subscriber holds every delivery until it is released.
+	arrived := make(chan struct{}, 10)
+	release := make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		<-release
+	}))
+	t.Cleanup(receiver.Close)
+	webhooks := NewWebhooks(WebhookConfig{
+		Workers:              1,
+		QueueSize:            1,
+		AllowedHosts:         []string{"127.0.0.1"},
+		AllowPrivateNetworks: true,
+	})
+	_, err := webhooks.Subscribe(SubscribeWebhookRequest{
+		URL:    receiver.URL,
+		Events: []string{EventOrderCreated},
+	})
+	require.NoError(t, err)
+
+	// One delivery is in progress and one queued, the next event is
+	// refused and stays in the outbox.
+	require.NoError(t, webhooks.Publish(ctx, Event{ID: "event-1", Type: EventOrderCreated}))
+	<-arrived
+	require.NoError(t, webhooks.Publish(ctx, Event{ID: "event-2", Type: EventOrderCreated}))
+
+	outbox := NewOutbox()
+	relay, err := NewRelay(outbox, webhooks, RelayConfig{Name: "webhooks"})
+	require.NoError(t, err)
+	items, discounts, orders := NewStore()
+	svc := New(items, discounts, orders, WithOutbox(outbox))
+	_, err = svc.SimpleSummary(ctx, goodOrderRequest)
+	require.NoError(t, err)
+	require.ErrorIs(t, relay.Flush(ctx), ErrWebhookQueueFull)
+	requirePending(t, outbox, 1)
+
+	// Once the subscriber catches up there is room again.
+	close(release)
+	require.Eventually(t, func() bool { return relay.Flush(ctx) == nil }, time.Second, time.Millisecond)
+	requirePending(t, outbox, 0)
+	require.NoError(t, webhooks.Close(ctx))
+	require.Len(t, arrived, 2)
+}
+
+func TestWebhookMaxDeadLetters(t *testing.T) {
+	receiver := newWebhookReceiver(t, 100)
+	webhooks := NewWebhooks(WebhookConfig{
+		MaxAttempts:          1,
+		MaxDeadLetters:       2,
+		AllowedHosts:         []string{"127.0.0.1"},
+		AllowPrivateNetworks: true,
+	})
+	_, err := webhooks.Subscribe(SubscribeWebhookRequest{
+		URL:    receiver.URL,
+		Events: []string{EventOrderCreated},
+	})
+	require.NoError(t, err)
+
+	// Only the two most recent failures are kept.
+	for _, id := range []string{"event-1", "event-2", "event-3"} {
+		require.NoError(t, webhooks.Publish(context.Background(), Event{ID: id, Type: EventOrderCreated}))
+		require.Eventually(t, func() bool {
+			deliveries := webhooks.FailedDeliveries().Deliveries
+			return len(deliveries) > 0 && deliveries[len(deliveries)-1].Event.ID == id
+		}, time.Second, time.Millisecond)
+	}
+	require.NoError(t, webhooks.Close(context.Background()))
+
+	deliveries := webhooks.FailedDeliveries().Deliveries
+	require.Len(t, deliveries, 2)
+	require.Equal(t, "event-2", deliveries[0].Event.ID)
+	require.Equal(t, "event-3", deliveries[1].Event.ID)
+}